@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"time"
+)
+
+// backup writes an archive of the database:
+//
+//	backup [FILE]
+//
+// The archive goes to stdout when FILE is missing or -.
+func backup(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("backup")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing backup flags")
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if len(args) > 0 && args[0] != "-" {
+		if file, err = os.Create(args[0]); err != nil {
+			return errors.Wrap(err, "creating archive")
+		}
+		defer file.Close()
+		out = file
+	}
+
+	summary, err := schema.Backup(context.Background(), db, out, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return errors.Wrap(err, "closing archive")
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Backed up", summary)
+	return nil
+}
+
+// restore loads an archive into an empty database:
+//
+//	restore FILE [--force]
+//
+// A database without any migration is first migrated to the version of the
+// archive; run migrate up afterwards to bring it up to date. Otherwise the
+// archive must be at the schema version of the database unless --force is
+// given.
+func restore(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("restore")
+	force := fs.Bool("force", false, "restore even if the schema versions differ")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing restore flags")
+	}
+
+	if len(args) != 1 {
+		return errors.New("restore must be called with an archive")
+	}
+
+	var in io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return errors.Wrap(err, "opening archive")
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	opts := schema.RestoreOptions{Migrate: true, Force: *force}
+	summary, err := schema.Restore(context.Background(), db, in, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Restored", summary)
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
+	"github.com/pkg/errors"
+)
+
+// configCmd runs the config subcommands:
+//
+//	config print
+//
+// print shows the effective configuration after the config file, the
+// environment and the flags are applied. Secrets are redacted.
+func configCmd(cfg *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("config must be called with print")
+	}
+
+	out, err := cfg.String()
+	if err != nil {
+		return errors.Wrap(err, "rendering config")
+	}
+	fmt.Println(out)
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+)
+
+// newFlagSet constructs the flag set of a subcommand. Errors are returned to
+// the caller instead of being printed.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// parseFlags parses the flags of a subcommand wherever they appear among its
+// arguments and returns the remaining positional arguments in order.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
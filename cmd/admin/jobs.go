@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// jobsCmd runs the jobs subcommands:
+//
+//	jobs list [--kind KIND] [--status STATUS] [--limit N]
+//	jobs show ID
+//	jobs stats
+//	jobs retry ID
+//	jobs enqueue KIND [--payload JSON] [--run-at TIME] [--unique-key KEY]
+//
+// TIME is in RFC 3339. Every subcommand accepts --json to print the jobs as
+// JSON; show always does, as payloads and errors do not fit a table. Queued
+// jobs are run by the API or the workers, not by this command.
+func jobsCmd(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("jobs")
+	asJSON := fs.Bool("json", false, "print jobs as JSON")
+	kind := fs.String("kind", "", "list only the jobs of this kind")
+	status := fs.String("status", "", "list only the jobs with this status")
+	limit := fs.Int("limit", 0, "number of jobs to list, newest first")
+	payload := fs.String("payload", "", "JSON payload of a new job")
+	runAt := fs.String("run-at", "", "time to run a new job at, defaults to now")
+	uniqueKey := fs.String("unique-key", "", "key no other queued or running job may have")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing jobs flags")
+	}
+
+	if len(args) == 0 {
+		return errors.New("jobs must be called with list, show, stats, retry or enqueue")
+	}
+	cmd, args := args[0], args[1:]
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	switch cmd {
+	case "list":
+		list, err := jobs.List(ctx, db, jobs.Filter{Kind: *kind, Status: *status, Limit: *limit})
+		if err != nil {
+			return err
+		}
+		return printJobs(list, *asJSON)
+
+	case "stats":
+		stats, err := jobs.Stats(ctx, db)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(stats)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tSTATUS\tCOUNT")
+		for _, s := range stats {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", s.Kind, s.Status, s.Count)
+		}
+		return tw.Flush()
+
+	case "enqueue":
+		if len(args) != 1 {
+			return errors.New("jobs enqueue must be called with a single kind")
+		}
+		nj := jobs.NewJob{Kind: args[0], UniqueKey: *uniqueKey}
+		if *payload != "" {
+			if !json.Valid([]byte(*payload)) {
+				return errors.New("jobs enqueue payload must be JSON")
+			}
+			nj.Payload = json.RawMessage(*payload)
+		}
+		if *runAt != "" {
+			if nj.RunAt, err = time.Parse(time.RFC3339, *runAt); err != nil {
+				return errors.Wrap(err, "parsing run-at")
+			}
+		}
+
+		j, err := jobs.Enqueue(ctx, db, nj, now)
+		if err != nil {
+			return err
+		}
+		return printJobs([]jobs.Job{*j}, *asJSON)
+	}
+
+	if len(args) != 1 {
+		return errors.Errorf("jobs %s must be called with a single job ID", cmd)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errors.Errorf("invalid job ID %q", args[0])
+	}
+
+	switch cmd {
+	case "show":
+	case "retry":
+		err = jobs.Retry(ctx, db, id, now)
+	default:
+		return errors.Errorf("unknown jobs command %q", cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	j, err := jobs.Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if *asJSON || cmd == "show" {
+		return printJSON(j)
+	}
+	return printJobs([]jobs.Job{*j}, false)
+}
+
+// printJobs prints jobs as a table or as JSON. The table shows the first line
+// of their last error.
+func printJobs(list []jobs.Job, asJSON bool) error {
+	if asJSON {
+		return printJSON(list)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tATTEMPTS\tRUN AT\tLAST ERROR")
+	for _, j := range list {
+		lastError := "-"
+		if j.LastError != nil {
+			lastError = strings.SplitN(*j.LastError, "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n", j.ID, j.Kind, j.Status, j.Attempts,
+			j.RunAt.Format(time.RFC3339), lastError)
+	}
+	return tw.Flush()
+}
@@ -8,14 +8,14 @@ import (
 	"encoding/pem"
 	"fmt"
 	"github.com/ardanlabs/conf"
-	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
 	"github.com/esmaeilmirzaee/grage/internal/platform/database"
 	"github.com/esmaeilmirzaee/grage/internal/schema"
-	"github.com/esmaeilmirzaee/grage/internal/user"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -31,27 +31,17 @@ func main() {
 func run() error {
 	// =============================================================
 	// Configuration
-	var cfg struct {
-		DB struct {
-			Host       string `conf:"default:192.168.101.2:5234"`
-			Name       string `conf:"default:garage"`
-			User       string `conf:"default:pgdmn"`
-			Password   string `conf:"default:secret"`
-			DisableTLS bool   `conf:"default:true"`
-		}
-		Args conf.Args
-	}
-
-	if err := conf.Parse(os.Args[1:], "SALES | ", &cfg); err != nil {
+	cfg, args, err := config.Parse(os.Args[1:])
+	if err != nil {
 		if err == conf.ErrHelpWanted {
-			usage, err := conf.Usage("SALES | ", &cfg)
+			usage, err := config.Usage()
 			if err != nil {
 				return errors.Wrap(err, "main: generating usage")
 			}
 			fmt.Println(usage)
 			return nil
 		}
-		return errors.Wrap(err, "error: parsing")
+		return err
 	}
 
 	// This is used for multiple commands below.
@@ -63,16 +53,31 @@ func run() error {
 		DisableTLS: cfg.DB.DisableTLS,
 	}
 
-	var err error
-	switch cfg.Args.Num(0) {
+	switch args.Num(0) {
+	case "config":
+		err = configCmd(cfg, args[1:])
 	case "migrate":
-		err = migrate(dbConfig)
+		err = migrate(dbConfig, args[1:])
+	case "schema":
+		err = schemaCmd(dbConfig, args[1:])
 	case "seed":
-		err = seed(dbConfig)
-	case "useradd":
-		err = useradd(dbConfig, cfg.Args.Num(1))
+		err = seed(dbConfig, args[1:])
+	case "user":
+		err = userCmd(dbConfig, args[1:])
+	case "backup":
+		err = backup(dbConfig, args[1:])
+	case "restore":
+		err = restore(dbConfig, args[1:])
+	case "products":
+		err = productsCmd(dbConfig, args[1:])
+	case "jobs":
+		err = jobsCmd(dbConfig, args[1:])
+	case "token":
+		err = tokenCmd(cfg.Auth, args[1:])
+	case "keys":
+		err = keysCmd(cfg.Auth, args[1:])
 	case "keygen":
-		err = keygen(cfg.Args.Num(1))
+		err = keygen(args.Num(1))
 	case "uuid":
 		var newUUID uuid.UUID
 		for i := 0; i < 10; i++ {
@@ -91,84 +96,44 @@ func run() error {
 	return nil
 }
 
-func migrate(dbConfig database.Config) error {
-	db, err := database.Open(dbConfig)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	if err := schema.Migrate(db); err != nil {
-		return err
+// seed loads a fixture set, optionally with generated records:
+//
+//	seed [--set NAME] [--generate N]
+//
+// Seeding is idempotent; records that already exist are left alone.
+func seed(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("seed")
+	set := fs.String("set", "dev", "fixture set to load: "+strings.Join(schema.FixtureSets(), ", "))
+	generate := fs.Int("generate", -1, "number of random products to generate, defaults to the set's own number")
+	if _, err := parseFlags(fs, args); err != nil {
+		return errors.Wrap(err, "parsing seed flags")
 	}
 
-	fmt.Println("Migrating is complete")
-	return nil
-}
-
-func seed(dbConfig database.Config) error {
-	db, err := database.Open(dbConfig)
+	f, err := schema.LoadFixtures(*set)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	if err := schema.Seed(db); err != nil {
+	n := f.Generate
+	if *generate >= 0 {
+		n = *generate
+	}
+	if err := f.AddGenerated(n); err != nil {
 		return err
 	}
 
-	fmt.Println("Seeding is complete")
-	return nil
-}
-
-func useradd(dbConfig database.Config, email string) error {
 	db, err := database.Open(dbConfig)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if email == "" {
-		return errors.New("User creation must be called with an additional argument | email")
-	}
-
-	fmt.Print("Please enter password: ")
-	var password string
-	if _, err := fmt.Scanf("%v\n", &password); err != nil {
-		return errors.Wrap(err, "entering password")
-	}
-	if password == "" {
-		fmt.Println("Canceling")
-		return nil
-	}
-
-	fmt.Printf("Admin user will be created with email %q", email)
-	fmt.Printf("Continue? (Y/N)")
-	var confirm byte
-	if _, err := fmt.Scanf("%c\n", &confirm); err != nil {
-		return errors.Wrap(err, "processing response")
-	}
-
-	if string(confirm) != "y" || string(confirm) != "Y" && string(confirm) == "n" || string(confirm) == "N" {
-		fmt.Println("Canceling")
-		return nil
-	}
-
-	ctx := context.Background()
-	nu := user.NewUser{
-		Name:     email,
-		Password: password,
-		Email:    email,
-		Roles:    []string{auth.RoleAdmin, auth.RoleUser},
-	}
-
-	user, err := user.Create(ctx, db, nu, time.Now())
-	if err != nil {
+	if err := f.Apply(context.Background(), db, time.Now()); err != nil {
 		return err
 	}
 
-	fmt.Printf("User created %q", user.ID)
-
+	fmt.Printf("Seeding %q is complete: %d users, %d products, %d sales, %d orders\n",
+		*set, len(f.Users), len(f.Products), len(f.Sales), len(f.Orders))
 	return nil
 }
 
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// migrate runs the migrate subcommands:
+//
+//	migrate status
+//	migrate up [N]
+//	migrate down [N]
+//	migrate to VERSION
+//	migrate create NAME
+//
+// up, down and to accept --dry-run to print the SQL instead of running it.
+func migrate(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "print the SQL instead of running it")
+	dir := fs.String("dir", "internal/schema/migrations", "directory new migrations are created in")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing migrate flags")
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	if cmd == "create" {
+		if len(args) != 1 {
+			return errors.New("migrate create must be called with a name")
+		}
+		path, err := schema.Create(*dir, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created", path)
+		return nil
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := schema.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	m.DryRun = *dryRun
+	m.Out = os.Stdout
+
+	ctx := context.Background()
+
+	switch cmd {
+	case "status":
+		return migrateStatus(ctx, m)
+	case "up":
+		n, err := optionalCount(args, 0)
+		if err != nil {
+			return err
+		}
+		if err := m.Up(ctx, n); err != nil {
+			return err
+		}
+	case "down":
+		n, err := optionalCount(args, 1)
+		if err != nil {
+			return err
+		}
+		if err := m.Down(ctx, n); err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 1 {
+			return errors.New("migrate to must be called with a version")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errors.Wrapf(err, "parsing version %q", args[0])
+		}
+		if err := m.To(ctx, version); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("unknown migrate command %q", cmd)
+	}
+
+	if !*dryRun {
+		fmt.Println("Migrating is complete")
+	}
+	return nil
+}
+
+// migrateStatus prints every migration and when it was applied.
+func migrateStatus(ctx context.Context, m *schema.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if s.Drifted {
+			applied += " (drifted)"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, applied, s.Description)
+	}
+
+	return tw.Flush()
+}
+
+// optionalCount parses the optional N argument of up and down.
+func optionalCount(args []string, fallback int) (int, error) {
+	if len(args) == 0 {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("expected a positive number of migrations, got %q", args[0])
+	}
+	return n, nil
+}
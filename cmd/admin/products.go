@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// productsCmd runs the products subcommands:
+//
+//	products import FILE --owner USER [--format FORMAT] [--dry-run] [--best-effort]
+//	products export [FILE] [--format FORMAT]
+//
+// FILE may be - for stdin or stdout. FORMAT is csv or ndjson and defaults to
+// the extension of FILE, or csv. An import is all or nothing unless
+// --best-effort is given.
+func productsCmd(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("products")
+	owner := fs.String("owner", "", "id or email of the user owning imported products")
+	formatName := fs.String("format", "", "csv or ndjson")
+	dryRun := fs.Bool("dry-run", false, "validate the rows without importing them")
+	bestEffort := fs.Bool("best-effort", false, "import the valid rows even if others are invalid")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing products flags")
+	}
+
+	if len(args) == 0 {
+		return errors.New("products must be called with import or export")
+	}
+	cmd, args := args[0], args[1:]
+
+	path := "-"
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	format := product.FormatCSV
+	switch {
+	case *formatName != "":
+		format, err = product.ParseFormat(*formatName)
+	case path != "-":
+		if f, ferr := product.ParseFormat(filepath.Ext(path)); ferr == nil {
+			format = f
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	store := product.NewPostgres(database.NewCluster(db))
+
+	switch cmd {
+	case "import":
+		if len(args) != 1 {
+			return errors.New("products import must be called with a file")
+		}
+		if *owner == "" {
+			return errors.New("products import must be called with --owner")
+		}
+
+		u, err := findUser(ctx, db, *owner)
+		if err != nil {
+			return err
+		}
+		claims := auth.NewClaims(u.ID, u.Roles, time.Now(), time.Minute)
+
+		var in io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return errors.Wrap(err, "opening import")
+			}
+			defer f.Close()
+			in = f
+		}
+
+		opts := product.ImportOptions{DryRun: *dryRun, BestEffort: *bestEffort}
+		result, err := product.Import(ctx, store, claims, in, format, opts, time.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, e := range result.Errors {
+			for _, f := range e.Fields {
+				fmt.Fprintf(os.Stderr, "line %d: %s: %s\n", e.Line, f.Field, f.Error)
+			}
+		}
+		fmt.Printf("%d rows, %d valid, %d imported\n", result.Rows, result.Valid, result.Imported)
+
+		if len(result.Errors) > 0 && !*bestEffort {
+			return errors.New("some rows are invalid, nothing was imported")
+		}
+		return nil
+
+	case "export":
+		rows, err := store.ListRows(ctx)
+		if err != nil {
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		if path != "-" {
+			f, err := os.Create(path)
+			if err != nil {
+				return errors.Wrap(err, "creating export")
+			}
+			defer f.Close()
+			out = f
+		}
+
+		return product.Export(rows, out, format)
+
+	default:
+		return errors.Errorf("unknown products command %q", cmd)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// schemaCmd runs the schema subcommands:
+//
+//	schema verify [--json]
+//
+// verify reports rows that break the constraints of the hardening migrations
+// and exits with an error when any of them would make migrating fail.
+func schemaCmd(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("schema")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing schema flags")
+	}
+
+	if len(args) == 0 || args[0] != "verify" {
+		return errors.New("schema must be called with verify")
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	violations, err := schema.Verify(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(violations); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TABLE\tCONSTRAINT\tROWS\tBACKFILLED\tSAMPLE")
+		for _, v := range violations {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%t\t%s\n", v.Table, v.Constraint, v.Rows, v.Backfilled, strings.Join(v.Sample, ","))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range violations {
+		if !v.Backfilled {
+			return errors.New("rows must be fixed before the constraints can be enforced")
+		}
+	}
+
+	return nil
+}
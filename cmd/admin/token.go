@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
+	"github.com/pkg/errors"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// tokenCmd runs the token subcommands:
+//
+//	token mint --sub SUBJECT [--roles ROLES] [--ttl DURATION]
+//	token inspect [TOKEN]
+//
+// inspect reads the token from stdin when it is not given and explains why
+// the API would reject it.
+func tokenCmd(cfg config.Auth, args []string) error {
+	fs := newFlagSet("token")
+	sub := fs.String("sub", "", "subject of the token, usually a user id")
+	roleList := fs.String("roles", "", "comma separated roles: "+strings.Join(roles, ","))
+	ttl := fs.Duration("ttl", time.Hour, "time until the token expires")
+	asJSON := fs.Bool("json", false, "print the token and its claims as JSON")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing token flags")
+	}
+
+	if len(args) == 0 {
+		return errors.New("token must be called with mint or inspect")
+	}
+	cmd, args := args[0], args[1:]
+
+	keys, err := auth.LoadKeys(cfg.PrivateKeyFile, cfg.KeyID, cfg.PublicKeyFiles)
+	if err != nil {
+		return err
+	}
+	authenticator, err := auth.NewAuthenticator(keys.Private, keys.ActiveKID, cfg.Algorithm, keys.Lookup)
+	if err != nil {
+		return errors.Wrap(err, "constructing authenticator")
+	}
+
+	switch cmd {
+	case "mint":
+		if *sub == "" {
+			return errors.New("token mint must be called with --sub")
+		}
+		rs, err := parseRoles(*roleList, []string{auth.RoleUser})
+		if err != nil {
+			return err
+		}
+
+		claims := auth.NewClaims(*sub, rs, time.Now(), *ttl)
+		tkn, err := authenticator.GenerateToken(claims)
+		if err != nil {
+			return err
+		}
+
+		if *asJSON {
+			return printJSON(struct {
+				Token  string      `json:"token"`
+				KID    string      `json:"kid"`
+				Claims auth.Claims `json:"claims"`
+			}{tkn, keys.ActiveKID, claims})
+		}
+		fmt.Println(tkn)
+		return nil
+
+	case "inspect":
+		tkn := ""
+		if len(args) > 0 {
+			tkn = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return errors.Wrap(err, "reading token from stdin")
+			}
+			tkn = line
+		}
+		tkn = strings.TrimPrefix(strings.TrimSpace(tkn), "Bearer ")
+
+		return inspect(authenticator, keys, cfg.Algorithm, tkn, *asJSON)
+
+	default:
+		return errors.Errorf("unknown token command %q", cmd)
+	}
+}
+
+// inspection is what inspect reports about a token.
+type inspection struct {
+	Header map[string]interface{} `json:"header"`
+	Claims auth.Claims            `json:"claims"`
+	Valid  bool                   `json:"valid"`
+	Reason string                 `json:"reason,omitempty"`
+}
+
+// inspect decodes a token without trusting it, verifies it like the API does
+// and explains the failure, if any.
+func inspect(authenticator *auth.Authenticator, keys *auth.Keys, algorithm, tkn string, asJSON bool) error {
+	var in inspection
+	parsed, _, err := new(jwt.Parser).ParseUnverified(tkn, &in.Claims)
+	if err != nil {
+		return errors.Wrap(err, "token is malformed")
+	}
+	in.Header = parsed.Header
+
+	if _, err := authenticator.ParseClaims(tkn); err != nil {
+		in.Reason = explain(err, parsed, in.Claims, keys, algorithm)
+	} else {
+		in.Valid = true
+	}
+
+	if asJSON {
+		if err := printJSON(in); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "Algorithm:\t%v\n", in.Header["alg"])
+		fmt.Fprintf(tw, "Key ID:\t%v\n", in.Header["kid"])
+		fmt.Fprintf(tw, "Subject:\t%s\n", in.Claims.Subject)
+		fmt.Fprintf(tw, "Roles:\t%s\n", strings.Join(in.Claims.Roles, ","))
+		fmt.Fprintf(tw, "Issued at:\t%s\n", formatUnix(in.Claims.IssuedAt))
+		fmt.Fprintf(tw, "Expires at:\t%s\n", formatUnix(in.Claims.ExpiresAt))
+		if in.Valid {
+			fmt.Fprintf(tw, "Valid:\tyes\n")
+		} else {
+			fmt.Fprintf(tw, "Valid:\tno, %s\n", in.Reason)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !in.Valid {
+		return errors.New("token is not valid")
+	}
+	return nil
+}
+
+// explain turns a verification error into the reason a person can act on.
+func explain(err error, parsed *jwt.Token, claims auth.Claims, keys *auth.Keys, algorithm string) string {
+	if alg := parsed.Method.Alg(); alg != algorithm {
+		return fmt.Sprintf("signed with %s but only %s is accepted", alg, algorithm)
+	}
+
+	kid, ok := parsed.Header["kid"].(string)
+	if !ok {
+		return "the header has no key id"
+	}
+	if _, err := keys.Lookup(kid); err != nil {
+		return fmt.Sprintf("unknown key id %q, configured are %s", kid, strings.Join(keys.KIDs(), ", "))
+	}
+
+	if verr, ok := errors.Cause(err).(*jwt.ValidationError); ok {
+		now := time.Now()
+		switch {
+		case verr.Errors&jwt.ValidationErrorExpired != 0:
+			exp := time.Unix(claims.ExpiresAt, 0)
+			return fmt.Sprintf("expired at %s, %s ago", exp.Format(time.RFC3339), now.Sub(exp).Round(time.Second))
+		case verr.Errors&jwt.ValidationErrorIssuedAt != 0:
+			return fmt.Sprintf("issued in the future at %s", formatUnix(claims.IssuedAt))
+		case verr.Errors&jwt.ValidationErrorNotValidYet != 0:
+			return fmt.Sprintf("not valid before %s", formatUnix(claims.NotBefore))
+		case verr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return fmt.Sprintf("the signature does not match key %q", kid)
+		}
+	}
+
+	return err.Error()
+}
+
+// keysCmd runs the keys subcommands:
+//
+//	keys list
+//
+// list shows every configured key id with the fingerprint of its public key.
+func keysCmd(cfg config.Auth, args []string) error {
+	fs := newFlagSet("keys")
+	asJSON := fs.Bool("json", false, "print the keys as JSON")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing keys flags")
+	}
+
+	if len(args) == 0 || args[0] != "list" {
+		return errors.New("keys must be called with list")
+	}
+
+	keys, err := auth.LoadKeys(cfg.PrivateKeyFile, cfg.KeyID, cfg.PublicKeyFiles)
+	if err != nil {
+		return err
+	}
+
+	type key struct {
+		KID         string `json:"kid"`
+		Active      bool   `json:"active"`
+		Bits        int    `json:"bits"`
+		Fingerprint string `json:"fingerprint"`
+	}
+	var list []key
+	for _, kid := range keys.KIDs() {
+		public := keys.Public[kid]
+		fp, err := auth.Fingerprint(public)
+		if err != nil {
+			return err
+		}
+		list = append(list, key{kid, kid == keys.ActiveKID, public.N.BitLen(), fp})
+	}
+
+	if *asJSON {
+		return printJSON(list)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KID\tACTIVE\tBITS\tFINGERPRINT")
+	for _, k := range list {
+		fmt.Fprintf(tw, "%s\t%t\t%d\t%s\n", k.KID, k.Active, k.Bits, k.Fingerprint)
+	}
+	return tw.Flush()
+}
+
+// formatUnix formats a claim timestamp, which is zero when missing.
+func formatUnix(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format(time.RFC3339)
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// roles lists the roles a User may be given.
+var roles = []string{auth.RoleAdmin, auth.RoleUser}
+
+// userCmd runs the user subcommands:
+//
+//	user list
+//	user show USER
+//	user add EMAIL [--name NAME] [--roles ROLES] [--password-stdin]
+//	user set-roles USER --roles ROLES
+//	user disable USER
+//	user enable USER
+//	user reset-password USER [--password-stdin]
+//	user delete USER [--yes]
+//
+// USER is an ID or an email and ROLES a comma separated list of ADMIN and
+// USER. Every subcommand accepts --json to print the users as JSON.
+func userCmd(dbConfig database.Config, args []string) error {
+	fs := newFlagSet("user")
+	asJSON := fs.Bool("json", false, "print users as JSON")
+	name := fs.String("name", "", "name of a new user, defaults to the email")
+	roleList := fs.String("roles", "", "comma separated roles: "+strings.Join(roles, ","))
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return errors.Wrap(err, "parsing user flags")
+	}
+
+	if len(args) == 0 {
+		return errors.New("user must be called with list, show, add, set-roles, disable, enable, reset-password or delete")
+	}
+	cmd, args := args[0], args[1:]
+
+	if cmd != "list" && len(args) != 1 {
+		return errors.Errorf("user %s must be called with a single user", cmd)
+	}
+
+	db, err := database.Open(dbConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	switch cmd {
+	case "list":
+		list, err := user.List(ctx, db)
+		if err != nil {
+			return err
+		}
+		return printUsers(list, *asJSON)
+
+	case "show":
+		u, err := findUser(ctx, db, args[0])
+		if err != nil {
+			return err
+		}
+		return printUsers([]user.User{*u}, *asJSON)
+
+	case "add":
+		rs, err := parseRoles(*roleList, []string{auth.RoleUser})
+		if err != nil {
+			return err
+		}
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			return err
+		}
+
+		nu := user.NewUser{
+			Name:     *name,
+			Email:    args[0],
+			Roles:    rs,
+			Password: password,
+		}
+		if nu.Name == "" {
+			nu.Name = nu.Email
+		}
+
+		u, err := user.Create(ctx, db, nu, now)
+		if err != nil {
+			return err
+		}
+		return printUsers([]user.User{*u}, *asJSON)
+
+	case "delete":
+		u, err := findUser(ctx, db, args[0])
+		if err != nil {
+			return err
+		}
+
+		if !*yes {
+			ok, err := confirm(fmt.Sprintf("Delete user %s (%s)?", u.Email, u.ID))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Fprintln(os.Stderr, "Canceling")
+				return nil
+			}
+		}
+
+		if err := user.Delete(ctx, db, u.ID); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printUsers([]user.User{*u}, true)
+		}
+		fmt.Printf("User %s deleted\n", u.Email)
+		return nil
+	}
+
+	u, err := findUser(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "set-roles":
+		if *roleList == "" {
+			return errors.New("user set-roles must be called with --roles")
+		}
+		var rs []string
+		if rs, err = parseRoles(*roleList, nil); err == nil {
+			err = user.SetRoles(ctx, db, u.ID, rs, now)
+		}
+	case "disable":
+		err = user.SetDisabled(ctx, db, u.ID, true, now)
+	case "enable":
+		err = user.SetDisabled(ctx, db, u.ID, false, now)
+	case "reset-password":
+		var password string
+		if password, err = readPassword(*passwordStdin); err == nil {
+			err = user.ChangePassword(ctx, db, u.ID, password, now)
+		}
+	default:
+		return errors.Errorf("unknown user command %q", cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	if u, err = user.Retrieve(ctx, db, u.ID); err != nil {
+		return err
+	}
+	return printUsers([]user.User{*u}, *asJSON)
+}
+
+// findUser retrieves a User by ID or, when ref is not a UUID, by email.
+func findUser(ctx context.Context, db *sqlx.DB, ref string) (*user.User, error) {
+	var u *user.User
+	var err error
+	if _, perr := uuid.Parse(ref); perr == nil {
+		u, err = user.Retrieve(ctx, db, ref)
+	} else {
+		u, err = user.RetrieveByEmail(ctx, db, ref)
+	}
+
+	if err == user.ErrNotFound {
+		return nil, errors.Errorf("no user %q", ref)
+	}
+	return u, err
+}
+
+// parseRoles validates a comma separated list of roles. An empty list yields
+// the defaults.
+func parseRoles(list string, defaults []string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return defaults, nil
+	}
+
+	var parsed []string
+	seen := make(map[string]bool)
+	for _, r := range strings.Split(list, ",") {
+		r = strings.ToUpper(strings.TrimSpace(r))
+
+		valid := false
+		for _, known := range roles {
+			valid = valid || r == known
+		}
+		if !valid {
+			return nil, errors.Errorf("unknown role %q, expected one of %s", r, strings.Join(roles, ", "))
+		}
+
+		if !seen[r] {
+			seen[r] = true
+			parsed = append(parsed, r)
+		}
+	}
+
+	return parsed, nil
+}
+
+// readPassword reads a password from the first line of stdin or, on a
+// terminal, prompts for it twice without echoing it.
+func readPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", errors.Wrap(err, "reading password from stdin")
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", errors.New("password must not be empty")
+		}
+		return password, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal, use --password-stdin")
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", errors.Wrap(err, "reading password")
+	}
+	if len(password) == 0 {
+		return "", errors.New("password must not be empty")
+	}
+
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", errors.Wrap(err, "reading password")
+	}
+	if string(confirmation) != string(password) {
+		return "", errors.New("passwords do not match")
+	}
+
+	return string(password), nil
+}
+
+// confirm asks a yes or no question on the terminal. Anything but y or yes
+// is a no.
+func confirm(question string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, errors.New("stdin is not a terminal, use --yes")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, errors.Wrap(err, "reading answer")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// printUsers writes users as a table or as JSON.
+func printUsers(users []user.User, asJSON bool) error {
+	if asJSON {
+		return printJSON(users)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tROLES\tDISABLED")
+	for _, u := range users {
+		disabled := "-"
+		if u.DisabledAt != nil {
+			disabled = u.DisabledAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Name, strings.Join(u.Roles, ","), disabled)
+	}
+	return tw.Flush()
+}
@@ -2,36 +2,43 @@ package handlers
 
 import (
 	"context"
-	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/health"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
-	"github.com/jmoiron/sqlx"
 	"net/http"
 )
 
 // Check has handlers to implement service orchestration
 type Check struct {
-	DB *sqlx.DB
-
-	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED
+	Health *health.Registry
 }
 
-// Health responds with a 200 OK if the service is healthy
-// and ready for the traffic.
-func (c *Check) Health(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	var health struct {
-		Status string `json:"status"`
+// Live responds with a 200 OK as long as the process can serve requests. It
+// never looks at dependencies so a database outage drains the service
+// through Ready instead of getting it restarted.
+func (c *Check) Live(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	live := struct {
+		Status string       `json:"status"`
+		Build  health.Build `json:"build"`
+	}{
+		Status: "OK",
+		Build:  c.Health.Build(),
 	}
 
-	// Check if the database is ready
-	if err := database.StatusCheck(ctx, c.DB); err != nil {
-		// If the database is not ready we will tell the client
-		// and use a 500 status. Do not respond by just returning
-		// an error because further up in the call stack will
-		// interpret that as an unhandled error.
-		health.Status = "db not ready"
-		return web.Respond(r.Context(), w, health, http.StatusInternalServerError)
+	return web.Respond(ctx, w, live, http.StatusOK)
+}
+
+// Ready responds with a 200 OK when every required dependency is usable and
+// with a 503 Service Unavailable otherwise, including once shutdown started.
+// The report is the response either way. Do not respond by just returning an
+// error because further up in the call stack will interpret that as an
+// unhandled error.
+func (c *Check) Ready(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	report := c.Health.Ready(ctx)
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
 	}
 
-	health.Status = "OK"
-	return web.Respond(r.Context(), w, health, http.StatusOK)
+	return web.Respond(ctx, w, report, status)
 }
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/health"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"github.com/pkg/errors"
+	"net/http"
+	"testing"
+)
+
+// TestHealth checks that a failing dependency drains the service without
+// making it look dead.
+func TestHealth(t *testing.T) {
+	checks := health.NewRegistry(health.Build{Version: "v1.0.0", Commit: "abc123"})
+
+	var dbErr error
+	checks.Register(health.Checker{
+		Name: "db",
+		Check: func(ctx context.Context) error {
+			return dbErr
+		},
+	})
+
+	app := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		return API(APIConfig{
+			Shutdown:      deps.Shutdown,
+			Log:           deps.Log,
+			Health:        checks,
+			Authenticator: deps.Authenticator,
+			Products:      deps.Products,
+			Sales:         deps.Sales,
+			Users:         deps.Users,
+		})
+	})
+
+	var report health.Report
+	app.Get(t, "/v1/api/health/ready").Do().Status(http.StatusOK).Decode(&report)
+	if report.Build.Commit != "abc123" || len(report.Checks) != 1 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	dbErr = errors.New("connection refused")
+	app.Get(t, "/v1/api/health/ready").Do().Status(http.StatusServiceUnavailable)
+	app.Get(t, "/v1/api/health/live").Do().Status(http.StatusOK)
+
+	dbErr = nil
+	checks.Drain()
+	app.Get(t, "/v1/api/health/ready").Do().Status(http.StatusServiceUnavailable).Decode(&report)
+	if report.Status != health.StatusDraining {
+		t.Fatalf("Expected %q once draining, got %q", health.StatusDraining, report.Status)
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// replayBatch is how many missed events are read from the feed at once.
+const replayBatch = 500
+
+// eventsCookie holds the stream token of browsers.
+const eventsCookie = "events_token"
+
+// Events streams the changes made to Products and Sales.
+type Events struct {
+	Feed events.Feed
+
+	// Streams end after MaxDuration, making long-lived clients reconnect
+	// and resume now and then. Zero lets them run until the client leaves.
+	// Idle streams send a heartbeat every Heartbeat. The server write
+	// timeout does not apply to streams: every write is given a Heartbeat
+	// to complete instead.
+	MaxDuration time.Duration
+	Heartbeat   time.Duration
+
+	// Authenticator signs the tokens browsers open streams with. They are
+	// valid for TokenTTL.
+	Authenticator *auth.Authenticator
+	TokenTTL      time.Duration
+}
+
+// Token issues a token for opening streams to browsers, whose EventSource and
+// WebSocket can not send an Authorization header. It is returned to pass as
+// the access_token query parameter and set as a cookie scoped to the stream.
+// The token expires after TokenTTL, or with the token it was issued for.
+func (e *Events) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web values missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	ttl := e.tokenTTL()
+	if claims.ExpiresAt != 0 {
+		if left := time.Unix(claims.ExpiresAt, 0).Sub(v.Start); left < ttl {
+			ttl = left
+		}
+	}
+
+	c := auth.NewClaims(claims.Subject, claims.Roles, v.Start, ttl)
+	c.Issuer = claims.Issuer
+	c.Audience = auth.AudienceEvents
+	tkn, err := e.Authenticator.GenerateToken(c)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     eventsCookie,
+		Value:    tkn,
+		Path:     "/v1/api/events",
+		MaxAge:   int(ttl / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	resp := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		Token:     tkn,
+		ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC(),
+	}
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Stream sends the events as Server-Sent Events, or as WebSocket text
+// messages holding one JSON event each when the client asks for an upgrade.
+// Clients resume after the last event they saw with the Last-Event-ID
+// header, which EventSource sends on its own when reconnecting, or the
+// last_event_id query parameter. Without either they only get new events.
+func (e *Events) Stream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	last, resume, err := lastEventID(r)
+	if err != nil {
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web values missing from context")
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return e.websocket(ctx, v, w, r, last, resume)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("response writer can not stream")
+	}
+
+	// Every write pushes the deadline the server set for the whole response
+	// forward.
+	write := func(format string, args ...interface{}) error {
+		err := web.SetWriteDeadline(ctx, time.Now().Add(e.heartbeat()))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return errors.Wrap(err, "extending the write deadline")
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return errors.Wrap(err, "Could not write to the client")
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+
+	// Proxies such as nginx would otherwise hold the events back.
+	h.Set("X-Accel-Buffering", "no")
+
+	v.StatusCode = http.StatusOK
+	w.WriteHeader(http.StatusOK)
+
+	// Clients reconnect at once when the stream ends after MaxDuration.
+	if err := write("retry: 1000\n\n"); err != nil {
+		return err
+	}
+
+	send := func(ev events.Event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "marshaling event")
+		}
+		return write("id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+	}
+	beat := func() error {
+		return write(": heartbeat\n\n")
+	}
+
+	err = e.follow(ctx, last, resume, send, beat)
+
+	// The server still ends the response once the handler returned.
+	web.SetWriteDeadline(ctx, time.Now().Add(e.heartbeat()))
+	return err
+}
+
+// websocket upgrades the connection and sends the events over it. Messages
+// from the client are ignored, except for closing the connection.
+func (e *Events) websocket(ctx context.Context, v *web.Values, w http.ResponseWriter, r *http.Request,
+	last int64, resume bool) error {
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept answered the client already.
+		v.StatusCode = http.StatusBadRequest
+		return nil
+	}
+	v.StatusCode = http.StatusSwitchingProtocols
+	defer c.Close(websocket.StatusInternalError, "")
+
+	ctx = c.CloseRead(ctx)
+
+	// The hijacked connection has no deadline left; every write is given a
+	// heartbeat to complete.
+	send := func(ev events.Event) error {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "marshaling event")
+		}
+		ctx, cancel := context.WithTimeout(ctx, e.heartbeat())
+		defer cancel()
+		return c.Write(ctx, websocket.MessageText, data)
+	}
+	beat := func() error {
+		ctx, cancel := context.WithTimeout(ctx, e.heartbeat())
+		defer cancel()
+		return c.Ping(ctx)
+	}
+
+	if err := e.follow(ctx, last, resume, send, beat); err != nil {
+		return err
+	}
+
+	// Going away tells the client to reconnect with the last event it got.
+	c.Close(websocket.StatusGoingAway, "stream duration reached")
+	return nil
+}
+
+// follow sends the events after last when resuming, then the new ones as
+// they come, until the client leaves or the stream lasted MaxDuration. It
+// subscribes before replaying so nothing committed meanwhile is missed.
+// Event IDs only grow, so anything up to the last event sent, such as an
+// event replayed or relayed again after the feed reconnected, is skipped.
+func (e *Events) follow(ctx context.Context, last int64, resume bool, send func(events.Event) error,
+	beat func() error) error {
+	sub := e.Feed.Subscribe()
+	defer sub.Close()
+
+	if resume {
+		for {
+			list, err := e.Feed.Since(ctx, last, replayBatch)
+			if err != nil {
+				return err
+			}
+			for _, ev := range list {
+				if err := send(ev); err != nil {
+					return err
+				}
+				last = ev.ID
+			}
+			if len(list) < replayBatch {
+				break
+			}
+		}
+	}
+
+	var end <-chan time.Time
+	if e.MaxDuration > 0 {
+		t := time.NewTimer(e.MaxDuration)
+		defer t.Stop()
+		end = t.C
+	}
+
+	ticker := time.NewTicker(e.heartbeat())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-end:
+			return nil
+
+		case <-ticker.C:
+			if err := beat(); err != nil {
+				return err
+			}
+
+		case ev, ok := <-sub.C:
+			// A subscription is closed when the client fell behind. Ending
+			// the stream makes it catch up from its last event.
+			if !ok {
+				return nil
+			}
+			if ev.ID <= last {
+				continue
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+			last = ev.ID
+		}
+	}
+}
+
+// heartbeat is how often idle streams send a heartbeat.
+func (e *Events) heartbeat() time.Duration {
+	if e.Heartbeat <= 0 {
+		return 15 * time.Second
+	}
+	return e.Heartbeat
+}
+
+// tokenTTL is how long stream tokens are valid.
+func (e *Events) tokenTTL() time.Duration {
+	if e.TokenTTL <= 0 {
+		return time.Minute
+	}
+	return e.TokenTTL
+}
+
+// lastEventID returns the ID of the last event the client saw and whether
+// it gave one. Zero asks for every event.
+func lastEventID(r *http.Request) (int64, bool, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, errors.Errorf("invalid last event ID %q", s)
+	}
+	return id, true, nil
+}
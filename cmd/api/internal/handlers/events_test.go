@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEvents checks that changes are streamed as Server-Sent Events and that
+// clients resume after the last event they saw.
+func TestEvents(t *testing.T) {
+	app := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		return API(APIConfig{
+			Shutdown:          deps.Shutdown,
+			Log:               deps.Log,
+			Authenticator:     deps.Authenticator,
+			Products:          deps.Products,
+			Sales:             deps.Sales,
+			Users:             deps.Users,
+			Events:            deps.Events,
+			EventsMaxDuration: 200 * time.Millisecond,
+		})
+	})
+
+	ctx := context.Background()
+	admin := app.Claims(t, webtest.Admin)
+	p, err := app.Products.Create(ctx, admin, product.NewProduct{Name: "Comic Books", Cost: 50, Quantity: 42}, time.Now())
+	if err != nil {
+		t.Fatalf("Creating product: %v", err)
+	}
+	if _, err := app.Sales.AddSale(ctx, admin, p.ID, product.NewSale{Quantity: 2, Paid: 100}, time.Now()); err != nil {
+		t.Fatalf("Adding sale: %v", err)
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		resp := app.Get(t, "/v1/api/events").As(webtest.User).Header("Last-Event-ID", "0").Do().
+			Status(http.StatusOK).
+			Header("Content-Type", "text/event-stream")
+
+		body := resp.Body.String()
+		for _, want := range []string{
+			"id: 1\nevent: " + product.EventProductCreated + "\n",
+			"id: 2\nevent: " + product.EventSaleRecorded + "\n",
+			`"subject":"` + p.ID + `"`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Fatalf("Expected %q in stream:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		resp := app.Get(t, "/v1/api/events?last_event_id=1").As(webtest.User).Do().
+			Status(http.StatusOK)
+
+		body := resp.Body.String()
+		if strings.Contains(body, "id: 1\n") || !strings.Contains(body, "id: 2\n") {
+			t.Fatalf("Expected only the events after 1:\n%s", body)
+		}
+	})
+
+	t.Run("Live", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			if err := app.Products.Delete(ctx, p.ID, time.Now()); err != nil {
+				t.Errorf("Deleting product: %v", err)
+			}
+
+			// A feed catching up after a reconnect publishes events again.
+			list, err := app.Events.Since(ctx, 2, 1)
+			if err != nil || len(list) != 1 {
+				t.Errorf("Reading the delete event: %v", err)
+				return
+			}
+			app.Events.(*events.Memory).Publish(list[0])
+		}()
+
+		resp := app.Get(t, "/v1/api/events").As(webtest.User).Do().
+			Status(http.StatusOK)
+
+		body := resp.Body.String()
+		if strings.Contains(body, "id: 2\n") || !strings.Contains(body, "id: 3\nevent: "+product.EventProductDeleted+"\n") {
+			t.Fatalf("Expected only the new delete event:\n%s", body)
+		}
+		if n := strings.Count(body, "id: 3\n"); n != 1 {
+			t.Fatalf("Expected the delete event once, got it %d times:\n%s", n, body)
+		}
+	})
+
+	t.Run("InvalidLastEventID", func(t *testing.T) {
+		app.Get(t, "/v1/api/events").As(webtest.User).Header("Last-Event-ID", "abc").Do().
+			Status(http.StatusBadRequest)
+	})
+
+	t.Run("BrowserToken", func(t *testing.T) {
+		app.Post(t, "/v1/api/events/token", nil).Do().Status(http.StatusUnauthorized)
+
+		var tkn struct {
+			Token     string    `json:"token"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}
+		resp := app.Post(t, "/v1/api/events/token", nil).As(webtest.User).Do().
+			Status(http.StatusOK).
+			Decode(&tkn)
+		if tkn.Token == "" || time.Until(tkn.ExpiresAt) > time.Minute {
+			t.Fatalf("Expected a token valid for a minute at most, got %+v", tkn)
+		}
+		if c := resp.Result().Header.Get("Set-Cookie"); !strings.Contains(c, "events_token="+tkn.Token) ||
+			!strings.Contains(c, "HttpOnly") {
+			t.Fatalf("Expected the token set as an HTTP only cookie, got %q", c)
+		}
+
+		app.Get(t, "/v1/api/events?last_event_id=1&access_token="+tkn.Token).Do().
+			Status(http.StatusOK)
+		app.Get(t, "/v1/api/events?last_event_id=1").Header("Cookie", "events_token="+tkn.Token).Do().
+			Status(http.StatusOK)
+
+		// Stream tokens open streams only, and only stream tokens go in URLs.
+		app.Get(t, "/v1/api/products").Header("Authorization", "Bearer "+tkn.Token).Do().
+			Status(http.StatusUnauthorized)
+		app.Get(t, "/v1/api/events?access_token="+app.Token(t, webtest.User)).Do().
+			Status(http.StatusUnauthorized)
+		app.Get(t, "/v1/api/events?access_token=garbage").Do().
+			Status(http.StatusUnauthorized)
+	})
+}
+
+// TestEventsWriteTimeout checks that streams outlive the write timeout of the
+// server as long as they keep writing.
+func TestEventsWriteTimeout(t *testing.T) {
+	app := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		return API(APIConfig{
+			Shutdown:          deps.Shutdown,
+			Log:               deps.Log,
+			Authenticator:     deps.Authenticator,
+			Products:          deps.Products,
+			Sales:             deps.Sales,
+			Users:             deps.Users,
+			Events:            deps.Events,
+			EventsMaxDuration: 600 * time.Millisecond,
+			EventsHeartbeat:   50 * time.Millisecond,
+		})
+	})
+
+	srv := httptest.NewUnstartedServer(app.Handler)
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/api/events", nil)
+	if err != nil {
+		t.Fatalf("Creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+app.Token(t, webtest.User))
+
+	start := time.Now()
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Opening stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading stream after %v: %v", time.Since(start), err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Fatalf("Expected the stream to last its max duration, it ended after %v", d)
+	}
+	if n := strings.Count(string(body), ": heartbeat\n"); n < 8 {
+		t.Fatalf("Expected heartbeats past the write timeout, got %d:\n%s", n, body)
+	}
+}
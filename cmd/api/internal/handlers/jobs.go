@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Jobs lets admins follow the background jobs and retry the dead ones.
+type Jobs struct {
+	Store jobs.Store
+}
+
+// List returns jobs, newest first, narrowed by the kind, status and limit
+// query parameters.
+func (jb *Jobs) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	f := jobs.Filter{
+		Kind:   q.Get("kind"),
+		Status: q.Get("status"),
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return web.NewRequestError(errors.Errorf("invalid limit %q", s), http.StatusBadRequest)
+		}
+		f.Limit = n
+	}
+
+	list, err := jb.Store.List(ctx, f)
+	if err != nil {
+		return errors.Wrap(err, "listing jobs")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Stats returns how many jobs of every kind have each status.
+func (jb *Jobs) Stats(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	stats, err := jb.Store.Stats(ctx)
+	if err != nil {
+		return errors.Wrap(err, "counting jobs")
+	}
+
+	return web.Respond(ctx, w, stats, http.StatusOK)
+}
+
+// Retrieve returns a single job.
+func (jb *Jobs) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id, err := jobID(r)
+	if err != nil {
+		return err
+	}
+
+	j, err := jb.Store.Retrieve(ctx, id)
+	if err != nil {
+		if err == jobs.ErrNotFound {
+			return web.NewRequestError(err, http.StatusNotFound)
+		}
+		return errors.Wrapf(err, "retrieving job %d", id)
+	}
+
+	return web.Respond(ctx, w, j, http.StatusOK)
+}
+
+// Retry queues a dead job again. A runner picks it up shortly after, so the
+// response is 202 Accepted with the queued job.
+func (jb *Jobs) Retry(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id, err := jobID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := jb.Store.Retry(ctx, id, time.Now()); err != nil {
+		switch err {
+		case jobs.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case jobs.ErrNotRetryable, jobs.ErrDuplicate:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "retrying job %d", id)
+		}
+	}
+
+	j, err := jb.Store.Retrieve(ctx, id)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving job %d", id)
+	}
+
+	return web.Respond(ctx, w, j, http.StatusAccepted)
+}
+
+// jobID parses the job ID of the request URL.
+func jobID(r *http.Request) (int64, error) {
+	s := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, web.NewRequestError(errors.Errorf("invalid job ID %q", s), http.StatusBadRequest)
+	}
+	return id, nil
+}
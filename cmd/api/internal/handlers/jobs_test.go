@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestJobs checks that admins follow the background jobs and retry the dead
+// ones through the API.
+func TestJobs(t *testing.T) {
+	app := webtest.New(t, build)
+
+	// A job that ran out of attempts.
+	ctx := context.Background()
+	now := time.Now()
+	j, err := app.Jobs.Enqueue(ctx, jobs.NewJob{Kind: "product.low_stock"}, now)
+	if err != nil {
+		t.Fatalf("Enqueuing: %v", err)
+	}
+	if _, err := app.Jobs.Claim(ctx, []string{j.Kind}, "test", now, time.Minute, 1); err != nil {
+		t.Fatalf("Claiming: %v", err)
+	}
+	if err := app.Jobs.Fail(ctx, j.ID, "test", "database is down", now, nil); err != nil {
+		t.Fatalf("Failing: %v", err)
+	}
+	path := "/v1/api/jobs/" + strconv.FormatInt(j.ID, 10)
+
+	t.Run("List", func(t *testing.T) {
+		app.Get(t, "/v1/api/jobs").As(webtest.User).Do().Status(http.StatusForbidden)
+
+		var list []jobs.Job
+		app.Get(t, "/v1/api/jobs?status=dead&kind=product.low_stock").As(webtest.Admin).Do().
+			Status(http.StatusOK).
+			Decode(&list)
+		if len(list) != 1 || list[0].ID != j.ID || list[0].LastError == nil {
+			t.Fatalf("Expected the dead job with its error, got %+v", list)
+		}
+
+		app.Get(t, "/v1/api/jobs?limit=many").As(webtest.Admin).Do().Status(http.StatusBadRequest)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		var stats []jobs.Stat
+		app.Get(t, "/v1/api/jobs/stats").As(webtest.Admin).Do().
+			Status(http.StatusOK).
+			Decode(&stats)
+		want := jobs.Stat{Kind: "product.low_stock", Status: jobs.StatusDead, Count: 1}
+		if len(stats) != 1 || stats[0] != want {
+			t.Fatalf("Expected %+v, got %+v", want, stats)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		var got jobs.Job
+		app.Post(t, path+"/retry", nil).As(webtest.Admin).Do().
+			Status(http.StatusAccepted).
+			Decode(&got)
+		if got.Status != jobs.StatusQueued || got.Attempts != 0 {
+			t.Fatalf("Expected the job queued with fresh attempts, got %+v", got)
+		}
+
+		app.Post(t, path+"/retry", nil).As(webtest.Admin).Do().Status(http.StatusConflict)
+		app.Get(t, path).As(webtest.Admin).Do().Status(http.StatusOK)
+		app.Get(t, "/v1/api/jobs/42").As(webtest.Admin).Do().Status(http.StatusNotFound)
+		app.Get(t, "/v1/api/jobs/abc").As(webtest.Admin).Do().Status(http.StatusBadRequest)
+	})
+}
@@ -5,8 +5,8 @@ import (
 	"github.com/esmaeilmirzaee/grage/internal/auth"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
 	"github.com/esmaeilmirzaee/grage/internal/product"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -14,15 +14,16 @@ import (
 	"github.com/go-chi/chi"
 )
 
-// ProductService is used to add database and log to a request.
+// ProductService is used to add the stores and log to a request.
 type ProductService struct {
-	DB  *sqlx.DB
-	Log *log.Logger
+	Products product.ProductStore
+	Sales    product.SaleStore
+	Log      *log.Logger
 }
 
 // List returns all the products stored in the database
 func (p *ProductService) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	list, err := product.List(r.Context(), p.DB)
+	list, err := p.Products.List(ctx)
 	if err != nil {
 		return err
 	}
@@ -33,7 +34,7 @@ func (p *ProductService) List(ctx context.Context, w http.ResponseWriter, r *htt
 // Retrieve returns a product to the browser
 func (p *ProductService) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
-	prod, err := product.Retrieve(ctx, p.DB, id)
+	prod, err := p.Products.Retrieve(ctx, id)
 	if err != nil {
 		switch err {
 		case product.ErrNotFound:
@@ -58,7 +59,7 @@ func (p *ProductService) Create(ctx context.Context, w http.ResponseWriter, r *h
 	if !ok {
 		return web.NewShutdownError("auth claims not in context")
 	}
-	prod, err := product.Create(ctx, p.DB, claims, np, time.Now())
+	prod, err := p.Products.Create(ctx, claims, np, time.Now())
 	if err != nil {
 		return err
 	}
@@ -66,6 +67,127 @@ func (p *ProductService) Create(ctx context.Context, w http.ResponseWriter, r *h
 	return web.Respond(ctx, w, prod, http.StatusCreated)
 }
 
+// Import creates the Products of a CSV or NDJSON upload, chosen by the
+// Content-Type or the format query parameter. With dry_run=true the rows are
+// only validated and with mode=best-effort the valid rows are created even if
+// others are not. An import failing validation responds 422 with the errors of
+// every row.
+func (p *ProductService) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	format, err := product.ParseFormat(firstNonEmpty(r.URL.Query().Get("format"), r.Header.Get("Content-Type")))
+	if err != nil {
+		return web.NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
+
+	opts := product.ImportOptions{
+		DryRun: r.URL.Query().Get("dry_run") == "true",
+	}
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "all-or-nothing":
+	case "best-effort":
+		opts.BestEffort = true
+	default:
+		err := errors.Errorf("unknown mode %q, expected all-or-nothing or best-effort", mode)
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	result, err := product.Import(ctx, p.Products, claims, r.Body, format, opts, time.Now())
+	if err != nil {
+		if err == product.ErrTooManyRows {
+			return web.NewRequestError(err, http.StatusRequestEntityTooLarge)
+		}
+		if merr, ok := err.(*product.MalformedError); ok {
+			if errors.Cause(merr.Err) == web.ErrBodyTooLarge {
+				return web.ErrBodyTooLarge
+			}
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "importing products")
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(result.Errors) > 0 && !opts.BestEffort:
+		status = http.StatusUnprocessableEntity
+	case result.Imported > 0:
+		status = http.StatusCreated
+	}
+
+	return web.Respond(ctx, w, result, status)
+}
+
+// Export writes every Product with its sales aggregates as CSV or NDJSON,
+// chosen by the format query parameter or the Accept header. CSV is the
+// default. Products are sent as they are read from the database.
+func (p *ProductService) Export(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	format, err := product.ParseFormat(firstNonEmpty(r.URL.Query().Get("format"), r.Header.Get("Accept"), "csv"))
+	if err != nil {
+		if r.URL.Query().Get("format") != "" {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		format = product.FormatCSV
+	}
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web values missing from context")
+	}
+
+	rows, err := p.Products.ListRows(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing products")
+	}
+	v.StatusCode = http.StatusOK
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="products.`+string(format)+`"`)
+
+	// Once rows are written the status can no longer change so a failure is
+	// only logged.
+	if err := product.Export(rows, &deadlineWriter{ctx: ctx, w: w}, format); err != nil {
+		p.Log.Printf("[%s] exporting products: %v", v.RequestID, err)
+	}
+
+	// The server still ends the response once the handler returned.
+	web.SetWriteDeadline(ctx, time.Now().Add(exportIdleTimeout))
+	return nil
+}
+
+// exportIdleTimeout is how long an export waits for a slow client to take
+// the next rows. It replaces the write timeout of the server, which would
+// otherwise cut long exports short.
+const exportIdleTimeout = time.Minute
+
+// deadlineWriter pushes the write deadline of the response forward before
+// every write.
+type deadlineWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// Write implements io.Writer.
+func (d *deadlineWriter) Write(b []byte) (int, error) {
+	err := web.SetWriteDeadline(d.ctx, time.Now().Add(exportIdleTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, errors.Wrap(err, "extending the write deadline")
+	}
+	return d.w.Write(b)
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 // Update decodes the body of a request to update an existing product. The ID
 // of the product is part of the request URL.
 func (p *ProductService) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -81,7 +203,7 @@ func (p *ProductService) Update(ctx context.Context, w http.ResponseWriter, r *h
 		return errors.Wrap(err, "decoding product update")
 	}
 
-	if err := product.Update(ctx, p.DB, claims, id, update, time.Now()); err != nil {
+	if err := p.Products.Update(ctx, claims, id, update, time.Now()); err != nil {
 		switch err {
 		case product.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -101,7 +223,7 @@ func (p *ProductService) Update(ctx context.Context, w http.ResponseWriter, r *h
 func (p *ProductService) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
 
-	if err := product.Delete(ctx, p.DB, id); err != nil {
+	if err := p.Products.Delete(ctx, id, time.Now()); err != nil {
 		switch err {
 		case product.ErrInvalidUUID:
 			return web.NewRequestError(err, http.StatusBadRequest)
@@ -113,11 +235,29 @@ func (p *ProductService) Delete(ctx context.Context, w http.ResponseWriter, r *h
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
 
-// ListSales returns all sales for a Product.
+// ListSales returns all sales for a Product. Histories can be long so they
+// are streamed as a JSON array, or NDJSON when asked for; other formats need
+// the whole list in memory.
 func (p *ProductService) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	id := chi.URLParam(r, "id")
 
-	lists, err := product.ListSales(r.Context(), p.DB, id)
+	rows, err := p.Sales.SalesRows(ctx, id)
+	if err != nil {
+		switch err {
+		case product.ErrInvalidUUID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "getting sales list")
+		}
+	}
+
+	var sale product.Sale
+	err = web.StreamRows(ctx, w, rows, &sale, http.StatusOK)
+	if err != web.ErrNotAcceptable {
+		return err
+	}
+
+	lists, err := p.Sales.ListSales(ctx, id)
 	if err != nil {
 		return errors.Wrap(err, "getting sales list")
 	}
@@ -134,9 +274,23 @@ func (p *ProductService) AddSale(ctx context.Context, w http.ResponseWriter, r *
 		return errors.Wrap(err, "decoding new sale")
 	}
 
-	sale, err := product.AddSale(ctx, p.DB, productID, ns, time.Now())
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	sale, err := p.Sales.AddSale(ctx, claims, productID, ns, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "adding new sale")
+		switch err {
+		case product.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case product.ErrInvalidUUID, product.ErrUnknownBuyer:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case product.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "adding new sale")
+		}
 	}
 
 	return web.Respond(ctx, w, sale, http.StatusCreated)
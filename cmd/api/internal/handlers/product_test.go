@@ -1,159 +1,205 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
-	"github.com/esmaeilmirzaee/grage/internal/schema"
-	"github.com/google/go-cmp/cmp"
-	"log"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
 	"net/http"
-	"net/http/httptest"
-	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // In the Go ecosystem it's anti-pattern to have folder named tests and store
 // testing files.
 
+// build wires the dependencies provided by webtest into the API.
+func build(deps webtest.Deps) http.Handler {
+	return API(APIConfig{
+		Shutdown:      deps.Shutdown,
+		Log:           deps.Log,
+		DB:            deps.DB,
+		Authenticator: deps.Authenticator,
+		Products:      deps.Products,
+		Sales:         deps.Sales,
+		Users:         deps.Users,
+		Events:        deps.Events,
+		Webhooks:      deps.Webhooks,
+		Jobs:          deps.Jobs,
+	})
+}
+
 // TestProducts runs a series of tests to exercise Product behavior from the
-// API level. The subsets all share the same database and application for
+// API level. The subsets all share the same stores and application for
 // speed and convenience. The downside is the order the tests ran matters
 // and one test may break if other tests are not ran before it. If a particular
 // subset needs a fresh instance of the application it can make it, or it
 // should be its own Test* function.
 func TestProducts(t *testing.T) {
-	db, teardown := databasetest.Setup(t)
-	defer teardown()
-
-	if err := schema.Seed(db); err != nil {
-		t.Fatalf("Could not seed the database. %s", err)
+	app := webtest.New(t, build)
+
+	now := time.Now()
+	admin := app.Claims(t, webtest.Admin)
+	for _, np := range []product.NewProduct{
+		{Name: "Comic Books", Cost: 50, Quantity: 42},
+		{Name: "McDonalds Toys", Cost: 75, Quantity: 120},
+	} {
+		if _, err := app.Products.Create(context.Background(), admin, np, now); err != nil {
+			t.Fatalf("Could not seed the store. %s", err)
+		}
+		now = now.Add(time.Second)
 	}
 
-	log := log.New(os.Stderr, "Test: ", log.LstdFlags|log.Lshortfile)
-
-	tests := ProductTests{app: API(log, db)}
-
 	// The following lines create subtests.
-	// These tests use the same database and share the created database so their
-	// changes to the database could have behavior effect on the next one.
-	// The issue could be prevented by specifying or creating a new database
-	// for each subtests.
+	// These tests use the same stores so their changes could have behavior
+	// effect on the next one. The issue could be prevented by creating new
+	// stores for each subtests.
+	tests := ProductTests{app: app}
 	t.Run("List", tests.List)
 	t.Run("ProductCRUD", tests.ProductCRUD)
+	t.Run("Authorization", tests.Authorization)
 }
 
 // ProductTests holds methods for each Product subset. This type allows
 // passing dependencies for test while still providing a convenient syntax
 // when subsets are registered.
 type ProductTests struct {
-	app http.Handler
+	app *webtest.App
 }
 
 func (p *ProductTests) List(t *testing.T) {
-	// httptest is standard package that is really helpful to test against http API
-	req := httptest.NewRequest("GET", "/v1/api/products", nil)
-	resp := httptest.NewRecorder()
-
-	p.app.ServeHTTP(resp, req)
-
-	if resp.Code != http.StatusOK {
-		t.Fatalf("getting: expected %v, but got %v", http.StatusOK, resp.Code)
-	}
-
-	var list []map[string]interface{}
+	// Identifiers, owners and dates are generated so they are masked.
+	p.app.Get(t, "/v1/api/products").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Golden("id", "user_id", "created_at", "updated_at")
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
-		t.Fatalf("decoding: %s", err)
+func (p *ProductTests) ProductCRUD(t *testing.T) {
+	var created product.Product
+	p.app.Post(t, "/v1/api/products", product.NewProduct{Name: "product0", Cost: 55, Quantity: 6}).
+		As(webtest.Admin).Do().
+		Status(http.StatusCreated).
+		Decode(&created)
+
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Fatalf("expected a generated id and creation date, got %+v", created)
 	}
-
-	want := []map[string]interface{}{
-		{
-			"id":         "",
-			"name":       "Comic Book",
-			"cost":       float64(50),
-			"quantity":   float64(42),
-			"created_at": "",
-			"updated_at": "",
-		},
-		{
-			"id":         "",
-			"name":       "McDonalds Toys",
-			"cost":       float64(75),
-			"quantity":   float64(120),
-			"created_at": "",
-			"updated_at": "",
-		},
+	if want := p.app.Claims(t, webtest.Admin).Subject; created.UserID != want {
+		t.Fatalf("expected the product to be owned by %s, got %s", want, created.UserID)
 	}
 
-	if diff := cmp.Diff(want, list); diff != "" {
-		t.Fatalf("Response did not match expected. Diff:\n%s.", diff)
-	}
-}
+	// Fetched product should match the one we created.
+	p.app.Get(t, "/v1/api/products/"+created.ID).As(webtest.Admin).Do().
+		Status(http.StatusOK).
+		Equal(created)
 
-func (p *ProductTests) ProductCRUD(t *testing.T) {
-	var created map[string]interface{}
+	p.app.Put(t, "/v1/api/products/"+created.ID, `{"name": "product1"}`).As(webtest.Admin).Do().
+		Status(http.StatusNoContent)
 
-	{ // Create
-		body := strings.NewReader(`{"name": "product0", "cost": 55, "quantity": 6}`)
+	p.app.Delete(t, "/v1/api/products/"+created.ID).As(webtest.Admin).Do().
+		Status(http.StatusNoContent)
 
-		req := httptest.NewRequest("POST", "/v1/api/products", body)
-		req.Header.Set("Content-Type", "application/json; charset=utf8;")
-		resp := httptest.NewRecorder()
+	p.app.Get(t, "/v1/api/products/"+created.ID).As(webtest.Admin).Do().
+		Status(http.StatusNotFound)
+}
 
-		p.app.ServeHTTP(resp, req)
+func (p *ProductTests) Authorization(t *testing.T) {
+	p.app.Get(t, "/v1/api/products").Do().
+		Status(http.StatusUnauthorized)
+
+	var list []product.Product
+	p.app.Get(t, "/v1/api/products").As(webtest.User).Do().Decode(&list)
+
+	// Only admins may delete products or record sales.
+	p.app.Delete(t, "/v1/api/products/"+list[0].ID).As(webtest.User).Do().
+		Status(http.StatusForbidden)
+	p.app.Post(t, "/v1/api/products/"+list[0].ID+"/sales", product.NewSale{Quantity: 1, Paid: 50}).
+		As(webtest.User).Do().
+		Status(http.StatusForbidden)
+
+	// Nor may users change products they do not own.
+	p.app.Put(t, "/v1/api/products/"+list[0].ID, `{"cost": 1}`).As(webtest.User).Do().
+		Status(http.StatusForbidden)
+
+	// Sales are refused to sellers the store does not know.
+	unknown := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		deps.Sales = unknownSeller{deps.Sales}
+		return build(deps)
+	})
+	unknown.Post(t, "/v1/api/products/"+list[0].ID+"/sales", product.NewSale{Quantity: 1, Paid: 50}).
+		As(webtest.Admin).Do().
+		Status(http.StatusForbidden)
+}
 
-		if http.StatusCreated != resp.Code {
-			t.Fatalf("posting: expected status code %v, got %v", http.StatusCreated, resp.Code)
-		}
+// unknownSeller is a SaleStore that does not know the seller of any sale.
+type unknownSeller struct {
+	product.SaleStore
+}
 
-		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
-			t.Fatalf("decoding: %s", err)
-		}
+func (unknownSeller) AddSale(ctx context.Context, user auth.Claims, productID string, ns product.NewSale,
+	now time.Time) (*product.Sale, error) {
+	return nil, product.ErrForbidden
+}
 
-		if created["id"] == "" || created["id"] == nil {
-			t.Fatal("expected non-empty product id")
-		}
+// TestProductTransfer checks that a product import reports invalid rows and
+// that exports carry the imported products.
+func TestProductTransfer(t *testing.T) {
+	app := webtest.New(t, build)
+
+	const csv = "name,cost,quantity\nComic Books,50,42\nMcDonalds Toys,-1,120\n"
+
+	// An invalid row fails the whole import by default.
+	var result product.ImportResult
+	app.Request(t, http.MethodPost, "/v1/api/products/import").
+		Body("text/csv", strings.NewReader(csv)).
+		As(webtest.User).Do().
+		Status(http.StatusUnprocessableEntity).
+		Decode(&result)
+	if result.Imported != 0 || len(result.Errors) != 1 || result.Errors[0].Line != 3 {
+		t.Fatalf("Expected a single error on line 3 and nothing imported, got %+v", result)
+	}
 
-		if created["created_at"] == "" || created["updated_at"] == nil {
-			t.Fatal("expected non-empty product date created.")
-		}
+	app.Request(t, http.MethodPost, "/v1/api/products/import?mode=best-effort").
+		Body("text/csv", strings.NewReader(csv)).
+		As(webtest.User).Do().
+		Status(http.StatusCreated)
 
-		want := map[string]interface{}{
-			"id":         created["id"],
-			"name":       "product0",
-			"cost":       float64(55),
-			"quantity":   float64(6),
-			"created_at": created["created_at"],
-			"updated_at": created["updated_at"],
-		}
+	resp := app.Get(t, "/v1/api/products/export?format=ndjson").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Type", "application/x-ndjson")
 
-		if diff := cmp.Diff(want, created); diff != "" {
-			t.Fatalf("Response did not match expected. Diff: %v", diff)
-		}
+	var exported product.Product
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected a single exported product, got %q", lines)
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &exported); err != nil {
+		t.Fatalf("Decoding export: %v", err)
+	}
+	if exported.Name != "Comic Books" || exported.Cost != 50 {
+		t.Fatalf("Unexpected exported product %+v", exported)
 	}
 
-	{ // READ
-		url := fmt.Sprintf("/v1/api/products/%s", created["id"])
-		req := httptest.NewRequest("GET", url, nil)
-		req.Header.Set("Content-Type", "application/json")
-		resp := httptest.NewRecorder()
-
-		p.app.ServeHTTP(resp, req)
-
-		if http.StatusOK != resp.Code {
-			t.Fatalf("retrieving: expected status code %v, got %v.", http.StatusOK, resp.Code)
-		}
+	// An export that can not list the products fails instead of sending an
+	// empty file.
+	broken := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		deps.Products = unlistable{deps.Products}
+		return build(deps)
+	})
+	broken.Get(t, "/v1/api/products/export").As(webtest.User).Do().
+		Status(http.StatusInternalServerError)
+}
 
-		var fetched map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&fetched); err != nil {
-			t.Fatalf("decoding: %v", err)
-		}
+// unlistable is a ProductStore whose products can not be listed.
+type unlistable struct {
+	product.ProductStore
+}
 
-		// Fetched product should match the one we created.
-		if diff := cmp.Diff(created, fetched); diff != "" {
-			t.Fatalf("Retrieved product did not match the created one. Diff: %v.", diff)
-		}
-	}
+func (unlistable) ListRows(ctx context.Context) (web.Rows, error) {
+	return nil, errors.New("database is down")
 }
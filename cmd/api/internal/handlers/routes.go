@@ -1,48 +1,156 @@
 package handlers
 
 import (
+	"context"
 	"github.com/esmaeilmirzaee/grage/internal/auth"
 	"github.com/esmaeilmirzaee/grage/internal/middleware"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/health"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
-	"github.com/jmoiron/sqlx"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
 	"log"
 	"net/http"
-	"os"
+	"time"
 )
 
+// APIConfig holds the dependencies of the handlers. The stores are interfaces
+// so tests can swap the Postgres implementations for in-memory ones.
+type APIConfig struct {
+	Shutdown      func(error)
+	Log           *log.Logger
+	LogLevel      *logger.Var // nil logs at Info
+	DB            *database.Cluster
+	Health        *health.Registry
+	Authenticator *auth.Authenticator
+	Products      product.ProductStore
+	Sales         product.SaleStore
+	Users         user.UserStore
+	Events        events.Feed
+	Webhooks      webhook.Store
+	Jobs          jobs.Store
+
+	// CORS is disabled without allowed origins. HSTS is only sent over TLS.
+	CORS       middleware.CORSConfig
+	HSTSMaxAge time.Duration
+
+	// Request bodies are limited to MaxBodyBytes, or MaxImportBytes for
+	// imports. Zero lifts the limit.
+	MaxBodyBytes   int64
+	MaxImportBytes int64
+
+	// HandlerTimeout is the deadline of the product routes, except imports,
+	// exports and sales which are only bounded by the server timeouts. Exports
+	// push the write timeout forward as they send rows. Zero disables it.
+	HandlerTimeout time.Duration
+
+	// Response bodies shorter than CompressMinBytes are not compressed.
+	CompressMinBytes int
+
+	// Event streams end after EventsMaxDuration and send a heartbeat every
+	// EventsHeartbeat while idle. Browsers open them with tokens valid for
+	// EventsTokenTTL.
+	EventsMaxDuration time.Duration
+	EventsHeartbeat   time.Duration
+	EventsTokenTTL    time.Duration
+}
+
 // API constructs a handler that knows about all routes
-func API(shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator) http.Handler {
+func API(cfg APIConfig) http.Handler {
 	// It is almost impossible to put auth middleware here because it would block
 	// all the routes; even the authentication mechanism
-	app := web.NewApp(shutdown, log, middleware.Logger(log), middleware.Errors(log), middleware.Metrics(),
-		middleware.Panics())
+	app := web.NewApp(cfg.Shutdown, cfg.Log, middleware.RequestID(), middleware.Logger(cfg.Log, cfg.LogLevel),
+		middleware.Compress(cfg.CompressMinBytes), middleware.Errors(cfg.Log),
+		middleware.Metrics(), middleware.Panics(), middleware.SecurityHeaders(cfg.HSTSMaxAge),
+		middleware.CORS(cfg.CORS), middleware.BodyLimit(cfg.MaxBodyBytes), middleware.ReadYourWrites())
 
+	// Preflights are answered by the CORS middleware. Any other OPTIONS
+	// request gets an empty answer.
+	app.Handle(http.MethodOptions, "/*", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	})
+
+	// Without a registry the service reports itself ready unconditionally.
 	c := Check{
-		DB: db,
+		Health: cfg.Health,
+	}
+	if c.Health == nil {
+		c.Health = health.NewRegistry(health.Build{})
 	}
-	app.Handle(http.MethodGet, "/v1/api/health", c.Health)
+	app.Handle(http.MethodGet, "/v1/api/health/live", c.Live)
+	app.Handle(http.MethodGet, "/v1/api/health/ready", c.Ready)
+
+	// Probes configured before the split still point here.
+	app.Handle(http.MethodGet, "/v1/api/health", c.Ready)
 
 	u := Users{
-		DB:            db,
-		authenticator: authenticator,
+		Users:         cfg.Users,
+		authenticator: cfg.Authenticator,
 	}
 	app.Handle(http.MethodGet, "/v1/api/users", u.Token)
 
 	p := ProductService{
-		DB:  db,
-		Log: log,
+		Products: cfg.Products,
+		Sales:    cfg.Sales,
+		Log:      cfg.Log,
 	}
+	authenticate := middleware.Authenticate(cfg.Authenticator)
+	timeout := middleware.Timeout(cfg.HandlerTimeout)
+
 	// the following routes require authorizations
-	app.Handle(http.MethodGet, "/v1/api/products", p.List, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodPost, "/v1/api/products", p.Create, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodGet, "/v1/api/products/{id}", p.Retrieve, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodPut, "/v1/api/products/{id}", p.Update, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodDelete, "/v1/api/products/{id}", p.Delete, middleware.Authenticate(authenticator),
-		middleware.HasRole(auth.RoleAdmin))
-
-	app.Handle(http.MethodGet, "/v1/api/products/{id}/sales", p.ListSales, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodPost, "/v1/api/products/{id}/sales", p.AddSale, middleware.Authenticate(authenticator),
-		middleware.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/api/products", p.List, authenticate, timeout)
+	app.Handle(http.MethodPost, "/v1/api/products", p.Create, authenticate, timeout)
+	app.Handle(http.MethodPost, "/v1/api/products/import", p.Import, authenticate,
+		middleware.BodyLimit(cfg.MaxImportBytes))
+	app.Handle(http.MethodGet, "/v1/api/products/export", p.Export, authenticate)
+	app.Handle(http.MethodGet, "/v1/api/products/{id}", p.Retrieve, authenticate, timeout)
+	app.Handle(http.MethodPut, "/v1/api/products/{id}", p.Update, authenticate, timeout)
+	app.Handle(http.MethodDelete, "/v1/api/products/{id}", p.Delete, authenticate,
+		middleware.HasRole(auth.RoleAdmin), timeout)
+
+	app.Handle(http.MethodGet, "/v1/api/products/{id}/sales", p.ListSales, authenticate)
+	app.Handle(http.MethodPost, "/v1/api/products/{id}/sales", p.AddSale, authenticate,
+		middleware.HasRole(auth.RoleAdmin), timeout)
+
+	// Streams outlive any handler timeout and the server write timeout;
+	// they end on their own.
+	// Browsers authenticate them with a short-lived token in the URL or a
+	// cookie instead of the Authorization header.
+	e := Events{
+		Feed:          cfg.Events,
+		MaxDuration:   cfg.EventsMaxDuration,
+		Heartbeat:     cfg.EventsHeartbeat,
+		Authenticator: cfg.Authenticator,
+		TokenTTL:      cfg.EventsTokenTTL,
+	}
+	app.Handle(http.MethodGet, "/v1/api/events", e.Stream,
+		middleware.AuthenticateBrowser(cfg.Authenticator, auth.AudienceEvents, eventsCookie))
+	app.Handle(http.MethodPost, "/v1/api/events/token", e.Token, authenticate)
+
+	wh := Webhooks{
+		Store:      cfg.Webhooks,
+		EventTypes: product.EventTypes,
+	}
+	admin := middleware.HasRole(auth.RoleAdmin)
+	app.Handle(http.MethodGet, "/v1/api/webhooks", wh.ListEndpoints, authenticate, admin, timeout)
+	app.Handle(http.MethodPost, "/v1/api/webhooks", wh.CreateEndpoint, authenticate, admin, timeout)
+	app.Handle(http.MethodDelete, "/v1/api/webhooks/{id}", wh.DeleteEndpoint, authenticate, admin, timeout)
+	app.Handle(http.MethodGet, "/v1/api/webhooks/deliveries", wh.ListDeliveries, authenticate, admin, timeout)
+	app.Handle(http.MethodGet, "/v1/api/webhooks/deliveries/{id}", wh.RetrieveDelivery, authenticate, admin, timeout)
+	app.Handle(http.MethodPost, "/v1/api/webhooks/deliveries/{id}/redeliver", wh.Redeliver, authenticate, admin,
+		timeout)
+
+	jb := Jobs{
+		Store: cfg.Jobs,
+	}
+	app.Handle(http.MethodGet, "/v1/api/jobs", jb.List, authenticate, admin, timeout)
+	app.Handle(http.MethodGet, "/v1/api/jobs/stats", jb.Stats, authenticate, admin, timeout)
+	app.Handle(http.MethodGet, "/v1/api/jobs/{id}", jb.Retrieve, authenticate, admin, timeout)
+	app.Handle(http.MethodPost, "/v1/api/jobs/{id}/retry", jb.Retry, authenticate, admin, timeout)
 
 	return app
 }
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/middleware"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// TestBrowserPolicies checks the CORS answers, the security headers and the
+// body limits applied to every route.
+func TestBrowserPolicies(t *testing.T) {
+	app := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		return API(APIConfig{
+			Shutdown:      deps.Shutdown,
+			Log:           deps.Log,
+			Authenticator: deps.Authenticator,
+			Products:      deps.Products,
+			Sales:         deps.Sales,
+			Users:         deps.Users,
+			CORS: middleware.CORSConfig{
+				AllowedOrigins: []string{"https://dashboard.example.com"},
+				AllowedMethods: []string{http.MethodGet, http.MethodPost},
+				AllowedHeaders: []string{"Authorization", "Content-Type"},
+				MaxAge:         10 * time.Minute,
+			},
+			HSTSMaxAge:     time.Hour,
+			MaxBodyBytes:   64,
+			MaxImportBytes: 1024,
+		})
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		app.Request(t, http.MethodOptions, "/v1/api/products/42").
+			Header("Origin", "https://dashboard.example.com").
+			Header("Access-Control-Request-Method", http.MethodPost).
+			Header("Access-Control-Request-Headers", "authorization, content-type").
+			Do().
+			Status(http.StatusNoContent).
+			Header("Access-Control-Allow-Origin", "https://dashboard.example.com").
+			Header("Access-Control-Allow-Methods", "GET, POST").
+			Header("Access-Control-Max-Age", "600")
+
+		app.Request(t, http.MethodOptions, "/v1/api/products").
+			Header("Origin", "https://dashboard.example.com").
+			Header("Access-Control-Request-Method", http.MethodDelete).
+			Do().
+			Status(http.StatusNoContent).
+			Header("Access-Control-Allow-Methods", "")
+
+		app.Request(t, http.MethodOptions, "/v1/api/products").
+			Header("Origin", "https://evil.example.com").
+			Header("Access-Control-Request-Method", http.MethodGet).
+			Do().
+			Status(http.StatusNoContent).
+			Header("Access-Control-Allow-Origin", "")
+	})
+
+	t.Run("SimpleRequest", func(t *testing.T) {
+		resp := app.Get(t, "/v1/api/products").Header("Origin", "https://dashboard.example.com").Do().
+			Status(http.StatusUnauthorized).
+			Header("Access-Control-Allow-Origin", "https://dashboard.example.com").
+			Header("X-Content-Type-Options", "nosniff").
+			Header("X-Frame-Options", "DENY").
+			Header("Strict-Transport-Security", "")
+		if vary := strings.Join(resp.Result().Header.Values("Vary"), ", "); !strings.Contains(vary, "Origin") {
+			t.Fatalf("Expected the response to vary by origin, got %q", vary)
+		}
+	})
+
+	t.Run("BodyLimit", func(t *testing.T) {
+		np := product.NewProduct{Name: strings.Repeat("x", 64), Cost: 1, Quantity: 1}
+		app.Post(t, "/v1/api/products", np).As(webtest.Admin).Do().
+			Status(http.StatusRequestEntityTooLarge)
+
+		// Imports have their own limit.
+		csv := "name,cost,quantity\n" + strings.Repeat("Comic Books,50,42\n", 10)
+		app.Request(t, http.MethodPost, "/v1/api/products/import?dry_run=true").
+			Body("text/csv", strings.NewReader(csv)).
+			As(webtest.Admin).Do().
+			Status(http.StatusOK)
+
+		csv = "name,cost,quantity\n" + strings.Repeat("Comic Books,50,42\n", 100)
+		app.Request(t, http.MethodPost, "/v1/api/products/import?dry_run=true").
+			Body("text/csv", strings.NewReader(csv)).
+			As(webtest.Admin).Do().
+			Status(http.StatusRequestEntityTooLarge)
+	})
+}
+
+// TestRequestID checks that every response carries the ID of its request and
+// that errors quote it.
+func TestRequestID(t *testing.T) {
+	app := webtest.New(t, build)
+
+	var er web.ErrorResponse
+	app.Get(t, "/v1/api/products").Header(web.RequestIDHeader, "client-42").Do().
+		Status(http.StatusUnauthorized).
+		Header(web.RequestIDHeader, "client-42").
+		Decode(&er)
+	if er.RequestID != "client-42" {
+		t.Fatalf("Expected the error to quote the request ID, got %+v", er)
+	}
+
+	// IDs that could forge log lines are replaced.
+	resp := app.Get(t, "/v1/api/health/live").Header(web.RequestIDHeader, "a b\nc").Do().
+		Status(http.StatusOK)
+	if id := resp.Result().Header.Get(web.RequestIDHeader); id == "" || id == "a b\nc" {
+		t.Fatalf("Expected a generated request ID, got %q", id)
+	}
+}
+
+// TestContentNegotiation checks that responses follow the Accept header and
+// bodies are read according to their Content-Type.
+func TestContentNegotiation(t *testing.T) {
+	app := webtest.New(t, build)
+
+	app.Request(t, http.MethodPost, "/v1/api/products").
+		Body("text/csv", strings.NewReader("name,cost,quantity\nComic Books,50,42\n")).
+		As(webtest.Admin).Do().
+		Status(http.StatusCreated)
+
+	resp := app.Get(t, "/v1/api/products").Header("Accept", "text/csv").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Type", "text/csv; charset=utf-8")
+	lines := strings.Split(resp.Body.String(), "\n")
+	if lines[0] != "product_id,name,cost,quantity,sold,revenue,user_id,created_at,updated_at" ||
+		!strings.Contains(lines[1], ",Comic Books,50,42,") {
+		t.Fatalf("Unexpected CSV:\n%s", resp.Body)
+	}
+
+	resp = app.Get(t, "/v1/api/products").Header("Accept", "application/msgpack").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Type", "application/msgpack")
+	var list []product.Product
+	dec := msgpack.NewDecoder(resp.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&list); err != nil {
+		t.Fatalf("Decoding MessagePack: %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "Comic Books" || list[0].Quantity != 42 {
+		t.Fatalf("Unexpected products %+v", list)
+	}
+
+	resp = app.Get(t, "/v1/api/products").Header("Accept", "application/xml;q=0.5, image/png").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Type", "application/xml; charset=utf-8")
+	if !strings.Contains(resp.Body.String(), "<list><item><ID>") {
+		t.Fatalf("Unexpected XML:\n%s", resp.Body)
+	}
+
+	// Browsers accept XML ahead of anything else but still get JSON, errors
+	// included.
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	app.Get(t, "/v1/api/products").Header("Accept", browser).As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Type", "application/json; charset=utf-8")
+	app.Get(t, "/v1/api/products/abc").Header("Accept", browser).As(webtest.User).Do().
+		Status(http.StatusBadRequest).
+		Header("Content-Type", "application/json; charset=utf-8")
+
+	// The report of the health check is not flat so it has no CSV form.
+	app.Get(t, "/v1/api/health/live").Header("Accept", "text/csv").Do().
+		Status(http.StatusNotAcceptable).
+		Header("Content-Type", "application/json; charset=utf-8")
+
+	app.Request(t, http.MethodPost, "/v1/api/products").
+		Body("application/yaml", strings.NewReader("name: Comic Books\n")).
+		As(webtest.Admin).Do().
+		Status(http.StatusUnsupportedMediaType)
+}
+
+// TestCompression checks that large responses, streamed ones included, are
+// compressed for clients accepting it while small ones are not.
+func TestCompression(t *testing.T) {
+	app := webtest.New(t, func(deps webtest.Deps) http.Handler {
+		return API(APIConfig{
+			Shutdown:         deps.Shutdown,
+			Log:              deps.Log,
+			Authenticator:    deps.Authenticator,
+			Products:         deps.Products,
+			Sales:            deps.Sales,
+			Users:            deps.Users,
+			CompressMinBytes: 256,
+		})
+	})
+
+	ctx := context.Background()
+	admin := app.Claims(t, webtest.Admin)
+	p, err := app.Products.Create(ctx, admin, product.NewProduct{Name: "Comic Books", Cost: 50, Quantity: 500}, time.Now())
+	if err != nil {
+		t.Fatalf("Seeding product: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := app.Sales.AddSale(ctx, admin, p.ID, product.NewSale{Quantity: 1, Paid: 50}, time.Now()); err != nil {
+			t.Fatalf("Seeding sale: %v", err)
+		}
+	}
+
+	resp := app.Get(t, "/v1/api/products/"+p.ID+"/sales").Header("Accept-Encoding", "gzip").As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Encoding", "gzip")
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading gzip: %v", err)
+	}
+	var sales []product.Sale
+	if err := json.NewDecoder(zr).Decode(&sales); err != nil || len(sales) != 200 {
+		t.Fatalf("Expected 200 sales, got %d: %v", len(sales), err)
+	}
+
+	resp = app.Get(t, "/v1/api/products/"+p.ID+"/sales").
+		Header("Accept", "application/x-ndjson").
+		Header("Accept-Encoding", "gzip;q=0.5, br").
+		As(webtest.User).Do().
+		Status(http.StatusOK).
+		Header("Content-Encoding", "br").
+		Header("Content-Type", web.NDJSONContentType)
+	body, err := io.ReadAll(brotli.NewReader(resp.Body))
+	if err != nil {
+		t.Fatalf("Reading brotli: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 200 {
+		t.Fatalf("Expected 200 lines, got %d", len(lines))
+	}
+
+	app.Get(t, "/v1/api/health/live").Header("Accept-Encoding", "gzip, br").Do().
+		Status(http.StatusOK).
+		Header("Content-Encoding", "").
+		Header("Vary", "Accept-Encoding")
+}
@@ -5,14 +5,13 @@ import (
 	"github.com/esmaeilmirzaee/grage/internal/auth"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
 	"github.com/esmaeilmirzaee/grage/internal/user"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"net/http"
 )
 
 // Users holds handlers for dealing with user.
 type Users struct {
-	DB            *sqlx.DB
+	Users         user.UserStore
 	authenticator *auth.Authenticator
 }
 
@@ -31,7 +30,7 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return web.NewRequestError(err, http.StatusUnauthorized)
 	}
 
-	claims, err := user.Authenticate(ctx, u.DB, v.Start, email, pass)
+	claims, err := u.Users.Authenticate(ctx, v.Start, email, pass)
 	if err != nil {
 		switch err {
 		case user.ErrAuthenticationFailure:
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Webhooks lets admins manage the webhook endpoints and inspect their
+// deliveries.
+type Webhooks struct {
+	Store webhook.Store
+
+	// EventTypes are the types endpoints may subscribe to.
+	EventTypes []string
+}
+
+// CreateEndpoint registers an endpoint. The response is the only one holding
+// its secret.
+func (wh *Webhooks) CreateEndpoint(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var ne webhook.NewEndpoint
+	if err := web.Decode(r, &ne); err != nil {
+		return err
+	}
+
+	for _, typ := range ne.EventTypes {
+		if !contains(wh.EventTypes, typ) {
+			return web.NewRequestError(errors.Errorf("unknown event type %q", typ), http.StatusBadRequest)
+		}
+	}
+
+	e, err := wh.Store.CreateEndpoint(ctx, ne, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "creating webhook endpoint")
+	}
+
+	return web.Respond(ctx, w, e, http.StatusCreated)
+}
+
+// ListEndpoints returns every endpoint.
+func (wh *Webhooks) ListEndpoints(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	list, err := wh.Store.ListEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// DeleteEndpoint removes an endpoint with its deliveries.
+func (wh *Webhooks) DeleteEndpoint(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	if err := wh.Store.DeleteEndpoint(ctx, id); err != nil {
+		switch err {
+		case webhook.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case webhook.ErrInvalidUUID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "deleting webhook endpoint %q", id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ListDeliveries returns the delivery log, newest first, narrowed by the
+// endpoint_id, status and limit query parameters.
+func (wh *Webhooks) ListDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	f := webhook.DeliveryFilter{
+		EndpointID: q.Get("endpoint_id"),
+		Status:     q.Get("status"),
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return web.NewRequestError(errors.Errorf("invalid limit %q", s), http.StatusBadRequest)
+		}
+		f.Limit = n
+	}
+
+	list, err := wh.Store.ListDeliveries(ctx, f)
+	if err != nil {
+		if err == webhook.ErrInvalidUUID {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "listing webhook deliveries")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveDelivery returns a delivery with every attempt made at it.
+func (wh *Webhooks) RetrieveDelivery(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id, err := deliveryID(r)
+	if err != nil {
+		return err
+	}
+
+	d, err := wh.Store.RetrieveDelivery(ctx, id)
+	if err != nil {
+		if err == webhook.ErrNotFound {
+			return web.NewRequestError(err, http.StatusNotFound)
+		}
+		return errors.Wrapf(err, "retrieving webhook delivery %d", id)
+	}
+
+	return web.Respond(ctx, w, d, http.StatusOK)
+}
+
+// Redeliver queues a delivery again. It is sent by the dispatcher shortly
+// after, so the response is 202 Accepted with the queued delivery.
+func (wh *Webhooks) Redeliver(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id, err := deliveryID(r)
+	if err != nil {
+		return err
+	}
+
+	if err := wh.Store.Redeliver(ctx, id, time.Now()); err != nil {
+		if err == webhook.ErrNotFound {
+			return web.NewRequestError(err, http.StatusNotFound)
+		}
+		return errors.Wrapf(err, "redelivering webhook delivery %d", id)
+	}
+
+	d, err := wh.Store.RetrieveDelivery(ctx, id)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving webhook delivery %d", id)
+	}
+
+	return web.Respond(ctx, w, d, http.StatusAccepted)
+}
+
+// deliveryID parses the delivery ID of the request URL.
+func deliveryID(r *http.Request) (int64, error) {
+	s := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, web.NewRequestError(errors.Errorf("invalid delivery ID %q", s), http.StatusBadRequest)
+	}
+	return id, nil
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web/webtest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestWebhooks checks that admins manage endpoints and inspect and redeliver
+// their deliveries through the API.
+func TestWebhooks(t *testing.T) {
+	app := webtest.New(t, build)
+
+	const body = `{"url": "https://partner.example.com/hooks", "event_types": ["sale.recorded", "product.sold_out"]}`
+
+	var created webhook.Endpoint
+	t.Run("CreateEndpoint", func(t *testing.T) {
+		app.Post(t, "/v1/api/webhooks", body).As(webtest.User).Do().
+			Status(http.StatusForbidden)
+
+		app.Post(t, "/v1/api/webhooks", `{"url": "https://partner.example.com", "event_types": ["sale.refunded"]}`).
+			As(webtest.Admin).Do().
+			Status(http.StatusBadRequest)
+
+		app.Post(t, "/v1/api/webhooks", body).As(webtest.Admin).Do().
+			Status(http.StatusCreated).
+			Decode(&created)
+		if created.Secret == "" {
+			t.Fatal("Expected the secret of the new endpoint")
+		}
+
+		var list []webhook.Endpoint
+		app.Get(t, "/v1/api/webhooks").As(webtest.Admin).Do().
+			Status(http.StatusOK).
+			Decode(&list)
+		if len(list) != 1 || list[0].ID != created.ID || list[0].Secret != "" {
+			t.Fatalf("Expected the endpoint without its secret, got %+v", list)
+		}
+	})
+
+	t.Run("Deliveries", func(t *testing.T) {
+		e := events.Event{ID: 1, Type: product.EventSaleRecorded, Subject: created.ID,
+			Data: json.RawMessage(`{}`), CreatedAt: time.Now()}
+		if err := app.Webhooks.(*webhook.Memory).Enqueue(context.Background(), e, time.Now()); err != nil {
+			t.Fatalf("Enqueuing: %v", err)
+		}
+
+		var list []webhook.Delivery
+		app.Get(t, "/v1/api/webhooks/deliveries?status=pending&endpoint_id="+created.ID).As(webtest.Admin).Do().
+			Status(http.StatusOK).
+			Decode(&list)
+		if len(list) != 1 || list[0].EventType != product.EventSaleRecorded {
+			t.Fatalf("Expected one pending delivery, got %+v", list)
+		}
+		path := "/v1/api/webhooks/deliveries/" + strconv.FormatInt(list[0].ID, 10)
+
+		app.Get(t, path).As(webtest.Admin).Do().Status(http.StatusOK)
+		app.Post(t, path+"/redeliver", nil).As(webtest.Admin).Do().Status(http.StatusAccepted)
+
+		app.Get(t, "/v1/api/webhooks/deliveries/42").As(webtest.Admin).Do().Status(http.StatusNotFound)
+		app.Get(t, "/v1/api/webhooks/deliveries/abc").As(webtest.Admin).Do().Status(http.StatusBadRequest)
+	})
+
+	t.Run("DeleteEndpoint", func(t *testing.T) {
+		app.Delete(t, "/v1/api/webhooks/"+created.ID).As(webtest.Admin).Do().Status(http.StatusNoContent)
+		app.Delete(t, "/v1/api/webhooks/"+created.ID).As(webtest.Admin).Do().Status(http.StatusNotFound)
+	})
+}
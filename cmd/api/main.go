@@ -3,29 +3,36 @@ package main
 import (
 	"context"
 	"contrib.go.opencensus.io/exporter/zipkin"
-	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
 	"github.com/ardanlabs/conf"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/esmaeilmirzaee/grage/cmd/api/internal/handlers"
 	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/middleware"
+	"github.com/esmaeilmirzaee/grage/internal/platform/certs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
 	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/debug"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/health"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/lifecycle"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"github.com/esmaeilmirzaee/grage/internal/worker"
 	"go.opencensus.io/trace"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
-	_ "github.com/ardanlabs/conf"
-
-	_ "expvar"         // Register the /debug/vars handler | metric middleware
-	_ "net/http/pprof" // Register the /debug/pprof handler | Profiling middleware
-
 	// Register OpenZipkin to send span
 	_ "contrib.go.opencensus.io/exporter/zipkin"
 	openzipkin "github.com/openzipkin/zipkin-go"
@@ -33,42 +40,25 @@ import (
 	_ "go.opencensus.io/trace"
 )
 
+// build and commit identify the binary in readiness reports. They are set at
+// link time:
+//
+//	go build -ldflags "-X main.build=v1.2.0 -X main.commit=$(git rev-parse --short HEAD)"
+var (
+	build  = "develop"
+	commit = "unknown"
+)
+
 func main() {
 	if err := run(); err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		os.Exit(lifecycle.ExitCode(err))
 	}
 }
 
 func run() error {
 	log := log.New(os.Stdout, "SALES | ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
 
-	var cfg struct {
-		Web struct {
-			Address         string        `conf:"default:localhost:5000"`
-			Debug           string        `conf:"default:localhost:6060"`
-			ReadTimeout     time.Duration `conf:"default:5s"`
-			WriteTimeout    time.Duration `conf:"default:5s"`
-			ShutdownTimeout time.Duration `conf:"default:5s"`
-		}
-		DB struct {
-			User       string `conf:"default:pgdmn"`
-			Password   string `conf:"default:secret,noprint"`
-			Name       string `conf:"default:garage"`
-			Host       string `conf:"default:192.168.101.2:5234"`
-			DisableTLS bool   `conf:"default:true"`
-		}
-		Auth struct {
-			PrivateKeyFile string `conf:"default:1"`
-			KeyID          string `conf:"default:private.pem"`
-			Algorithm      string `conf:"default:RS256"`
-		}
-		Trace struct {
-			URL         string  `conf:"default:http://192.168.101.2:9411/api/v2/spans"`
-			Service     string  `conf:"default:grage-api"`
-			Probability float64 `conf:"default:1"`
-		}
-	}
-
 	// =============================================================
 	// App starting
 	log.Printf("main: Started.")
@@ -76,19 +66,20 @@ func run() error {
 
 	// =============================================================
 	// Get configuration
-	if err := conf.Parse(os.Args[1:], "SALES | ", &cfg); err != nil {
+	cfg, _, err := config.Parse(os.Args[1:])
+	if err != nil {
 		if err == conf.ErrHelpWanted {
-			usage, err := conf.Usage("SALES", &cfg)
+			usage, err := config.Usage()
 			if err != nil {
 				return errors.Wrap(err, "generating config usage")
 			}
 			fmt.Println(usage)
 			return nil
 		}
-		return errors.Wrap(err, "Parsing config.")
+		return err
 	}
 
-	out, err := conf.String(&cfg)
+	out, err := cfg.String()
 	if err != nil {
 		return errors.Wrap(err, "Generating config output.")
 	}
@@ -96,114 +87,466 @@ func run() error {
 
 	// =============================================================
 	// Initialize authentication support
-	authenticator, err := createAuth(cfg.Auth.PrivateKeyFile, cfg.Auth.KeyID, cfg.Auth.Algorithm)
+	authenticator, err := createAuth(cfg.Auth.PrivateKeyFile, cfg.Auth.KeyID, cfg.Auth.Algorithm, cfg.Auth.PublicKeyFiles)
 	if err != nil {
 		return errors.Wrap(err, "constructing authenticator")
 	}
 
+	// =============================================================
+	// Runtime settings. Both may be changed on the debug server while the
+	// service runs.
+	// probability is a percentage of requests that should be monitored
+	// 1 equals 100%; or all the requests and 0.1 means 10%
+	sampler := debug.NewSampler(cfg.Trace.Probability)
+	level, err := logger.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	logLevel := logger.NewVar(level)
+
+	// =============================================================
+	// Load the certificate of the API
+	apiTLS, reloader, err := apiTLSConfig(cfg.TLS)
+	if err != nil {
+		return err
+	}
+
+	var debugSrv *http.Server
+	if cfg.Web.Debug != "" {
+		debugSrv, err = debugServer(cfg, log, logLevel, sampler)
+		if err != nil {
+			return err
+		}
+	}
+
 	// =============================================================
 	// Setup dependencies
 	// Start database
-	db, err := database.Open(database.Config{
-		Host:       cfg.DB.Host,
-		Name:       cfg.DB.Name,
-		User:       cfg.DB.User,
-		Password:   cfg.DB.Password,
-		DisableTLS: cfg.DB.DisableTLS,
-	})
+	dbConfig := database.ClusterConfig{
+		Primary: database.Config{
+			Host:       cfg.DB.Host,
+			Name:       cfg.DB.Name,
+			User:       cfg.DB.User,
+			Password:   cfg.DB.Password,
+			DisableTLS: cfg.DB.DisableTLS,
+		},
+		MaxLag:        cfg.DB.MaxReplicaLag,
+		CheckInterval: cfg.DB.ReplicaCheckInterval,
+	}
+	for _, host := range cfg.DB.Replicas {
+		replica := dbConfig.Primary
+		replica.Host = host
+		dbConfig.Replicas = append(dbConfig.Replicas, replica)
+	}
+
+	db, err := database.OpenCluster(dbConfig)
 	if err != nil {
 		return errors.Wrap(err, "Could not connect to database.")
 	}
 
 	// =============================================================
 	// Start tracing session
-	closer, err := registerTracer(cfg.Trace.Service, cfg.Web.Address, cfg.Trace.URL, cfg.Trace.Probability)
+	flush, err := registerTracer(cfg.Trace.Service, cfg.Web.Address, cfg.Trace.URL)
 	if err != nil {
+		db.Close()
 		return err
 	}
-	defer closer()
 
 	// =============================================================
-	// Start Debug Service
-	go func() {
-		log.Println("main: Profile API is listening on %v", cfg.Web.Debug)
-		err := http.ListenAndServe(cfg.Web.Debug, http.DefaultServeMux)
-		log.Println("main: Debug service ended %v", err)
-	}()
+	// Register readiness checks
+	checks := health.NewRegistry(health.Build{Version: build, Commit: commit})
+	if err := registerChecks(checks, db, authenticator, cfg.Trace.URL); err != nil {
+		flush()
+		db.Close()
+		return err
+	}
+
+	// =============================================================
+	// Wire the components. They stop in the reverse order: readiness first
+	// so load balancers stop sending traffic, then the HTTP servers drain,
+	// the debug server stops and the workers return, then the tracer flushes
+	// the spans of the last requests and the database closes. The drain
+	// delay comes on top of the shutdown timeout.
+	m := lifecycle.New(log, cfg.Web.DrainDelay+cfg.Web.ShutdownTimeout)
+	m.Add(lifecycle.Component{
+		Name: "database",
+		Stop: func(ctx context.Context) error {
+			return db.Close()
+		},
+	})
+	m.Add(lifecycle.Component{
+		Name: "tracer",
+		Stop: func(ctx context.Context) error {
+			// Spans are best effort; losing the last ones must not fail
+			// the shutdown.
+			if err := flush(); err != nil {
+				log.Printf("main: flushing spans: %v", err)
+			}
+			return nil
+		},
+	})
+
+	if reloader != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.Add(lifecycle.Component{
+			Name: "certificate reloader",
+			Run: func() error {
+				reloader.Watch(ctx, cfg.TLS.ReloadInterval, log)
+				return nil
+			},
+			Stop: func(context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+	}
+
+	// Every instance relays the events committed by any of them to its own
+	// streams.
+	feed := events.NewPostgres(db)
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		m.Add(lifecycle.Component{
+			Name: "events listener",
+			Run: func() error {
+				return feed.Listen(ctx, database.DSN(dbConfig.Primary), log)
+			},
+			Stop: func(context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+	}
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	// Every instance running jobs takes from the same queue; a job is claimed
+	// by one at a time. Webhooks are sent by a job too. Stopping the runner
+	// cancels the jobs in flight, which are queued again, so they end within
+	// the shutdown timeout.
+	if cfg.Jobs.Run {
+		runner, err := worker.NewRunner(cfg, db, log)
+		if err != nil {
+			flush()
+			db.Close()
+			return errors.Wrap(err, "registering jobs")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		m.Add(lifecycle.Component{
+			Name: "job runner",
+			Run: func() error {
+				runner.Run(ctx)
+				return nil
+			},
+			Stop: func(context.Context) error {
+				cancel()
+				return nil
+			},
+		})
+	}
 
-	// Start API Service
+	// The debug server outlives the API so the shutdown can be watched, and
+	// stops before the workers.
+	if debugSrv != nil {
+		m.Add(serverComponent("debug server", log, debugSrv))
+	}
+
+	products := product.NewPostgres(db)
 	api := http.Server{
-		Addr:         cfg.Web.Address,
-		ReadTimeout:  cfg.Web.ReadTimeout,
-		WriteTimeout: cfg.Web.WriteTimeout,
-		Handler:      handlers.API(shutdown, log, db, authenticator),
+		Addr:              cfg.Web.Address,
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		ReadHeaderTimeout: cfg.Web.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Web.WriteTimeout,
+		IdleTimeout:       cfg.Web.IdleTimeout,
+		MaxHeaderBytes:    cfg.Web.MaxHeaderBytes,
+		TLSConfig:         apiTLS,
+		Handler: handlers.API(handlers.APIConfig{
+			Shutdown: func(err error) {
+				m.Shutdown(lifecycle.Integrity, err)
+			},
+			Log:           log,
+			LogLevel:      logLevel,
+			DB:            db,
+			Health:        checks,
+			Authenticator: authenticator,
+			Products:      products,
+			Sales:         products,
+			Users:         user.NewPostgres(db),
+			Events:        feed,
+			Webhooks:      webhook.NewPostgres(db),
+			Jobs:          jobs.NewPostgres(db),
+			CORS: middleware.CORSConfig{
+				AllowedOrigins:   cfg.CORS.AllowedOrigins,
+				AllowedMethods:   cfg.CORS.AllowedMethods,
+				AllowedHeaders:   cfg.CORS.AllowedHeaders,
+				ExposedHeaders:   cfg.CORS.ExposedHeaders,
+				AllowCredentials: cfg.CORS.AllowCredentials,
+				MaxAge:           cfg.CORS.MaxAge,
+			},
+			HSTSMaxAge:        cfg.TLS.HSTSMaxAge,
+			MaxBodyBytes:      cfg.Web.MaxBodyBytes,
+			MaxImportBytes:    cfg.Web.MaxImportBytes,
+			HandlerTimeout:    cfg.Web.HandlerTimeout,
+			CompressMinBytes:  cfg.Web.CompressMinBytes,
+			EventsMaxDuration: cfg.Web.EventsMaxDuration,
+			EventsHeartbeat:   cfg.Web.EventsHeartbeat,
+			EventsTokenTTL:    cfg.Web.EventsTokenTTL,
+		}),
+	}
+	if apiTLS != nil && !cfg.TLS.HTTP2 {
+		// A non-nil map keeps net/http from negotiating HTTP/2.
+		api.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	m.Add(serverComponent("api server", log, &api))
+
+	if cfg.TLS.RedirectAddress != "" {
+		redirect := http.Server{
+			Addr:              cfg.TLS.RedirectAddress,
+			ReadHeaderTimeout: cfg.Web.ReadHeaderTimeout,
+			IdleTimeout:       cfg.Web.IdleTimeout,
+			MaxHeaderBytes:    cfg.Web.MaxHeaderBytes,
+			Handler:           web.RedirectHTTPS(cfg.Web.Address),
+		}
+		m.Add(serverComponent("redirect server", log, &redirect))
 	}
 
-	serverErrors := make(chan error, 1)
+	m.Add(lifecycle.Component{
+		Name: "readiness",
+		Stop: func(ctx context.Context) error {
+			checks.Drain()
 
-	go func() {
-		log.Println("main: Api is listening on ", api.Addr)
-		serverErrors <- api.ListenAndServe()
-	}()
+			// Load balancers only notice at their next probe, so the
+			// servers keep taking new requests meanwhile.
+			select {
+			case <-time.After(cfg.Web.DrainDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		},
+	})
+
+	return m.Run()
+}
+
+// serverComponent runs srv until it is shut down. Requests in flight are
+// given until the shutdown deadline to complete; connections still open
+// after that are closed.
+func serverComponent(name string, log *log.Logger, srv *http.Server) lifecycle.Component {
+	return lifecycle.Component{
+		Name: name,
+		Run: func() error {
+			log.Printf("main: %s is listening on %s", name, srv.Addr)
+
+			// Certificates come with the TLS config.
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return errors.Wrap(err, "draining connections")
+			}
+			return nil
+		},
+	}
+}
+
+// apiTLSConfig constructs the TLS config of the API server and the reloader
+// of its certificate. Both are nil when no certificate is configured.
+func apiTLSConfig(cfg config.TLS) (*tls.Config, *certs.Reloader, error) {
+	if cfg.CertFile == "" {
+		return nil, nil, nil
+	}
+
+	reloader, err := certs.NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	minVersion, err := certs.ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tc := tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+	}
+	if cfg.ClientCA != "" {
+		pool, err := certs.LoadPool(cfg.ClientCA)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "loading client CA")
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == "require" {
+			tc.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return &tc, reloader, nil
+}
 
-	select {
-	case err := <-serverErrors:
-		return errors.Wrap(err, "Listening and serving")
-	case sig := <-shutdown:
-		log.Println("main: Start shutdown", sig)
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
-		defer cancel()
+// debugServer constructs the debug server. It serves TLS and verifies client
+// certificates when a client CA is configured; the token, if any, is then
+// enough for clients without a certificate.
+func debugServer(cfg *config.Config, log *log.Logger, level *logger.Var, sampler *debug.Sampler) (*http.Server, error) {
+	dc := debug.Config{
+		Log:     log,
+		Token:   cfg.Debug.Token,
+		Pprof:   cfg.Debug.Pprof,
+		Expvar:  cfg.Debug.Expvar,
+		Metrics: cfg.Debug.Metrics,
+		Settings: &debug.Settings{
+			Log:     log,
+			Level:   level,
+			Sampler: sampler,
+		},
+	}
+	if cfg.Debug.Config {
+		dc.Config = cfg.String
+	}
+
+	srv := http.Server{
+		Addr:              cfg.Web.Debug,
+		ReadHeaderTimeout: cfg.Web.ReadTimeout,
+	}
 
-		err := api.Shutdown(ctx)
+	if cfg.Debug.ClientCA != "" {
+		pool, err := certs.LoadPool(cfg.Debug.ClientCA)
 		if err != nil {
-			return errors.Wrap(err, "main: Grceful shut down did not complete.")
+			return nil, errors.Wrap(err, "loading debug client CA")
 		}
 
+		cert, err := tls.LoadX509KeyPair(cfg.Debug.CertFile, cfg.Debug.KeyFile)
 		if err != nil {
-			return errors.Wrap(err, "Could not gracefully shut down server.")
+			return nil, errors.Wrap(err, "loading debug server certificate")
 		}
 
-		if sig == syscall.SIGSTOP {
-			return errors.New("Integrity error detected. Asking for sel shutdown")
+		clientAuth := tls.RequireAndVerifyClientCert
+		if cfg.Debug.Token != "" {
+			clientAuth = tls.VerifyClientCertIfGiven
 		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   clientAuth,
+			MinVersion:   tls.VersionTLS12,
+		}
+		dc.ClientCerts = true
 	}
 
-	return nil
+	srv.Handler = debug.Mux(dc)
+	return &srv, nil
 }
 
-// createAuth creates an x509 private key.
-func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, error) {
-	keyContents, err := ioutil.ReadFile(privateKeyFile)
+// createAuth creates an authenticator signing with the private key and
+// verifying with it and the additional public keys.
+func createAuth(privateKeyFile, keyID, algorithm string, publicKeyFiles []string) (*auth.Authenticator, error) {
+	keys, err := auth.LoadKeys(privateKeyFile, keyID, publicKeyFiles)
 	if err != nil {
-		return nil, errors.Wrap(err, "reading auth private key")
+		return nil, err
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContents)
+	return auth.NewAuthenticator(keys.Private, keys.ActiveKID, algorithm, keys.Lookup)
+}
+
+// registerChecks adds the dependencies of the API to the readiness checks.
+// Replicas and the trace exporter are optional: reads fall back to the
+// primary and spans are dropped without them.
+func registerChecks(checks *health.Registry, db *database.Cluster, authenticator *auth.Authenticator,
+	traceURL string) error {
+	migrator, err := schema.NewMigrator(db.Primary())
 	if err != nil {
-		return nil, errors.Wrap(err, "passing auth private key")
+		return errors.Wrap(err, "loading migrations")
+	}
+
+	checks.Register(health.Checker{
+		Name:    "db",
+		Timeout: time.Second,
+		Check: func(ctx context.Context) error {
+			return database.StatusCheck(ctx, db.Primary())
+		},
+	})
+	checks.Register(health.Checker{
+		Name:    "migrations",
+		Timeout: 2 * time.Second,
+		Check:   migrator.Check,
+	})
+	checks.Register(health.Checker{
+		Name:    "signing key",
+		Timeout: time.Second,
+		Check: func(ctx context.Context) error {
+			return authenticator.Check()
+		},
+	})
+
+	if len(db.Replicas()) > 0 {
+		checks.Register(health.Checker{
+			Name:     "db replicas",
+			Optional: true,
+			Check: func(ctx context.Context) error {
+				var unhealthy []string
+				for _, r := range db.Replicas() {
+					if !r.Healthy {
+						unhealthy = append(unhealthy, r.Host+": "+r.Error)
+					}
+				}
+				if len(unhealthy) > 0 {
+					return errors.Errorf("unhealthy replicas: %s", strings.Join(unhealthy, "; "))
+				}
+				return nil
+			},
+		})
 	}
 
-	public := auth.NewSimpleKeyLookup(keyID, key.Public().(*rsa.PublicKey))
-	return auth.NewAuthenticator(key, keyID, algorithm, public)
+	checks.Register(health.Checker{
+		Name:     "trace exporter",
+		Timeout:  2 * time.Second,
+		Optional: true,
+		Check: func(ctx context.Context) error {
+			// Any response proves the collector is reachable; it only
+			// accepts spans by POST.
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, traceURL, nil)
+			if err != nil {
+				return err
+			}
+			client := http.Client{Transport: &web.Transport{}}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		},
+	})
+
+	return nil
 }
 
-// registerTracer registers for a zipkin tracer
-// probability is a percentage of requests that should be monitored
-// 1 equals 100%; or all the requests and 0.1 means 10%
-func registerTracer(service, httpAddr, traceURL string, probability float64) (func() error, error) {
+// registerTracer registers for a zipkin tracer and returns the function
+// flushing it. The share of requests traced is set by a debug.Sampler.
+func registerTracer(service, httpAddr, traceURL string) (func() error, error) {
 	localEndPoint, err := openzipkin.NewEndpoint(service, httpAddr)
 	if err != nil {
 		return nil, errors.Wrap(err, "Creating local endpoint zipkin")
 	}
 	reporter := zipkinHTTP.NewReporter(traceURL)
 
-	trace.RegisterExporter(zipkin.NewExporter(reporter, localEndPoint))
-	trace.ApplyConfig(trace.Config{
-		DefaultSampler: trace.ProbabilitySampler(probability),
-	})
+	exporter := zipkin.NewExporter(reporter, localEndPoint)
+	trace.RegisterExporter(exporter)
 
-	return reporter.Close, nil
+	// Spans are exported in batches. Unregistering first makes sure nothing
+	// is added while the reporter flushes the last batch.
+	flush := func() error {
+		trace.UnregisterExporter(exporter)
+		return reporter.Close()
+	}
+	return flush, nil
 }
@@ -0,0 +1,104 @@
+// Command worker runs the background jobs of the service apart from the API.
+// Any number of workers may run against the same database; turn jobs off in
+// the API with --jobs-run=false once they do.
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ardanlabs/conf"
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/lifecycle"
+	"github.com/esmaeilmirzaee/grage/internal/worker"
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Println(err)
+		os.Exit(lifecycle.ExitCode(err))
+	}
+}
+
+func run() error {
+	log := log.New(os.Stdout, "WORKER | ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
+
+	// =============================================================
+	// App starting
+	log.Printf("main: Started.")
+	defer log.Println("main: Ended.")
+
+	// =============================================================
+	// Get configuration
+	cfg, _, err := config.Parse(os.Args[1:])
+	if err != nil {
+		if err == conf.ErrHelpWanted {
+			usage, err := config.Usage()
+			if err != nil {
+				return errors.Wrap(err, "generating config usage")
+			}
+			fmt.Println(usage)
+			return nil
+		}
+		return err
+	}
+
+	out, err := cfg.String()
+	if err != nil {
+		return errors.Wrap(err, "Generating config output.")
+	}
+	log.Printf("main: Config \n%v\n", out)
+
+	// =============================================================
+	// Start database. Jobs only use the primary.
+	db, err := database.OpenCluster(database.ClusterConfig{
+		Primary: database.Config{
+			Host:       cfg.DB.Host,
+			Name:       cfg.DB.Name,
+			User:       cfg.DB.User,
+			Password:   cfg.DB.Password,
+			DisableTLS: cfg.DB.DisableTLS,
+		},
+		MaxLag:        cfg.DB.MaxReplicaLag,
+		CheckInterval: cfg.DB.ReplicaCheckInterval,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not connect to database.")
+	}
+
+	runner, err := worker.NewRunner(cfg, db, log)
+	if err != nil {
+		db.Close()
+		return errors.Wrap(err, "registering jobs")
+	}
+
+	// =============================================================
+	// Wire the components. Stopping the runner cancels the jobs in flight
+	// and waits for them to be queued again before the database closes.
+	m := lifecycle.New(log, cfg.Web.ShutdownTimeout)
+	m.Add(lifecycle.Component{
+		Name: "database",
+		Stop: func(ctx context.Context) error {
+			return db.Close()
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.Add(lifecycle.Component{
+		Name: "job runner",
+		Run: func() error {
+			runner.Run(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+
+	return m.Run()
+}
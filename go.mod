@@ -1,9 +1,11 @@
 module github.com/esmaeilmirzaee/grage
 
-go 1.17
+go 1.20
 
 require (
 	contrib.go.opencensus.io/exporter/zipkin v0.1.2
+	github.com/BurntSushi/toml v1.2.1
+	github.com/andybalholm/brotli v1.0.4
 	github.com/ardanlabs/conf v1.5.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-chi/chi v1.5.4
@@ -15,19 +17,21 @@ require (
 	github.com/lib/pq v1.2.0
 	github.com/openzipkin/zipkin-go v0.3.0
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	go.opencensus.io v0.23.0
 	golang.org/x/crypto v0.0.0-20211209193657-4570a0811e8b
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 	gopkg.in/go-playground/validator.v9 v9.31.0
+	gopkg.in/yaml.v2 v2.4.0
+	nhooyr.io/websocket v1.8.7
 )
 
 require (
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
+	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 )
-
-require (
-	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
-	github.com/GuiaBolso/darwin v0.0.0-20191218124601-fd6d2aa3d244
-	github.com/cznic/ql v1.2.0 // indirect
-)
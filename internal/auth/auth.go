@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"time"
 )
 
 // KeyLookupFunc is used to map a JWT Key ID (KID) to the corresponding public
@@ -127,3 +128,16 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 
 	return claims, nil
 }
+
+// Check signs a throwaway token with the active key and verifies it again. It
+// fails when the signing key or its public half cannot be used.
+func (a *Authenticator) Check() error {
+	tkn, err := a.GenerateToken(NewClaims("health-check", nil, time.Now(), time.Minute))
+	if err != nil {
+		return err
+	}
+	if _, err := a.ParseClaims(tkn); err != nil {
+		return errors.Wrapf(err, "verifying with key %q", a.activeKID)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// ClaimsFromCertificate builds the Claims of an internal caller that
+// authenticated with a client certificate. The subject is the common name of
+// the certificate and the roles are its organizational units that name a
+// role. The claims last as long as the certificate is valid. Certificates
+// of callers acting as a user carry the ID of the user as their common name;
+// other names are not user IDs and may not be recorded as such.
+func ClaimsFromCertificate(cert *x509.Certificate, now time.Time) Claims {
+	var roles []string
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == RoleAdmin || ou == RoleUser {
+			roles = append(roles, ou)
+		}
+	}
+
+	c := NewClaims(cert.Subject.CommonName, roles, now, cert.NotAfter.Sub(now))
+	c.Issuer = cert.Issuer.CommonName
+	return c
+}
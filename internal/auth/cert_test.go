@@ -0,0 +1,33 @@
+package auth_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+func TestClaimsFromCertificate(t *testing.T) {
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	cert := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "6a84703c-caaf-4c94-a0a7-b131e395abdf",
+			OrganizationalUnit: []string{"ADMIN", "billing"},
+		},
+		Issuer:   pkix.Name{CommonName: "internal CA"},
+		NotAfter: now.Add(24 * time.Hour),
+	}
+
+	c := auth.ClaimsFromCertificate(&cert, now)
+	if c.Subject != cert.Subject.CommonName || c.Issuer != "internal CA" {
+		t.Fatalf("Unexpected claims %+v", c)
+	}
+	if diff := cmp.Diff([]string{auth.RoleAdmin}, c.Roles); diff != "" {
+		t.Fatalf("Only known roles should be kept. Diff:\n%s", diff)
+	}
+	if c.ExpiresAt != cert.NotAfter.Unix() {
+		t.Fatalf("Expected the claims to expire with the certificate, got %d", c.ExpiresAt)
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Keys holds the private key tokens are signed with and every public key
+// tokens are verified with, by key id. Keeping the public keys of retired
+// private keys lets tokens signed before a rotation stay valid.
+type Keys struct {
+	Private   *rsa.PrivateKey
+	ActiveKID string
+	Public    map[string]*rsa.PublicKey
+}
+
+// LoadKeys reads the PEM encoded private key used to sign tokens as activeKID
+// and the additional PEM encoded public keys, each given as KID=path.
+func LoadKeys(privateKeyFile, activeKID string, publicKeyFiles []string) (*Keys, error) {
+	contents, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading auth private key")
+	}
+
+	private, err := jwt.ParseRSAPrivateKeyFromPEM(contents)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing auth private key")
+	}
+
+	k := Keys{
+		Private:   private,
+		ActiveKID: activeKID,
+		Public:    map[string]*rsa.PublicKey{activeKID: &private.PublicKey},
+	}
+
+	for _, entry := range publicKeyFiles {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("public key %q is not of the form KID=path", entry)
+		}
+		kid, path := parts[0], parts[1]
+
+		if _, ok := k.Public[kid]; ok {
+			return nil, errors.Errorf("key id %q is configured twice", kid)
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading public key %q", kid)
+		}
+
+		public, err := jwt.ParseRSAPublicKeyFromPEM(contents)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing public key %q", kid)
+		}
+		k.Public[kid] = public
+	}
+
+	return &k, nil
+}
+
+// Lookup is the KeyLookupFunc over the public keys.
+func (k *Keys) Lookup(kid string) (*rsa.PublicKey, error) {
+	public, ok := k.Public[kid]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized key id %q", kid)
+	}
+	return public, nil
+}
+
+// KIDs returns the ids of the public keys in order.
+func (k *Keys) KIDs() []string {
+	kids := make([]string, 0, len(k.Public))
+	for kid := range k.Public {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// Fingerprint identifies a public key by the SHA-256 hash of its DER
+// encoding, in the form ssh-keygen prints.
+func Fingerprint(public *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding public key")
+	}
+
+	sum := sha256.Sum256(der)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
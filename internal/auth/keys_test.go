@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadKeys checks that tokens signed with a retired key are still
+// verified once its public key is configured.
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Writing %s: %v", name, err)
+		}
+		return path
+	}
+
+	generate := func() *rsa.PrivateKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Generating key: %v", err)
+		}
+		return key
+	}
+
+	old, current := generate(), generate()
+	oldDER, err := x509.MarshalPKIXPublicKey(&old.PublicKey)
+	if err != nil {
+		t.Fatalf("Encoding public key: %v", err)
+	}
+
+	private := write("current.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(current))
+	public := write("old.pub", "PUBLIC KEY", oldDER)
+
+	keys, err := auth.LoadKeys(private, "2", []string{"1=" + public})
+	if err != nil {
+		t.Fatalf("Loading keys: %v", err)
+	}
+	if diff := cmp.Diff([]string{"1", "2"}, keys.KIDs()); diff != "" {
+		t.Fatalf("Unexpected key ids. Diff:\n%s", diff)
+	}
+
+	// A token signed with the retired key before the rotation.
+	retired, err := auth.NewAuthenticator(old, "1", "RS256", keys.Lookup)
+	if err != nil {
+		t.Fatalf("Creating authenticator: %v", err)
+	}
+	tkn, err := retired.GenerateToken(auth.NewClaims("gopher", []string{auth.RoleUser}, time.Now(), time.Hour))
+	if err != nil {
+		t.Fatalf("Generating token: %v", err)
+	}
+
+	a, err := auth.NewAuthenticator(keys.Private, keys.ActiveKID, "RS256", keys.Lookup)
+	if err != nil {
+		t.Fatalf("Creating authenticator: %v", err)
+	}
+	if _, err := a.ParseClaims(tkn); err != nil {
+		t.Fatalf("Parsing token signed with the retired key: %v", err)
+	}
+
+	if err := a.Check(); err != nil {
+		t.Fatalf("Checking the active key: %v", err)
+	}
+	mismatched, err := auth.NewAuthenticator(old, "2", "RS256", keys.Lookup)
+	if err != nil {
+		t.Fatalf("Creating authenticator: %v", err)
+	}
+	if err := mismatched.Check(); err == nil {
+		t.Fatal("Expected the check to fail when the key id names another key")
+	}
+
+	fp1, _ := auth.Fingerprint(keys.Public["1"])
+	fp2, _ := auth.Fingerprint(&current.PublicKey)
+	if fp1 == fp2 {
+		t.Fatalf("Expected distinct fingerprints, got %s twice", fp1)
+	}
+
+	if _, err := auth.LoadKeys(private, "2", []string{"2=" + public}); err == nil {
+		t.Fatal("Expected an error for a key id configured twice")
+	}
+	if _, err := auth.LoadKeys(private, "2", []string{public}); err == nil {
+		t.Fatal("Expected an error for a public key without a key id")
+	}
+}
@@ -18,6 +18,11 @@ type ctxKey int
 // Key is used to store/retrieve Claims value from a context.Context
 const Key ctxKey = 1
 
+// AudienceEvents is the audience of the short-lived tokens browsers pass to
+// the event stream in its URL or a cookie, as EventSource and WebSocket can
+// not send an Authorization header. They are good for nothing else.
+const AudienceEvents = "events"
+
 // Claims represents the authorization claims transmitted via a JWT.
 type Claims struct {
 	Roles []string `json:"roles"`
@@ -4,17 +4,26 @@ import (
 	"context"
 	"github.com/esmaeilmirzaee/grage/internal/auth"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // ErrForbidden is returned when an authenticated user does not have a
 // sufficient role for an action
 var ErrForbidden = web.NewRequestError(errors.New("You are not authorized for that action"), http.StatusForbidden)
 
-// Authenticate validates a JWT from the 'Authorization' token.
+// errServiceCertificate is returned when a certificate whose common name is
+// not a user ID is used to change data.
+var errServiceCertificate = web.NewRequestError(errors.New("Only certificates naming a user may change data"),
+	http.StatusForbidden)
+
+// Authenticate validates a JWT from the 'Authorization' token. Requests
+// without the header that came with a client certificate the server verified
+// are authenticated by the certificate instead.
 func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
@@ -24,6 +33,19 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			ctx, span := trace.StartSpan(ctx, "internal.middleware.auth")
 			defer span.End()
 
+			// Internal callers may present a certificate instead of a token.
+			// Chains are only verified when the server trusts a client CA.
+			// Changes are recorded against the caller, so a certificate
+			// naming a service rather than a user may only read.
+			if r.Header.Get("Authorization") == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				claims := auth.ClaimsFromCertificate(r.TLS.VerifiedChains[0][0], time.Now())
+				if _, err := uuid.Parse(claims.Subject); err != nil && !readOnly(r.Method) {
+					return errServiceCertificate
+				}
+				ctx = context.WithValue(ctx, auth.Key, claims)
+				return after(ctx, w, r)
+			}
+
 			// Parse the authorization header. Expected header is of
 			// the format <Bearer> token.
 			parts := strings.Split(r.Header.Get("Authorization"), " ")
@@ -40,6 +62,12 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 			}
 			span.End()
 
+			// Tokens issued for a single route are good for nothing else.
+			if claims.Audience != "" {
+				err := errors.Errorf("Token is only valid for %s", claims.Audience)
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
 			// Add claims to the context, so they can be retrieved later.
 			ctx = context.WithValue(ctx, auth.Key, claims)
 
@@ -52,6 +80,57 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// AccessTokenParam is the query parameter carrying the token of clients that
+// can not send an Authorization header.
+const AccessTokenParam = "access_token"
+
+// AuthenticateBrowser authenticates like Authenticate but also takes a token
+// from the AccessTokenParam query parameter or from cookie, for browser APIs
+// such as EventSource and WebSocket that can not send an Authorization
+// header. Those must be tokens issued for audience: URLs end up in logs and
+// histories, so they should carry nothing that outlives a connection.
+func AuthenticateBrowser(authenticator *auth.Authenticator, audience, cookie string) web.Middleware {
+	authenticate := Authenticate(authenticator)
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		header := authenticate(after)
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			tkn := r.URL.Query().Get(AccessTokenParam)
+			if c, err := r.Cookie(cookie); tkn == "" && err == nil {
+				tkn = c.Value
+			}
+			if tkn == "" || r.Header.Get("Authorization") != "" {
+				return header(ctx, w, r)
+			}
+
+			claims, err := authenticator.ParseClaims(tkn)
+			if err != nil {
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+			if !claims.VerifyAudience(audience, true) {
+				err := errors.Errorf("Token is not valid for %s", audience)
+				return web.NewRequestError(err, http.StatusUnauthorized)
+			}
+
+			ctx = context.WithValue(ctx, auth.Key, claims)
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
+
+// readOnly reports whether requests with method only read data.
+func readOnly(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
 // HasRole validates that an authenticated user has at least one role from
 // a specified list. This method constructs the actual function that is used.
 func HasRole(roles ...string) web.Middleware {
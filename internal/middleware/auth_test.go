@@ -0,0 +1,56 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/esmaeilmirzaee/grage/internal/middleware"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestAuthenticateCertificate checks that a client certificate naming a
+// service may read but not change data, while one naming a user may do both.
+func TestAuthenticateCertificate(t *testing.T) {
+	shutdown := func(err error) {
+		t.Errorf("Integrity shutdown requested: %v", err)
+	}
+	app := web.NewApp(shutdown, log.New(io.Discard, "", 0), middleware.Errors(log.New(io.Discard, "", 0)))
+
+	ok := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+	authenticate := middleware.Authenticate(nil)
+	app.Handle(http.MethodGet, "/things", ok, authenticate)
+	app.Handle(http.MethodPost, "/things", ok, authenticate)
+
+	tests := []struct {
+		name   string
+		method string
+		want   int
+	}{
+		{"billing", http.MethodGet, http.StatusNoContent},
+		{"billing", http.MethodPost, http.StatusForbidden},
+		{"6a84703c-caaf-4c94-a0a7-b131e395abdf", http.MethodPost, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		cert := &x509.Certificate{
+			Subject:  pkix.Name{CommonName: tt.name, OrganizationalUnit: []string{"ADMIN"}},
+			NotAfter: time.Now().Add(time.Hour),
+		}
+		req := httptest.NewRequest(tt.method, "/things", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.name, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compress compresses response bodies with brotli or gzip, whichever the
+// client prefers in Accept-Encoding, brotli winning ties. Bodies shorter
+// than minSize are sent as they are since compressing them costs more than
+// it saves; a handler flushing before minSize bytes were written is
+// streaming, so its body is compressed.
+//
+// It must run before Errors so error responses are compressed too.
+func Compress(minSize int) web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				return after(ctx, w, r)
+			}
+
+			cw := compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+			}
+			err := after(ctx, &cw, r)
+			if cerr := cw.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			return err
+		}
+		return h
+	}
+	return f
+}
+
+// negotiateEncoding returns "br", "gzip" or an empty string when the client
+// accepts neither.
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, err := mime.ParseMediaType("x/" + strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		name = strings.TrimPrefix(name, "x/")
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch name {
+		case "*":
+			name = "br"
+		case "br", "gzip":
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter holds the start of a body back until it knows whether the
+// body is worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when the body is sent as it is
+}
+
+// WriteHeader implements http.ResponseWriter. The status is sent with the
+// first bytes of the body, once they tell whether it is compressed.
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		return w.write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.minSize {
+		return len(p), nil
+	}
+	if err := w.decide(true); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher. A handler flushing is streaming so the
+// rest of its body is compressed.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for handlers upgrading the connection.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can not be hijacked")
+	}
+	return h.Hijack()
+}
+
+// Close sends what is still held back and ends the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 {
+			return nil
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// decide sends the header with or without compression and writes what was
+// held back.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || w.status < http.StatusOK ||
+		w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		compress = false
+	}
+
+	if compress {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		switch w.encoding {
+		case "br":
+			w.enc = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		default:
+			w.enc = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.write(buf)
+	return err
+}
+
+// write sends p through the encoder, if any.
+func (w *compressWriter) write(p []byte) (int, error) {
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"net/http"
+	"strings"
+)
+
+// ReadYourWritesHeader lets a client that just changed something ask for its
+// follow-up reads to skip the replicas.
+const ReadYourWritesHeader = "X-Read-Your-Writes"
+
+// ReadYourWrites routes every read of a request to the primary database when
+// the client sent the ReadYourWritesHeader.
+func ReadYourWrites() web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if strings.EqualFold(r.Header.Get(ReadYourWritesHeader), "true") {
+				ctx = database.ReadYourWrites(ctx)
+			}
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
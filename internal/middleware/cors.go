@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lists what browsers on other origins may do with the API.
+type CORSConfig struct {
+	// AllowedOrigins are matched exactly, ignoring case. "*" allows any
+	// origin. Without origins CORS is disabled.
+	AllowedOrigins []string
+
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache the answer to a preflight.
+	MaxAge time.Duration
+}
+
+// CORS answers preflight requests and adds the CORS headers to the responses
+// of allowed origins. Requests from other origins are served without the
+// headers, so browsers refuse to hand the response to the calling page.
+func CORS(cfg CORSConfig) web.Middleware {
+	anyOrigin := false
+	origins := make(map[string]bool)
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(o)] = true
+	}
+
+	methods := make(map[string]bool)
+	for _, m := range cfg.AllowedMethods {
+		methods[strings.ToUpper(m)] = true
+	}
+
+	headers := make(map[string]bool)
+	for _, h := range cfg.AllowedHeaders {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			origin := r.Header.Get("Origin")
+			if len(origins) == 0 || origin == "" {
+				return after(ctx, w, r)
+			}
+
+			// The answer depends on the origin so caches must keep one per
+			// origin, unless any origin gets the same answer.
+			if !anyOrigin || cfg.AllowCredentials {
+				w.Header().Add("Vary", "Origin")
+			}
+			if !anyOrigin && !origins[strings.ToLower(origin)] {
+				return after(ctx, w, r)
+			}
+
+			// Credentials can not be combined with the wildcard so the origin
+			// is echoed instead.
+			allowOrigin := origin
+			if anyOrigin && !cfg.AllowCredentials {
+				allowOrigin = "*"
+			}
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || method == "" {
+				if len(cfg.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+				return after(ctx, w, r)
+			}
+
+			// This is a preflight. Leaving out the allow headers when the
+			// method or a header is not allowed makes the browser fail the
+			// actual request.
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !methods[strings.ToUpper(method)] || !allowed(headers, r.Header.Get("Access-Control-Request-Headers")) {
+				return web.Respond(ctx, w, nil, http.StatusNoContent)
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+			if len(cfg.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+			}
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge/time.Second)))
+			}
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+		return h
+	}
+	return f
+}
+
+// allowed tells whether every header of the comma separated list requested is
+// in headers.
+func allowed(headers map[string]bool, requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
@@ -10,7 +10,9 @@ import (
 
 // Errors handle errors coming out of the call chain. It detects normal
 // application errors which are used to respond to the client in a uniform
-// way. Unexpected errors (status >= 500) are logged.
+// way. Unexpected errors (status >= 500) are logged. Requests canceled by
+// the client are not errors: nothing is sent and they are logged with the
+// status web.StatusClientClosedRequest.
 func Errors(log *log.Logger) web.Middleware {
 	// This is the actual middleware function to be executed.
 	f := func(before web.Handler) web.Handler {
@@ -22,9 +24,18 @@ func Errors(log *log.Logger) web.Middleware {
 
 			// Run the handler chain and catch any propagated error.
 			if err := before(ctx, w, r); err != nil {
+				// A client that went away can not be answered. Its request
+				// is logged as such instead of as a failure.
+				if ctx.Err() == context.Canceled {
+					if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+						v.StatusCode = web.StatusClientClosedRequest
+					}
+					return nil
+				}
+
 				// log the error
 				// Extended display message
-				log.Printf("Error %+v, ", err)
+				log.Printf("[%s] Error %+v, ", web.RequestID(ctx), err)
 
 				// Respond to the error
 				if err := web.RespondError(ctx, w, err); err != nil {
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"io"
+	"net/http"
+)
+
+// BodyLimit fails reading request bodies larger than n bytes with
+// web.ErrBodyTooLarge, which responds 413. Zero or less lifts the limit.
+//
+// Applied to a route it replaces the limit the App applies to every request,
+// so routes taking uploads can allow more.
+func BodyLimit(n int64) web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if r.Body == nil || r.Body == http.NoBody {
+				return after(ctx, w, r)
+			}
+
+			// The outermost limit wraps the body. Limits closer to the handler
+			// only change it.
+			if body, ok := r.Body.(*limitedBody); ok {
+				body.limit = n
+				return after(ctx, w, r)
+			}
+
+			r.Body = &limitedBody{
+				ReadCloser: r.Body,
+				limit:      n,
+				length:     r.ContentLength,
+			}
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
+
+// limitedBody is a request body that can not be read past its limit. The
+// check is left to the first read, once every BodyLimit of the route had the
+// chance to change the limit.
+type limitedBody struct {
+	io.ReadCloser
+	limit  int64
+	length int64 // Content-Length, -1 when unknown
+	read   int64
+}
+
+// Read implements io.Reader.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.ReadCloser.Read(p)
+	}
+	if b.length > b.limit || b.read > b.limit {
+		return 0, web.ErrBodyTooLarge
+	}
+
+	// Reading one byte past the limit tells a body of exactly limit bytes
+	// from a larger one.
+	if max := b.limit - b.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), web.ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
 	"github.com/esmaeilmirzaee/grage/internal/platform/web"
 	"go.opencensus.io/trace"
 	"log"
@@ -9,8 +10,11 @@ import (
 	"time"
 )
 
-// Logger will log a line for every
-func Logger(log *log.Logger) web.Middleware {
+// Logger will log a line for every request. Requests that failed on the
+// server are logged at Error and the others at Info; at Debug the headers of
+// the request are logged too, except for credentials. level may be changed
+// while the service runs.
+func Logger(log *log.Logger, level *logger.Var) web.Middleware {
 	// This is the actual middleware to be executed.
 	f := func(before web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -27,7 +31,16 @@ func Logger(log *log.Logger) web.Middleware {
 			// Run the handler
 			err := before(ctx, w, r)
 
-			log.Printf("%s: %d [%s %s] -> %s (%s)", v.TraceID, v.StatusCode, r.Method, r.URL.Path, r.RemoteAddr, time.Since(v.Start))
+			lvl := logger.Info
+			if v.StatusCode >= http.StatusInternalServerError {
+				lvl = logger.Error
+			}
+			if level.Enabled(lvl) {
+				log.Printf("[%s] %d [%s %s] -> %s (%s) trace %s", v.RequestID, v.StatusCode, r.Method, r.URL.Path, r.RemoteAddr, time.Since(v.Start), v.TraceID)
+			}
+			if level.Enabled(logger.Debug) {
+				log.Printf("[%s] headers %v", v.RequestID, redactHeaders(r.Header))
+			}
 
 			return err
 		}
@@ -35,3 +48,15 @@ func Logger(log *log.Logger) web.Middleware {
 	}
 	return f
 }
+
+// redactHeaders copies h without the values of the headers carrying
+// credentials.
+func redactHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, k := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+		if _, ok := out[k]; ok {
+			out[k] = []string{"<redacted>"}
+		}
+	}
+	return out
+}
@@ -11,13 +11,15 @@ import (
 
 // m contains the global program counters for the application.
 var m = struct {
-	gr  *expvar.Int
-	req *expvar.Int
-	err *expvar.Int
+	gr       *expvar.Int
+	req      *expvar.Int
+	err      *expvar.Int
+	canceled *expvar.Int
 }{
-	gr:  expvar.NewInt("goroutines"),
-	req: expvar.NewInt("requests"),
-	err: expvar.NewInt("errors"),
+	gr:       expvar.NewInt("goroutines"),
+	req:      expvar.NewInt("requests"),
+	err:      expvar.NewInt("errors"),
+	canceled: expvar.NewInt("canceled"),
 }
 
 // Metrics updates program counters.
@@ -40,7 +42,11 @@ func Metrics() web.Middleware {
 			}
 
 			// Increment the errors counter if an error occurred on this request.
-			if err != nil {
+			// Requests the client canceled are counted on their own.
+			switch {
+			case err != nil && ctx.Err() == context.Canceled:
+				m.canceled.Add(1)
+			case err != nil:
 				m.err.Add(1)
 			}
 
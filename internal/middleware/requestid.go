@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// maxRequestIDLength bounds the IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// RequestID takes the ID of the request from the X-Request-ID header, or
+// generates one, and stores it in the web values so it is logged and
+// returned with errors. The ID is echoed in the response headers.
+//
+// It must run before Logger and Errors for them to see the ID.
+func RequestID() web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				return web.NewShutdownError("Web values missing from context")
+			}
+
+			id := r.Header.Get(web.RequestIDHeader)
+			if !validRequestID(id) {
+				id = uuid.New().String()
+			}
+			v.RequestID = id
+			w.Header().Set(web.RequestIDHeader, id)
+
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
+
+// validRequestID tells whether id is short and only made of characters that
+// can not forge log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecurityHeaders sets the headers that keep browsers from sniffing content
+// types or framing responses. Responses sent over TLS also tell browsers to
+// only use HTTPS for hstsMaxAge; zero leaves HSTS out.
+func SecurityHeaders(hstsMaxAge time.Duration) web.Middleware {
+	hsts := "max-age=" + strconv.Itoa(int(hstsMaxAge/time.Second)) + "; includeSubDomains"
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("X-Frame-Options", "DENY")
+			w.Header().Set("Referrer-Policy", "no-referrer")
+			if r.TLS != nil && hstsMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+			return after(ctx, w, r)
+		}
+		return h
+	}
+	return f
+}
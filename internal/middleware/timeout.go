@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+// Timeout gives the handler of a route d to finish. Its context is canceled
+// once d passed, which stops the queries it runs, and a handler failing after
+// the deadline responds 503. Zero or less leaves the route without a deadline.
+func Timeout(d time.Duration) web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			if d <= 0 {
+				return after(ctx, w, r)
+			}
+
+			tctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+
+			err := after(tctx, w, r)
+
+			// The deadline only explains the failure when the client is still
+			// waiting; otherwise Errors sees a canceled request.
+			if err != nil && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+				err := errors.Errorf("request did not complete within %s", d)
+				return web.NewRequestError(err, http.StatusServiceUnavailable)
+			}
+			return err
+		}
+		return h
+	}
+	return f
+}
@@ -0,0 +1,55 @@
+package middleware_test
+
+import (
+	"context"
+	"expvar"
+	"github.com/esmaeilmirzaee/grage/internal/middleware"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestTimeout checks that a route past its deadline responds 503 while a
+// request the client canceled is neither answered nor counted as an error.
+func TestTimeout(t *testing.T) {
+	shutdown := func(err error) {
+		t.Errorf("Integrity shutdown requested: %v", err)
+	}
+	app := web.NewApp(shutdown, log.New(io.Discard, "", 0), middleware.Errors(log.New(io.Discard, "", 0)), middleware.Metrics())
+
+	// wait blocks until its context is done, like a query would.
+	wait := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	app.Handle(http.MethodGet, "/slow", wait, middleware.Timeout(10*time.Millisecond))
+	app.Handle(http.MethodGet, "/unbounded", wait)
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusServiceUnavailable, rec.Code, rec.Body)
+	}
+
+	counter := func(name string) int64 {
+		return expvar.Get(name).(*expvar.Int).Value()
+	}
+	errs, canceled := counter("errors"), counter("canceled")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/unbounded", nil).WithContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	rec = httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if rec.Body.Len() != 0 {
+		t.Fatalf("Expected no response to a canceled request, got %s", rec.Body)
+	}
+	if counter("errors") != errs || counter("canceled") != canceled+1 {
+		t.Fatalf("Expected the request counted as canceled, got errors %d canceled %d", counter("errors")-errs, counter("canceled")-canceled)
+	}
+}
@@ -0,0 +1,143 @@
+// Package certs serves TLS certificates that are renewed on disk, as done by
+// cert-manager or certbot, without restarting the process.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ParseVersion reads a TLS version written as "1.2" or "1.3". Older versions
+// are not accepted.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, errors.Errorf("unsupported TLS version %q, expected 1.2 or 1.3", v)
+}
+
+// LoadPool reads the PEM encoded certificates of file into a pool.
+func LoadPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CA file")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
+
+// Reloader holds a certificate and its key and loads them again when their
+// files change. It is safe for concurrent use.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate and key. It fails when they cannot be
+// used, so a broken pair is caught at startup.
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetCertificate returns the current certificate. It is meant for
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the certificate and key again. The current pair is kept when
+// the new one cannot be used.
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "loading certificate")
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Watch checks the files every interval and reloads them once either one
+// changed. It returns when ctx is done. Failed reloads are logged and retried
+// on the next tick.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, log *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			log.Printf("certs: checking %s: %v", r.certFile, err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("certs: reloading %s: %v", r.certFile, err)
+			continue
+		}
+		log.Printf("certs: reloaded %s", r.certFile)
+	}
+}
+
+// latestModTime returns when the certificate or the key last changed.
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "checking certificate files")
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
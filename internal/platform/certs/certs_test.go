@@ -0,0 +1,113 @@
+package certs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/esmaeilmirzaee/grage/internal/platform/certs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePair writes a self-signed certificate for name and its key to dir.
+func writePair(t *testing.T, dir, name string, modTime time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generating key: %v", err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Encoding key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("Writing %s: %v", file, err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf("Touching %s: %v", file, err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// commonName returns the name the reloader currently serves.
+func commonName(t *testing.T, r *certs.Reloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Getting certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Parsing certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	certFile, keyFile := writePair(t, dir, "old.example.com", start)
+
+	r, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Loading: %v", err)
+	}
+	if got := commonName(t, r); got != "old.example.com" {
+		t.Fatalf("Expected old.example.com, got %s", got)
+	}
+
+	// A broken pair is rejected and the current one kept.
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("Writing key: %v", err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Expected a broken key to be rejected")
+	}
+	if got := commonName(t, r); got != "old.example.com" {
+		t.Fatalf("Expected the old certificate to be kept, got %s", got)
+	}
+
+	writePair(t, dir, "new.example.com", start.Add(time.Minute))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reloading: %v", err)
+	}
+	if got := commonName(t, r); got != "new.example.com" {
+		t.Fatalf("Expected new.example.com, got %s", got)
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, v := range []string{"1.0", "1.1", "TLS1.3", ""} {
+		if _, err := certs.ParseVersion(v); err == nil {
+			t.Errorf("Expected %q to be rejected", v)
+		}
+	}
+	if v, err := certs.ParseVersion("1.3"); err != nil || v == 0 {
+		t.Errorf("Expected 1.3 to be accepted, got %v, %v", v, err)
+	}
+}
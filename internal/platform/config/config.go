@@ -0,0 +1,376 @@
+// Package config holds the configuration shared by the commands of the
+// project. Values are layered: defaults, then a YAML or TOML config file,
+// then environment variables, then command line flags. Secrets can be read
+// from files, such as those mounted by an orchestrator, by setting the
+// environment variable of a field with a _FILE suffix, for example
+// SALES_DB_PASSWORD_FILE=/run/secrets/db-password.
+package config
+
+import (
+	"fmt"
+	"github.com/ardanlabs/conf"
+	"github.com/esmaeilmirzaee/grage/internal/platform/certs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Namespace prefixes every environment variable, as in SALES_DB_HOST.
+const Namespace = "SALES"
+
+// Web configures the HTTP servers.
+type Web struct {
+	Address         string        `conf:"default:localhost:5000"`
+	Debug           string        `conf:"default:localhost:6060"`
+	ReadTimeout     time.Duration `conf:"default:5s"`
+	WriteTimeout    time.Duration `conf:"default:5s"`
+	ShutdownTimeout time.Duration `conf:"default:5s"`
+
+	// DrainDelay is how long the API keeps serving once it reports it is
+	// draining, before the servers shut down, so load balancers notice in
+	// time. It comes on top of ShutdownTimeout.
+	DrainDelay time.Duration `conf:"default:5s,help:time between failing readiness and shutting the servers down"`
+
+	// ReadHeaderTimeout and MaxHeaderBytes bound what a client may send
+	// before the handler runs. IdleTimeout closes keep-alive connections.
+	ReadHeaderTimeout time.Duration `conf:"default:5s"`
+	IdleTimeout       time.Duration `conf:"default:2m"`
+	MaxHeaderBytes    int           `conf:"default:1048576"`
+
+	// MaxBodyBytes limits request bodies. Imports take whole files so they
+	// have their own limit.
+	MaxBodyBytes   int64 `conf:"default:1048576"`
+	MaxImportBytes int64 `conf:"default:33554432"`
+
+	// HandlerTimeout stops the work of a request the client can no longer be
+	// answered in time. It must be shorter than WriteTimeout so the 503
+	// still reaches the client.
+	HandlerTimeout time.Duration `conf:"default:4s,help:deadline of the product handlers; 0 disables it"`
+
+	// CompressMinBytes is the size from which responses are compressed for
+	// clients accepting brotli or gzip.
+	CompressMinBytes int `conf:"default:1024"`
+
+	// Event streams are not bounded by WriteTimeout. They end after
+	// EventsMaxDuration and clients resume where they left off. Idle streams
+	// send a heartbeat every EventsHeartbeat so proxies keep them open.
+	// Browsers open them with tokens valid for EventsTokenTTL.
+	EventsMaxDuration time.Duration `conf:"default:30m,help:how long an event stream lasts before the client reconnects"`
+	EventsHeartbeat   time.Duration `conf:"default:15s"`
+	EventsTokenTTL    time.Duration `conf:"default:1m,help:how long browsers may open event streams with a token"`
+}
+
+// CORS configures which browser origins may call the API. It is disabled
+// without allowed origins.
+type CORS struct {
+	AllowedOrigins   []string      `conf:"help:origins separated by ';' or * for any"`
+	AllowedMethods   []string      `conf:"default:GET;POST;PUT;DELETE"`
+	AllowedHeaders   []string      `conf:"default:Authorization;Content-Type;X-Read-Your-Writes;X-Request-ID"`
+	ExposedHeaders   []string      `conf:"default:X-Request-ID,help:response headers scripts may read separated by ';'"`
+	AllowCredentials bool          `conf:"default:false"`
+	MaxAge           time.Duration `conf:"default:10m,help:how long browsers cache a preflight"`
+}
+
+// TLS configures HTTPS on Web.Address. The API serves plain HTTP without a
+// certificate.
+type TLS struct {
+	CertFile string `conf:"help:PEM certificate, reloaded when the file changes"`
+	KeyFile  string `conf:"help:PEM private key, reloaded when the file changes"`
+
+	MinVersion     string        `conf:"default:1.2,help:1.2 or 1.3"`
+	ReloadInterval time.Duration `conf:"default:1m,help:how often the certificate files are checked for changes"`
+	HTTP2          bool          `conf:"default:true"`
+
+	// Internal callers may authenticate with a certificate issued by ClientCA
+	// instead of a token.
+	ClientCA   string `conf:"help:PEM file of the CAs issuing certificates to internal callers"`
+	ClientAuth string `conf:"default:optional,help:optional or require; only used with a client CA"`
+
+	RedirectAddress string `conf:"help:plain HTTP listener redirecting to HTTPS, such as :80"`
+
+	// HSTSMaxAge is how long browsers only use HTTPS once they saw the API
+	// over TLS. Zero leaves the header out.
+	HSTSMaxAge time.Duration `conf:"default:8760h"`
+}
+
+// Debug configures the debug server listening on Web.Debug. Unless it listens
+// on a loopback address it needs a token, client certificates or both.
+type Debug struct {
+	Token string `conf:"mask,help:bearer token the debug server requires"`
+
+	// ClientCA turns on mTLS: clients presenting a certificate issued by one
+	// of these CAs do not need the token.
+	ClientCA string `conf:"help:PEM file of the CAs client certificates must be issued by"`
+	CertFile string `conf:"help:certificate of the debug server, required with a client CA"`
+	KeyFile  string `conf:"help:private key of the debug server, required with a client CA"`
+
+	Pprof   bool `conf:"default:true"`
+	Expvar  bool `conf:"default:true"`
+	Metrics bool `conf:"default:true"`
+	Config  bool `conf:"default:false,help:serve the effective config with secrets redacted"`
+}
+
+// Log configures logging.
+type Log struct {
+	Level string `conf:"default:info,help:debug, info or error; may be changed on the debug server"`
+}
+
+// DB configures the database cluster.
+type DB struct {
+	User       string `conf:"default:pgdmn"`
+	Password   string `conf:"default:secret,mask"`
+	Name       string `conf:"default:garage"`
+	Host       string `conf:"default:localhost:5432"`
+	DisableTLS bool   `conf:"default:true"`
+
+	// Replicas share the credentials and database name of the primary.
+	Replicas             []string      `conf:"help:read replica hosts separated by ';'"`
+	MaxReplicaLag        time.Duration `conf:"default:5s"`
+	ReplicaCheckInterval time.Duration `conf:"default:5s"`
+}
+
+// Auth configures the signing and verification of tokens.
+type Auth struct {
+	PrivateKeyFile string `conf:"default:private.pem"`
+	KeyID          string `conf:"default:1"`
+	Algorithm      string `conf:"default:RS256"`
+
+	// PublicKeyFiles keep tokens signed with retired keys valid.
+	PublicKeyFiles []string `conf:"help:extra verification keys as KID=path separated by ';'"`
+}
+
+// Trace configures the export of spans to Zipkin.
+type Trace struct {
+	URL         string  `conf:"default:http://localhost:9411/api/v2/spans"`
+	Service     string  `conf:"default:grage-api"`
+	Probability float64 `conf:"default:1"`
+}
+
+// Webhook configures the delivery of webhooks.
+type Webhook struct {
+	Interval    time.Duration `conf:"default:5s,help:how often due deliveries are looked for"`
+	Timeout     time.Duration `conf:"default:10s,help:how long an endpoint has to answer"`
+	MaxAttempts int           `conf:"default:8,help:attempts before a delivery is marked failed"`
+}
+
+// Jobs configures the background jobs. The API runs them unless workers are
+// deployed to.
+type Jobs struct {
+	Run               bool          `conf:"default:true,help:run background jobs in this process"`
+	Concurrency       int           `conf:"default:4,help:jobs run at a time"`
+	PollInterval      time.Duration `conf:"default:1s,help:how often due jobs and schedules are looked for"`
+	LowStockThreshold int           `conf:"default:5,help:units left at which a product is low on stock"`
+	EventRetention    time.Duration `conf:"default:720h,help:how long events are kept"`
+	JobRetention      time.Duration `conf:"default:168h,help:how long succeeded jobs are kept"`
+}
+
+// Config is the effective configuration of a command.
+type Config struct {
+	Config  string `conf:"help:path of a YAML or TOML config file"`
+	Web     Web
+	CORS    CORS
+	TLS     TLS
+	Debug   Debug
+	Log     Log
+	DB      DB
+	Auth    Auth
+	Trace   Trace
+	Webhook Webhook
+	Jobs    Jobs
+}
+
+// parsed adds the positional arguments, which are not part of the
+// configuration, to Config.
+type parsed struct {
+	Config
+	Args conf.Args
+}
+
+// Parse builds the configuration from args, the environment and the config
+// file and validates it. It returns the positional arguments left after the
+// flags. conf.ErrHelpWanted is returned as is when args ask for help.
+func Parse(args []string) (*Config, conf.Args, error) {
+	var cfg parsed
+
+	file, err := loadFile(configPath(args))
+	if err != nil {
+		return nil, nil, err
+	}
+	secrets := secretFiles{}
+
+	if err := conf.Parse(args, Namespace, &cfg, file, &secrets); err != nil {
+		if err == conf.ErrHelpWanted {
+			return nil, nil, err
+		}
+		return nil, nil, errors.Wrap(err, "parsing config")
+	}
+	if secrets.err != nil {
+		return nil, nil, secrets.err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg.Config, cfg.Args, nil
+}
+
+// Usage describes every setting with its flag, environment variable and
+// default.
+func Usage() (string, error) {
+	var cfg parsed
+	return conf.Usage(Namespace, &cfg)
+}
+
+// String renders the configuration with its secrets redacted.
+func (c *Config) String() (string, error) {
+	return conf.String(c)
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Web.Address != "", "web address is required")
+	check(c.Web.ReadTimeout > 0, "web read timeout must be positive")
+	check(c.Web.WriteTimeout > 0, "web write timeout must be positive")
+	check(c.Web.ShutdownTimeout > 0, "web shutdown timeout must be positive")
+	check(c.Web.DrainDelay >= 0, "web drain delay must not be negative")
+	check(c.Web.ReadHeaderTimeout > 0, "web read header timeout must be positive")
+	check(c.Web.IdleTimeout > 0, "web idle timeout must be positive")
+	check(c.Web.MaxHeaderBytes > 0, "web max header bytes must be positive")
+	check(c.Web.MaxBodyBytes > 0, "web max body bytes must be positive")
+	check(c.Web.MaxImportBytes > 0, "web max import bytes must be positive")
+	check(c.Web.HandlerTimeout < c.Web.WriteTimeout, "web handler timeout must be shorter than the write timeout")
+	check(c.Web.HandlerTimeout >= 0, "web handler timeout can not be negative")
+	check(c.Web.CompressMinBytes >= 0, "web compress min bytes can not be negative")
+	check(c.Web.EventsMaxDuration > 0, "web events max duration must be positive")
+	check(c.Web.EventsHeartbeat > 0, "web events heartbeat must be positive")
+	check(c.Web.EventsTokenTTL > 0, "web events token TTL must be positive")
+
+	for _, o := range c.CORS.AllowedOrigins {
+		check(o != "*" || !c.CORS.AllowCredentials, "cors credentials can not be allowed for any origin")
+	}
+	check(c.CORS.MaxAge >= 0, "cors max age can not be negative")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls cert and key files must be set together")
+	if c.TLS.CertFile != "" {
+		_, err := certs.ParseVersion(c.TLS.MinVersion)
+		check(err == nil, "tls %v", err)
+		check(c.TLS.ReloadInterval > 0, "tls reload interval must be positive")
+		check(c.TLS.ClientAuth == "optional" || c.TLS.ClientAuth == "require", "tls client auth must be optional or require, got %q", c.TLS.ClientAuth)
+	}
+	check(c.TLS.ClientCA == "" || c.TLS.CertFile != "", "tls client CA needs a cert and key file")
+	check(c.TLS.HSTSMaxAge >= 0, "tls hsts max age can not be negative")
+	check(c.TLS.RedirectAddress == "" || c.TLS.CertFile != "", "tls redirect address needs a cert and key file")
+
+	if c.Web.Debug != "" && c.Debug.Token == "" && c.Debug.ClientCA == "" && !isLoopback(c.Web.Debug) {
+		problems = append(problems, "debug server on "+c.Web.Debug+" needs a token or a client CA")
+	}
+	check(c.Debug.ClientCA == "" || (c.Debug.CertFile != "" && c.Debug.KeyFile != ""), "debug cert and key files are required with a client CA")
+	_, err := logger.ParseLevel(c.Log.Level)
+	check(err == nil, "%v", err)
+
+	check(c.DB.Host != "", "db host is required")
+	check(c.DB.Name != "", "db name is required")
+	check(c.DB.User != "", "db user is required")
+	check(c.DB.MaxReplicaLag > 0, "db max replica lag must be positive")
+	check(c.DB.ReplicaCheckInterval > 0, "db replica check interval must be positive")
+
+	check(c.Auth.PrivateKeyFile != "", "auth private key file is required")
+	check(c.Auth.KeyID != "", "auth key id is required")
+	check(c.Auth.Algorithm != "", "auth algorithm is required")
+
+	check(c.Trace.Probability >= 0 && c.Trace.Probability <= 1, "trace probability must be between 0 and 1, got %v", c.Trace.Probability)
+
+	check(c.Webhook.Interval >= time.Second, "webhook interval must be at least a second")
+	check(c.Webhook.Timeout > 0, "webhook timeout must be positive")
+	check(c.Webhook.MaxAttempts > 0, "webhook max attempts must be positive")
+
+	check(c.Jobs.Concurrency > 0, "jobs concurrency must be positive")
+	check(c.Jobs.PollInterval > 0, "jobs poll interval must be positive")
+	check(c.Jobs.LowStockThreshold > 0, "jobs low stock threshold must be positive")
+	check(c.Jobs.EventRetention > 0, "jobs event retention must be positive")
+	check(c.Jobs.JobRetention > 0, "jobs job retention must be positive")
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isLoopback tells whether addr only accepts connections from this host.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// configPath finds the config file named by the --config flag or, failing
+// that, by the SALES_CONFIG environment variable. Flags are scanned the way
+// conf scans them: up to the first positional argument.
+func configPath(args []string) string {
+	for len(args) > 0 {
+		s := args[0]
+		if len(s) < 2 || s[0] != '-' || s == "--" {
+			break
+		}
+		args = args[1:]
+
+		name := strings.TrimLeft(s, "-")
+		value, hasValue := "", false
+		if i := strings.Index(name, "="); i > 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		if !hasValue && len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+			value, args = args[0], args[1:]
+		}
+
+		if name == "config" {
+			return value
+		}
+	}
+
+	return os.Getenv(Namespace + "_CONFIG")
+}
+
+// secretFiles is a conf.Sourcer reading the value of a field from the file
+// named by its environment variable with a _FILE suffix. Trailing newlines
+// are dropped. The first file that cannot be read is kept in err since
+// sourcers cannot fail.
+type secretFiles struct {
+	err error
+}
+
+// Source implements conf.Sourcer.
+func (s *secretFiles) Source(fld conf.Field) (string, bool) {
+	key := Namespace + "_" + strings.ToUpper(strings.Join(fld.EnvKey, "_")) + "_FILE"
+	path, ok := os.LookupEnv(key)
+	if !ok {
+		return "", false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if s.err == nil {
+			s.err = errors.Wrapf(err, "reading %s", key)
+		}
+		return "", false
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), true
+}
@@ -0,0 +1,139 @@
+package config_test
+
+import (
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, args, err := config.Parse([]string{"migrate", "--force"})
+	if err != nil {
+		t.Fatalf("Parsing: %v", err)
+	}
+
+	if cfg.Auth.PrivateKeyFile != "private.pem" || cfg.Auth.KeyID != "1" {
+		t.Fatalf("Unexpected auth defaults: %+v", cfg.Auth)
+	}
+	if cfg.DB.Host != "localhost:5432" {
+		t.Fatalf("Expected the database on localhost, got %q", cfg.DB.Host)
+	}
+	if args.Num(0) != "migrate" || args.Num(1) != "--force" {
+		t.Fatalf("Unexpected args %v", args)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "garage.yaml", `
+web:
+  read_timeout: 10s
+db:
+  host: file:5432
+  name: file
+  user: file
+  replicas: [r1:5432, r2:5432]
+trace:
+  probability: 0.5
+`)
+	t.Setenv("SALES_DB_NAME", "env")
+	t.Setenv("SALES_DB_USER", "env")
+
+	cfg, _, err := config.Parse([]string{"--config", yamlFile, "--db-user=flag"})
+	if err != nil {
+		t.Fatalf("Parsing: %v", err)
+	}
+
+	if cfg.DB.Host != "file:5432" {
+		t.Errorf("Expected the host from the file, got %q", cfg.DB.Host)
+	}
+	if cfg.DB.Name != "env" {
+		t.Errorf("Expected the env to override the file, got %q", cfg.DB.Name)
+	}
+	if cfg.DB.User != "flag" {
+		t.Errorf("Expected the flag to override the env, got %q", cfg.DB.User)
+	}
+	if cfg.Web.ReadTimeout != 10*time.Second || cfg.Trace.Probability != 0.5 {
+		t.Errorf("Unexpected values from the file: %+v %+v", cfg.Web, cfg.Trace)
+	}
+	if len(cfg.DB.Replicas) != 2 || cfg.DB.Replicas[1] != "r2:5432" {
+		t.Errorf("Unexpected replicas %v", cfg.DB.Replicas)
+	}
+}
+
+func TestTOML(t *testing.T) {
+	tomlFile := writeFile(t, "garage.toml", `
+[db]
+host = "toml:5432"
+disableTLS = false
+
+[auth]
+key-id = "2024"
+`)
+	t.Setenv("SALES_CONFIG", tomlFile)
+
+	cfg, _, err := config.Parse(nil)
+	if err != nil {
+		t.Fatalf("Parsing: %v", err)
+	}
+
+	if cfg.DB.Host != "toml:5432" || cfg.DB.DisableTLS || cfg.Auth.KeyID != "2024" {
+		t.Fatalf("Unexpected values from the file: %+v %+v", cfg.DB, cfg.Auth)
+	}
+}
+
+func TestSecretFile(t *testing.T) {
+	t.Setenv("SALES_DB_PASSWORD_FILE", writeFile(t, "password", "hunter2\n"))
+
+	cfg, _, err := config.Parse(nil)
+	if err != nil {
+		t.Fatalf("Parsing: %v", err)
+	}
+	if cfg.DB.Password != "hunter2" {
+		t.Fatalf("Expected the password from the file, got %q", cfg.DB.Password)
+	}
+
+	out, err := cfg.String()
+	if err != nil {
+		t.Fatalf("Rendering: %v", err)
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Fatalf("Expected the password to be redacted:\n%s", out)
+	}
+
+	t.Setenv("SALES_DB_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, _, err := config.Parse(nil); err == nil || !strings.Contains(err.Error(), "SALES_DB_PASSWORD_FILE") {
+		t.Fatalf("Expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, _, err := config.Parse([]string{"--db-host=", "--trace-probability=2", "--web-debug=0.0.0.0:6060", "--log-level=loud",
+		"--cors-allowed-origins=*", "--cors-allow-credentials=true"})
+	if err == nil {
+		t.Fatal("Expected an invalid config")
+	}
+	for _, want := range []string{"db host is required", "trace probability", "needs a token", "unknown log level", "cors credentials"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+
+	if _, _, err := config.Parse([]string{"--web-debug=0.0.0.0:6060", "--debug-token=s3cret"}); err != nil {
+		t.Fatalf("Expected a debug server with a token to be valid, got %v", err)
+	}
+}
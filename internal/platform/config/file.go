@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"github.com/ardanlabs/conf"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// file is a conf.Sourcer reading values from a config file. Sections of the
+// file match the sections of Config and keys match field names, ignoring
+// case, dashes and underscores, so both of these set DB.DisableTLS:
+//
+//	db:
+//	  disable_tls: false
+//
+//	[db]
+//	disableTLS = false
+type file struct {
+	values map[string]string
+}
+
+// loadFile reads the YAML or TOML file at path, picking the format from the
+// extension. An empty path yields an empty source.
+func loadFile(path string) (*file, error) {
+	f := file{values: make(map[string]string)}
+	if path == "" {
+		return &f, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading config file")
+	}
+
+	var doc map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, errors.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing config file %s", path)
+	}
+
+	f.flatten("", doc)
+	return &f, nil
+}
+
+// flatten records the scalars and lists of doc under their normalized keys.
+func (f *file) flatten(prefix string, doc interface{}) {
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			f.flatten(prefix+normalize(k), child)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			f.flatten(prefix+normalize(fmt.Sprint(k)), child)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		f.values[prefix] = strings.Join(items, ";")
+	case nil:
+	default:
+		f.values[prefix] = fmt.Sprint(v)
+	}
+}
+
+// Source implements conf.Sourcer.
+func (f *file) Source(fld conf.Field) (string, bool) {
+	v, ok := f.values[normalize(strings.Join(fld.FlagKey, ""))]
+	return v, ok
+}
+
+// normalize lowercases key and drops its dashes and underscores.
+func normalize(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
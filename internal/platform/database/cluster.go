@@ -0,0 +1,274 @@
+package database
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClusterConfig describes a primary database and the read replicas that
+// follow it.
+type ClusterConfig struct {
+	Primary  Config
+	Replicas []Config
+
+	// MaxLag is how far behind the primary a replica may fall before it stops
+	// receiving reads.
+	MaxLag time.Duration
+
+	// CheckInterval controls how often replica health and lag are sampled.
+	CheckInterval time.Duration
+}
+
+// ReplicaStatus describes the last observed state of a read replica.
+type ReplicaStatus struct {
+	Host       string    `json:"host"`
+	Healthy    bool      `json:"healthy"`
+	LagSeconds float64   `json:"lag_seconds"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// replica is a single read replica and its last known status.
+type replica struct {
+	db *sqlx.DB
+
+	mu     sync.RWMutex
+	status ReplicaStatus
+}
+
+// Status returns the result of the latest check.
+func (r *replica) Status() ReplicaStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status
+}
+
+func (r *replica) setStatus(s ReplicaStatus) {
+	r.mu.Lock()
+	r.status = s
+	r.mu.Unlock()
+}
+
+// Cluster routes queries between a primary database and any number of read
+// replicas. Writes always go to the primary. Read-only calls go to a healthy
+// replica and fall back to the primary when none is available or when the
+// request asked to read its own writes.
+type Cluster struct {
+	primary  *sqlx.DB
+	replicas []*replica
+	maxLag   time.Duration
+	next     uint32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenCluster opens the primary and every replica and starts sampling the
+// replicas in the background. Replicas do not receive reads until their first
+// successful check.
+func OpenCluster(cfg ClusterConfig) (*Cluster, error) {
+	primary, err := Open(cfg.Primary)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening primary")
+	}
+
+	c := Cluster{
+		primary: primary,
+		maxLag:  cfg.MaxLag,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for _, rc := range cfg.Replicas {
+		db, err := Open(rc)
+		if err != nil {
+			c.closeAll()
+			return nil, errors.Wrapf(err, "opening replica %q", rc.Host)
+		}
+		c.replicas = append(c.replicas, &replica{
+			db:     db,
+			status: ReplicaStatus{Host: rc.Host, Error: "not checked yet"},
+		})
+	}
+
+	interval := cfg.CheckInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go c.monitor(interval)
+
+	return &c, nil
+}
+
+// NewCluster wraps an already opened database as a cluster without replicas.
+// It is mostly useful for tests and tools that only ever talk to one server.
+func NewCluster(primary *sqlx.DB) *Cluster {
+	c := Cluster{
+		primary: primary,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	close(c.done)
+	return &c
+}
+
+// Primary returns the handle all writes must use.
+func (c *Cluster) Primary() *sqlx.DB {
+	return c.primary
+}
+
+// Reader returns a handle for read-only queries. Healthy replicas are used in
+// turn; the primary is returned when there is no healthy replica or when ctx
+// was marked with ReadYourWrites.
+func (c *Cluster) Reader(ctx context.Context) *sqlx.DB {
+	if len(c.replicas) == 0 || readsOwnWrites(ctx) {
+		return c.primary
+	}
+
+	start := atomic.AddUint32(&c.next, 1)
+	for i := 0; i < len(c.replicas); i++ {
+		r := c.replicas[(int(start)+i)%len(c.replicas)]
+		if r.Status().Healthy {
+			return r.db
+		}
+	}
+
+	return c.primary
+}
+
+// WithTx runs fn in a transaction on the primary. See the package level WithTx
+// for the nesting and retry rules.
+func (c *Cluster) WithTx(ctx context.Context, fn TxFunc) error {
+	return WithTx(ctx, c.primary, fn)
+}
+
+// Replicas returns the last observed status of every replica.
+func (c *Cluster) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, len(c.replicas))
+	for i, r := range c.replicas {
+		statuses[i] = r.Status()
+	}
+	return statuses
+}
+
+// CheckReplicas samples every replica immediately and returns the result.
+func (c *Cluster) CheckReplicas(ctx context.Context) []ReplicaStatus {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			r.setStatus(c.check(ctx, r))
+		}(r)
+	}
+	wg.Wait()
+
+	return c.Replicas()
+}
+
+// Close stops the background checks and closes every connection pool.
+func (c *Cluster) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+
+	return c.closeAll()
+}
+
+func (c *Cluster) closeAll() error {
+	err := c.primary.Close()
+	for _, r := range c.replicas {
+		if rerr := r.db.Close(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// monitor refreshes the status of every replica until the cluster is closed.
+func (c *Cluster) monitor(interval time.Duration) {
+	defer close(c.done)
+
+	if len(c.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		c.CheckReplicas(ctx)
+		cancel()
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check measures how far a replica is behind its primary. A replica that has
+// replayed everything it received reports no lag, even when the primary has
+// been idle for a while, so it is only healthy while its WAL receiver is
+// streaming: one that lost its primary has replayed everything too. A host
+// that is not in recovery, such as a promoted one, is not a replica.
+func (c *Cluster) check(ctx context.Context, r *replica) ReplicaStatus {
+	const q = `SELECT pg_is_in_recovery(),
+	COALESCE((SELECT status = 'streaming' FROM pg_stat_wal_receiver), FALSE),
+	CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+	s := ReplicaStatus{
+		Host:      r.Status().Host,
+		CheckedAt: time.Now().UTC(),
+	}
+
+	var recovering, streaming bool
+	if err := r.db.QueryRowContext(ctx, q).Scan(&recovering, &streaming, &s.LagSeconds); err != nil {
+		s.Error = err.Error()
+		return s
+	}
+
+	switch {
+	case !recovering:
+		s.Error = "not a replica: the host is not in recovery"
+		return s
+	case !streaming:
+		s.Error = "not streaming from the primary"
+		return s
+	}
+
+	lag := time.Duration(s.LagSeconds * float64(time.Second))
+	if c.maxLag > 0 && lag > c.maxLag {
+		s.Error = "replication lag exceeds " + c.maxLag.String()
+		return s
+	}
+
+	s.Healthy = true
+	return s
+}
+
+// consistencyKey marks a context whose reads must observe its own writes.
+type consistencyKey struct{}
+
+// ReadYourWrites returns a context whose reads are always served by the
+// primary, so a caller sees the effect of anything it just wrote.
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, true)
+}
+
+func readsOwnWrites(ctx context.Context) bool {
+	v, _ := ctx.Value(consistencyKey{}).(bool)
+	return v
+}
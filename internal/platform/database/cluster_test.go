@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"testing"
+)
+
+// TestClusterReader checks the routing rules without talking to a server;
+// sqlx.Open does not connect until the first query.
+func TestClusterReader(t *testing.T) {
+	open := func(host string) *sqlx.DB {
+		db, err := Open(Config{Host: host, Name: "garage", DisableTLS: true})
+		if err != nil {
+			t.Fatalf("Opening %s: %v", host, err)
+		}
+		return db
+	}
+
+	primary := open("primary:5432")
+	r := &replica{db: open("replica:5432"), status: ReplicaStatus{Host: "replica:5432"}}
+	c := NewCluster(primary)
+	c.replicas = []*replica{r}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	if got := c.Reader(ctx); got != primary {
+		t.Fatal("Expected reads to fall back to the primary while the replica is unchecked.")
+	}
+
+	r.setStatus(ReplicaStatus{Host: "replica:5432", Healthy: true})
+	if got := c.Reader(ctx); got != r.db {
+		t.Fatal("Expected reads to go to the healthy replica.")
+	}
+
+	if got := c.Reader(ReadYourWrites(ctx)); got != primary {
+		t.Fatal("Expected read-your-writes requests to use the primary.")
+	}
+}
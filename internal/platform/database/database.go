@@ -18,6 +18,12 @@ type Config struct {
 
 // Open knows how to open a database connection
 func Open(cfg Config) (*sqlx.DB, error) {
+	return sqlx.Open("postgres", DSN(cfg))
+}
+
+// DSN returns the connection string for cfg, for clients such as listeners
+// that open their own connections.
+func DSN(cfg Config) string {
 	q := url.Values{}
 
 	q.Set("timezone", "utc")
@@ -35,7 +41,7 @@ func Open(cfg Config) (*sqlx.DB, error) {
 		RawQuery: q.Encode(),
 	}
 
-	return sqlx.Open("postgres", u.String())
+	return u.String()
 }
 
 // StatusCheck returns nil if it can successfully talk to
@@ -50,3 +56,16 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	const q = `SELECT true`
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
 }
+
+// PageSize bounds the number of rows a listing returns at once: 100 when n
+// is not positive and never more than 500.
+func PageSize(n int) int {
+	const def, max = 100, 500
+	switch {
+	case n <= 0:
+		return def
+	case n > max:
+		return max
+	}
+	return n
+}
@@ -0,0 +1,150 @@
+package databasetest
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// localServer is a throwaway Postgres server living in a temporary directory.
+type localServer struct {
+	dir    string
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// startLocal initializes a cluster in a temporary directory and starts a
+// server on a free port of the loopback interface. The cluster trusts every
+// local connection and trades durability for speed.
+func startLocal() (*server, error) {
+	initdb, err := findBinary("initdb")
+	if err != nil {
+		return nil, err
+	}
+	postgres, err := findBinary("postgres")
+	if err != nil {
+		return nil, err
+	}
+	if os.Geteuid() == 0 {
+		return nil, errors.Wrap(errUnavailable, "postgres refuses to run as root")
+	}
+
+	dir, err := ioutil.TempDir("", "databasetest")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating temporary directory")
+	}
+	data := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "running initdb: %s", out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, "postgres.log"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.Wrap(err, "creating server log")
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(postgres,
+		"-D", data,
+		"-h", "127.0.0.1",
+		"-p", fmt.Sprint(port),
+		"-k", dir,
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off",
+	)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.Wrap(err, "starting postgres")
+	}
+
+	l := localServer{dir: dir, cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(l.exited)
+	}()
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.User("postgres"),
+		Host:     fmt.Sprintf("127.0.0.1:%d", port),
+		Path:     "/postgres",
+		RawQuery: "sslmode=disable&timezone=utc",
+	}
+
+	admin, err := sqlx.Open("postgres", u.String())
+	if err == nil {
+		err = waitReady(admin, l.exited)
+	}
+	if err != nil {
+		log, _ := ioutil.ReadFile(logFile.Name())
+		l.stop()
+		return nil, errors.Wrapf(err, "postgres log:\n%s", log)
+	}
+
+	return &server{base: u, admin: admin, local: &l}, nil
+}
+
+// stop shuts the server down, killing it if it does not stop in time, and
+// removes its directory.
+func (l *localServer) stop() {
+	// SIGINT asks postgres for a fast shutdown.
+	l.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-l.exited:
+	case <-time.After(10 * time.Second):
+		l.cmd.Process.Kill()
+		<-l.exited
+	}
+	os.RemoveAll(l.dir)
+}
+
+// findBinary looks for a Postgres program on the PATH, then in the versioned
+// directories Debian and Ubuntu install it to, newest version first.
+func findBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql", "*", "bin", name))
+	if len(matches) == 0 {
+		return "", errors.Wrapf(errUnavailable, "%s is not set and %s is not installed", DatabaseURLEnv, name)
+	}
+	version := func(path string) int {
+		v, _ := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(path))))
+		return v
+	}
+	sort.Slice(matches, func(i, j int) bool { return version(matches[i]) > version(matches[j]) })
+	return matches[0], nil
+}
+
+// freePort asks the kernel for a port nobody listens on.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, errors.Wrap(err, "finding a free port")
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
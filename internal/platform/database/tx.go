@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Queryer is the set of methods shared by *sqlx.DB and *sqlx.Tx. Domain
+// functions accept a Queryer so they can run on their own or as one step of a
+// caller's transaction.
+type Queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// TxFunc is the unit of work run by WithTx. The context it receives carries
+// the transaction so nested calls to WithTx and QueryerFrom join it.
+type TxFunc func(ctx context.Context, tx Queryer) error
+
+// MaxTxAttempts is how many times WithTx runs a unit of work that keeps
+// failing with a serialization failure or a deadlock.
+var MaxTxAttempts = 3
+
+// txKey is how the running transaction is stored in a context.
+type txKey struct{}
+
+// txState tracks a running transaction and how deeply it is nested.
+type txState struct {
+	tx    *sqlx.Tx
+	depth int
+}
+
+// WithTx runs fn inside a transaction on db. The transaction is committed when
+// fn returns nil and rolled back otherwise.
+//
+// When ctx already carries a transaction, fn runs inside a savepoint of that
+// transaction instead. An error only rolls back to the savepoint, leaving the
+// outer unit of work to decide what to do.
+//
+// The outermost call retries fn when Postgres aborts the transaction with a
+// serialization failure or a deadlock, so fn must be safe to run again.
+func WithTx(ctx context.Context, db *sqlx.DB, fn TxFunc) error {
+	return WithTxOptions(ctx, db, nil, fn)
+}
+
+// WithTxOptions is WithTx with control over the isolation level and read-only
+// mode of the outermost transaction. The options are ignored for nested calls.
+func WithTxOptions(ctx context.Context, db *sqlx.DB, opts *sql.TxOptions, fn TxFunc) error {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return withSavepoint(ctx, state, fn)
+	}
+
+	var err error
+	for attempt := 1; attempt <= MaxTxAttempts; attempt++ {
+		err = runTx(ctx, db, opts, fn)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+
+	return errors.Wrapf(err, "giving up after %d attempts", MaxTxAttempts)
+}
+
+// WithTxOnce is WithTx for units of work that can not run again, such as one
+// consuming a stream: a serialization failure or a deadlock is returned
+// instead of retried.
+func WithTxOnce(ctx context.Context, db *sqlx.DB, fn TxFunc) error {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return withSavepoint(ctx, state, fn)
+	}
+	return runTx(ctx, db, nil, fn)
+}
+
+// QueryerFrom returns the transaction carried by ctx, or fallback when ctx
+// is not part of a unit of work.
+func QueryerFrom(ctx context.Context, fallback Queryer) Queryer {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx
+	}
+	return fallback
+}
+
+// runTx runs one attempt of an outermost unit of work.
+func runTx(ctx context.Context, db *sqlx.DB, opts *sql.TxOptions, fn TxFunc) (err error) {
+	tx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+
+	// Roll back if fn panics so the connection is not leaked.
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	state := txState{tx: tx}
+	if err := fn(context.WithValue(ctx, txKey{}, &state), tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			return errors.Wrapf(err, "rolling back: %v", rerr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing transaction")
+	}
+
+	return nil
+}
+
+// withSavepoint runs a nested unit of work inside a savepoint.
+func withSavepoint(ctx context.Context, state *txState, fn TxFunc) error {
+	state.depth++
+	defer func() { state.depth-- }()
+
+	name := fmt.Sprintf("sp_%d", state.depth)
+	if _, err := state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrapf(err, "creating savepoint %s", name)
+	}
+
+	if err := fn(ctx, state.tx); err != nil {
+		if _, rerr := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			return errors.Wrapf(err, "rolling back to savepoint %s: %v", name, rerr)
+		}
+		return err
+	}
+
+	if _, err := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrapf(err, "releasing savepoint %s", name)
+	}
+
+	return nil
+}
+
+// isRetryable reports whether Postgres aborted the transaction for a reason
+// that running it again may resolve.
+func isRetryable(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	if !ok {
+		return false
+	}
+
+	switch pqErr.Code.Name() {
+	case "serialization_failure", "deadlock_detected":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package database_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestWithTx checks that a unit of work commits or rolls back as a whole and
+// that a failing nested unit only rolls back to its savepoint.
+func TestWithTx(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	claims := auth.NewClaims("e612a422-2239-45e3-a8e0-c0c56c71454a", []string{auth.RoleAdmin}, now, time.Hour)
+
+	// Products and sales reference their user.
+	const q = `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at)
+VALUES ($1, 'Admin Gopher', 'admin@example.com', '', '{ADMIN}', $2, $2)`
+	if _, err := db.ExecContext(ctx, q, claims.Subject, now); err != nil {
+		t.Fatalf("Creating user: %v", err)
+	}
+
+	// Create a product and record its initial sale together. The nested sale
+	// for a missing product fails without undoing the rest.
+	var created *product.Product
+	err := database.WithTx(ctx, db, func(ctx context.Context, tx database.Queryer) error {
+		p, err := product.Create(ctx, tx, claims, product.NewProduct{Name: "Comic Books", Cost: 5, Quantity: 10}, now)
+		if err != nil {
+			return err
+		}
+		created = p
+
+		if _, err := product.AddSale(ctx, tx, claims, p.ID, product.NewSale{Quantity: 2, Paid: 10}, now); err != nil {
+			return err
+		}
+
+		nested := database.WithTx(ctx, db, func(ctx context.Context, tx database.Queryer) error {
+			_, err := product.AddSale(ctx, tx, claims, "2fd0f9bf-d9e2-4cbd-8b2c-1a9b1d5e1c2a", product.NewSale{Quantity: 1}, now)
+			return err
+		})
+		if nested != product.ErrNotFound {
+			return errors.Errorf("expected %v from the nested unit, got %v", product.ErrNotFound, nested)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Running unit of work: %v", err)
+	}
+
+	p, err := product.Retrieve(ctx, db, created.ID)
+	if err != nil {
+		t.Fatalf("Retrieving product: %v", err)
+	}
+	if p.Sold != 2 {
+		t.Fatalf("Expected the initial sale to be committed, sold is %d", p.Sold)
+	}
+
+	// A failing unit of work leaves nothing behind.
+	failure := errors.New("failure")
+	var discarded *product.Product
+	err = database.WithTx(ctx, db, func(ctx context.Context, tx database.Queryer) error {
+		p, err := product.Create(ctx, tx, claims, product.NewProduct{Name: "Toys", Cost: 5, Quantity: 10}, now)
+		if err != nil {
+			return err
+		}
+		discarded = p
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("Expected %v, got %v", failure, err)
+	}
+	if _, err := product.Retrieve(ctx, db, discarded.ID); err != product.ErrNotFound {
+		t.Fatalf("Expected the product to be rolled back, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package debug serves the endpoints meant for operators: profiles, program
+// counters, metrics, the effective configuration and the settings that can
+// be changed while the service runs. It uses its own mux so nothing
+// registered on http.DefaultServeMux is ever exposed, and every endpoint
+// requires a bearer token or a verified client certificate once either is
+// configured.
+package debug
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+)
+
+// Config selects the endpoints of the debug mux and how clients prove who
+// they are.
+type Config struct {
+	Log *log.Logger
+
+	// Token is the bearer token clients must send. Clients presenting a
+	// verified certificate do not need it.
+	Token string
+
+	// ClientCerts tells that the listener verifies client certificates.
+	// Without it and without a token the mux is open to anyone who can
+	// reach it.
+	ClientCerts bool
+
+	// Endpoints to serve.
+	Pprof   bool
+	Expvar  bool
+	Metrics bool
+
+	// Config renders the effective configuration with secrets redacted. It
+	// is served when not nil.
+	Config func() (string, error)
+
+	// Settings are served when not nil.
+	Settings *Settings
+}
+
+// Mux constructs the handler of the debug server.
+func Mux(cfg Config) http.Handler {
+	mux := http.NewServeMux()
+
+	var endpoints []string
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, h)
+		endpoints = append(endpoints, pattern)
+	}
+
+	if cfg.Pprof {
+		handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+		handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+		handle("/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+		handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+		handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+	}
+	if cfg.Expvar {
+		handle("/debug/vars", expvar.Handler())
+	}
+	if cfg.Metrics {
+		handle("/debug/metrics", http.HandlerFunc(metrics))
+	}
+	if cfg.Config != nil {
+		handle("/debug/config", configHandler(cfg.Config))
+	}
+	if cfg.Settings != nil {
+		handle("/debug/settings", cfg.Settings)
+	}
+
+	// The index lists what this instance serves so operators do not have to
+	// guess which endpoints were left disabled.
+	mux.HandleFunc("/debug/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/" {
+			http.NotFound(w, r)
+			return
+		}
+		respond(w, map[string][]string{"endpoints": endpoints}, http.StatusOK)
+	})
+
+	if cfg.Token == "" && !cfg.ClientCerts {
+		return mux
+	}
+	return authenticate(cfg.Log, cfg.Token, mux)
+}
+
+// authenticate lets requests through when they come with a verified client
+// certificate or the bearer token.
+func authenticate(log *log.Logger, token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		log.Printf("debug: rejected %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+		respond(w, map[string]string{"error": "authentication required"}, http.StatusUnauthorized)
+	})
+}
+
+// metrics reports the request counters of the API with a few runtime
+// statistics.
+func metrics(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m := map[string]interface{}{
+		"goroutines":   runtime.NumGoroutine(),
+		"heap_alloc":   mem.HeapAlloc,
+		"heap_objects": mem.HeapObjects,
+		"gc_runs":      mem.NumGC,
+		"gc_pause_ns":  mem.PauseTotalNs,
+	}
+	for _, name := range []string{"requests", "errors", "canceled"} {
+		if v, ok := expvar.Get(name).(*expvar.Int); ok {
+			m[name] = v.Value()
+		}
+	}
+
+	respond(w, m, http.StatusOK)
+}
+
+// configHandler serves the rendered configuration as plain text.
+func configHandler(render func() (string, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := render()
+		if err != nil {
+			respond(w, map[string]string{"error": err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(out + "\n"))
+	})
+}
+
+// respond writes v as JSON.
+func respond(w http.ResponseWriter, v interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,94 @@
+package debug_test
+
+import (
+	"github.com/esmaeilmirzaee/grage/internal/platform/debug"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// do sends a request to h and returns the recorded response.
+func do(h http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestMux(t *testing.T) {
+	logs := log.New(ioutil.Discard, "", 0)
+	h := debug.Mux(debug.Config{
+		Log:     logs,
+		Token:   "s3cret",
+		Expvar:  true,
+		Metrics: true,
+		Config: func() (string, error) {
+			return "--db-password=xxxxxx", nil
+		},
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		want   int
+	}{
+		{"NoToken", "/debug/vars", "", http.StatusUnauthorized},
+		{"WrongToken", "/debug/vars", "guess", http.StatusUnauthorized},
+		{"Expvar", "/debug/vars", "s3cret", http.StatusOK},
+		{"Metrics", "/debug/metrics", "s3cret", http.StatusOK},
+		{"Config", "/debug/config", "s3cret", http.StatusOK},
+		{"PprofDisabled", "/debug/pprof/", "s3cret", http.StatusNotFound},
+		{"Index", "/debug/", "s3cret", http.StatusOK},
+		{"DefaultServeMux", "/debug/pprof/heap", "s3cret", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := do(h, http.MethodGet, tt.target, tt.token, ""); w.Code != tt.want {
+				t.Fatalf("Expected %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestSettings(t *testing.T) {
+	level := logger.NewVar(logger.Info)
+	sampler := debug.NewSampler(1)
+	h := debug.Mux(debug.Config{
+		Log: log.New(ioutil.Discard, "", 0),
+		Settings: &debug.Settings{
+			Log:     log.New(ioutil.Discard, "", 0),
+			Level:   level,
+			Sampler: sampler,
+		},
+	})
+
+	w := do(h, http.MethodPut, "/debug/settings", "", `{"log_level":"debug","trace_probability":0.25}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if level.Level() != logger.Debug || sampler.Probability() != 0.25 {
+		t.Fatalf("Settings not applied: level %s, probability %v", level.Level(), sampler.Probability())
+	}
+
+	// Nothing changes when part of the body is invalid.
+	w = do(h, http.MethodPut, "/debug/settings", "", `{"log_level":"error","trace_probability":2}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d: %s", w.Code, w.Body)
+	}
+	if level.Level() != logger.Debug {
+		t.Fatalf("Expected the level to be left alone, got %s", level.Level())
+	}
+
+	w = do(h, http.MethodGet, "/debug/settings", "", "")
+	if want := `{"log_level":"debug","trace_probability":0.25}`; strings.TrimSpace(w.Body.String()) != want {
+		t.Fatalf("Expected %s, got %s", want, w.Body)
+	}
+}
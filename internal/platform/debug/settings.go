@@ -0,0 +1,97 @@
+package debug
+
+import (
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/logger"
+	"go.opencensus.io/trace"
+	"log"
+	"math"
+	"net/http"
+	"sync/atomic"
+)
+
+// Sampler holds the probability of tracing a request and applies it to the
+// opencensus tracer. It may be changed while the service runs.
+type Sampler struct {
+	bits uint64
+}
+
+// NewSampler constructs a Sampler and applies probability.
+func NewSampler(probability float64) *Sampler {
+	var s Sampler
+	s.Set(probability)
+	return &s
+}
+
+// Probability returns the current probability.
+func (s *Sampler) Probability() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+// Set changes the probability. It must be between 0 and 1.
+func (s *Sampler) Set(probability float64) {
+	atomic.StoreUint64(&s.bits, math.Float64bits(probability))
+	trace.ApplyConfig(trace.Config{
+		DefaultSampler: trace.ProbabilitySampler(probability),
+	})
+}
+
+// Settings are the knobs operators may turn without a restart. GET reports
+// them and PUT changes the ones present in the body:
+//
+//	{"log_level": "debug", "trace_probability": 0.1}
+type Settings struct {
+	Log     *log.Logger
+	Level   *logger.Var
+	Sampler *Sampler
+}
+
+// settings is the JSON form of Settings.
+type settings struct {
+	LogLevel         *string  `json:"log_level,omitempty"`
+	TraceProbability *float64 `json:"trace_probability,omitempty"`
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Settings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var in settings
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			respond(w, map[string]string{"error": "decoding settings: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+
+		// Everything is validated before anything changes.
+		level := s.Level.Level()
+		if in.LogLevel != nil {
+			l, err := logger.ParseLevel(*in.LogLevel)
+			if err != nil {
+				respond(w, map[string]string{"error": err.Error()}, http.StatusBadRequest)
+				return
+			}
+			level = l
+		}
+		if p := in.TraceProbability; p != nil && (*p < 0 || *p > 1) {
+			respond(w, map[string]string{"error": "trace_probability must be between 0 and 1"}, http.StatusBadRequest)
+			return
+		}
+
+		if in.LogLevel != nil {
+			s.Level.Set(level)
+			s.Log.Printf("debug: log level set to %s by %s", level, r.RemoteAddr)
+		}
+		if in.TraceProbability != nil {
+			s.Sampler.Set(*in.TraceProbability)
+			s.Log.Printf("debug: trace probability set to %v by %s", *in.TraceProbability, r.RemoteAddr)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		respond(w, map[string]string{"error": "method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	level, probability := s.Level.Level().String(), s.Sampler.Probability()
+	respond(w, settings{LogLevel: &level, TraceProbability: &probability}, http.StatusOK)
+}
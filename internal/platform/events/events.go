@@ -0,0 +1,190 @@
+// Package events records what changed in the service and fans it out to the
+// clients following the changes. Events are appended to the events table in
+// the transaction of the change they describe, so an event exists if and
+// only if its change was committed, and Postgres notifies every API instance
+// once it is.
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/pkg/errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Channel is the Postgres notification channel carrying the ID of every
+// committed event.
+const Channel = "events"
+
+// perTransaction is how many events a transaction may append. Event IDs are
+// the ID of the appending transaction times perTransaction plus the position
+// of the event in it, so they follow the order transactions started in.
+// Readers only return the events of transactions older than every one still
+// running: an event committed later then always has a greater ID than the
+// ones a client saw, and resuming after an ID misses nothing. Appends never
+// wait for each other; a long transaction only holds back what readers see.
+const perTransaction = 1 << 16
+
+// visible bounds the event IDs readers return to the transactions that
+// ended before every running one began. 65536 is perTransaction.
+const visible = `event_id < txid_snapshot_xmin(txid_current_snapshot()) * 65536`
+
+// Event is a change that happened to a subject, such as a Product.
+type Event struct {
+	ID        int64           `db:"event_id" json:"id"`
+	Type      string          `db:"type" json:"type"`
+	Subject   string          `db:"subject" json:"subject"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+// Feed is what clients following the events need: the events they missed
+// and the ones still to come.
+type Feed interface {
+	// Since returns up to limit events recorded after the event afterID,
+	// oldest first.
+	Since(ctx context.Context, afterID int64, limit int) ([]Event, error)
+
+	// Subscribe starts receiving the events published from now on.
+	Subscribe() *Subscription
+}
+
+// Append records an event of typ about subject with data marshaled as JSON.
+// Listeners are notified when the transaction of db commits; outside of a
+// transaction they are notified at once.
+func Append(ctx context.Context, db database.Queryer, typ, subject string, data interface{},
+	now time.Time) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling event data")
+	}
+
+	e := Event{
+		Type:      typ,
+		Subject:   subject,
+		Data:      raw,
+		CreatedAt: now,
+	}
+
+	const q = `WITH t AS (SELECT txid_current() * $5::BIGINT AS base),
+n AS (SELECT COALESCE(MAX(event_id) + 1, t.base) AS id, t.base FROM t
+	LEFT JOIN events ON event_id >= t.base AND event_id < t.base + $5 GROUP BY t.base)
+INSERT INTO events (event_id, type, subject, data, created_at)
+SELECT n.id, $1::TEXT, $2::TEXT, $3::JSONB, $4::TIMESTAMP FROM n WHERE n.id < n.base + $5
+RETURNING event_id;`
+	err = db.QueryRowxContext(ctx, q, e.Type, e.Subject, []byte(e.Data), e.CreatedAt, perTransaction).Scan(&e.ID)
+	if err == sql.ErrNoRows {
+		return nil, errors.Errorf("appending event: more than %d events in one transaction", perTransaction)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting event")
+	}
+
+	if _, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2);`, Channel, strconv.FormatInt(e.ID, 10)); err != nil {
+		return nil, errors.Wrap(err, "notifying event")
+	}
+
+	return &e, nil
+}
+
+// Since returns up to limit events recorded after the event afterID, oldest
+// first. The events of transactions younger than a running one are held back
+// until it ends.
+func Since(ctx context.Context, db database.Queryer, afterID int64, limit int) ([]Event, error) {
+	const q = `SELECT event_id, type, subject, data, created_at FROM events WHERE event_id > $1 AND ` + visible + `
+ORDER BY event_id LIMIT $2;`
+
+	var list []Event
+	if err := db.SelectContext(ctx, &list, q, afterID, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting events")
+	}
+	return list, nil
+}
+
+// Prune deletes the events recorded before before and returns how many there
+// were. Clients resuming from a pruned event get the events left.
+func Prune(ctx context.Context, db database.Queryer, before time.Time) (int64, error) {
+	const q = `DELETE FROM events WHERE created_at < $1;`
+
+	res, err := db.ExecContext(ctx, q, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "pruning events")
+	}
+	return res.RowsAffected()
+}
+
+// Hub fans published events out to its subscriptions. It is safe for
+// concurrent use.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the events published on a Hub.
+type Subscription struct {
+	// C delivers the events. It is closed when the subscriber fell too far
+	// behind; it should then catch up with Feed.Since.
+	C <-chan Event
+
+	c   chan Event
+	hub *Hub
+}
+
+// subscriptionBuffer is how many events a subscriber may lag behind before
+// it is dropped.
+const subscriptionBuffer = 64
+
+// NewHub constructs a Hub without subscriptions.
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe starts receiving the events published from now on.
+func (h *Hub) Subscribe() *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	s := Subscription{
+		C:   c,
+		c:   c,
+		hub: h,
+	}
+
+	h.mu.Lock()
+	h.subs[&s] = struct{}{}
+	h.mu.Unlock()
+
+	return &s
+}
+
+// Publish sends e to every subscription. It never blocks: a subscription
+// whose buffer is full is closed so one slow client can not hold up the
+// others.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			delete(h.subs, s)
+			close(s.c)
+		}
+	}
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,129 @@
+package events_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"testing"
+	"time"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestAppendOrder checks that transactions appending events do not wait for
+// each other and that readers hold back the events of a transaction younger
+// than a running one: a client resuming after the ID of the later commit
+// must not miss the earlier one.
+func TestAppendOrder(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// The first transaction starts writing before the second but appends
+	// its event after the second committed.
+	first, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Beginning transaction: %v", err)
+	}
+	defer first.Rollback()
+	if _, err := first.ExecContext(ctx, `SELECT txid_current()`); err != nil {
+		t.Fatalf("Starting the first transaction: %v", err)
+	}
+
+	second, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Beginning transaction: %v", err)
+	}
+	defer second.Rollback()
+	if _, err := events.Append(ctx, second, "product.created", "second", struct{}{}, now); err != nil {
+		t.Fatalf("Appending in the second transaction: %v", err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatalf("Committing the second transaction: %v", err)
+	}
+
+	list, err := events.Since(ctx, db, 0, 10)
+	if err != nil {
+		t.Fatalf("Reading events: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("Expected the second event held back by the first transaction, got %+v", list)
+	}
+
+	if _, err := events.Append(ctx, first, "product.created", "first", struct{}{}, now); err != nil {
+		t.Fatalf("Appending in the first transaction: %v", err)
+	}
+	if _, err := events.Append(ctx, first, "product.updated", "first", struct{}{}, now); err != nil {
+		t.Fatalf("Appending in the first transaction: %v", err)
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatalf("Committing the first transaction: %v", err)
+	}
+
+	list, err = events.Since(ctx, db, 0, 10)
+	if err != nil {
+		t.Fatalf("Reading events: %v", err)
+	}
+	if len(list) != 3 || list[0].Subject != "first" || list[1].Subject != "first" || list[2].Subject != "second" ||
+		list[0].ID >= list[1].ID || list[1].ID >= list[2].ID {
+		t.Fatalf("Expected the events in the order their transactions started, got %+v", list)
+	}
+}
+
+// TestHubPublish checks that a subscriber falling too far behind is dropped
+// without holding up the others.
+func TestHubPublish(t *testing.T) {
+	h := events.NewHub()
+	slow, fast := h.Subscribe(), h.Subscribe()
+	defer fast.Close()
+
+	// The slow subscriber reads nothing; the fast one keeps up.
+	const n = 100
+	for i := int64(1); i <= n; i++ {
+		h.Publish(events.Event{ID: i})
+		if e := <-fast.C; e.ID != i {
+			t.Fatalf("Expected event %d, got %d", i, e.ID)
+		}
+	}
+
+	// What the slow subscriber has buffered is still delivered, in order,
+	// before its channel closes.
+	var last int64
+	for e := range slow.C {
+		if e.ID != last+1 {
+			t.Fatalf("Expected event %d, got %d", last+1, e.ID)
+		}
+		last = e.ID
+	}
+	if last == 0 || last == n {
+		t.Fatalf("Expected the slow subscriber dropped part way, got %d events", last)
+	}
+
+	// Closing a dropped subscription is harmless.
+	slow.Close()
+}
+
+// TestSubscriptionClose checks that a closed subscription stops receiving
+// events and may be closed again.
+func TestSubscriptionClose(t *testing.T) {
+	h := events.NewHub()
+	s := h.Subscribe()
+
+	h.Publish(events.Event{ID: 1})
+	s.Close()
+	s.Close()
+	h.Publish(events.Event{ID: 2})
+
+	var got []int64
+	for e := range s.C {
+		got = append(got, e.ID)
+	}
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("Expected only the event published before closing, got %v", got)
+	}
+}
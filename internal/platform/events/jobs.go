@@ -0,0 +1,24 @@
+package events
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"time"
+)
+
+// JobPrune is the kind of the job deleting old events.
+const JobPrune = "events.prune"
+
+// RegisterJobs registers the jobs of the package: every hour the events older
+// than retention are deleted.
+func RegisterJobs(r *jobs.Registry, db *database.Cluster, retention time.Duration) error {
+	return r.Register(jobs.Definition{
+		Kind:     JobPrune,
+		Schedule: "@hourly",
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			_, err := Prune(ctx, db.Primary(), time.Now().Add(-retention))
+			return err
+		},
+	})
+}
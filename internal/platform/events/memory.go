@@ -0,0 +1,68 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Memory is a Feed kept in memory, for tests and stores that do not need a
+// database. Events are published as soon as they are appended.
+type Memory struct {
+	*Hub
+
+	mu   sync.RWMutex
+	list []Event
+}
+
+// NewMemory constructs an empty Memory feed.
+func NewMemory() *Memory {
+	return &Memory{
+		Hub: NewHub(),
+	}
+}
+
+// Append records an event of typ about subject and publishes it.
+func (m *Memory) Append(typ, subject string, data interface{}, now time.Time) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling event data")
+	}
+
+	// Publishing under the lock keeps subscribers seeing events in order.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := Event{
+		ID:        int64(len(m.list) + 1),
+		Type:      typ,
+		Subject:   subject,
+		Data:      raw,
+		CreatedAt: now,
+	}
+	m.list = append(m.list, e)
+	m.Publish(e)
+
+	return &e, nil
+}
+
+// Since returns up to limit events appended after the event afterID.
+func (m *Memory) Since(ctx context.Context, afterID int64, limit int) ([]Event, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// IDs are positions in the list starting at 1.
+	if afterID < 0 {
+		afterID = 0
+	}
+	if afterID >= int64(len(m.list)) {
+		return nil, nil
+	}
+	list := m.list[afterID:]
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return append([]Event(nil), list...), nil
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Postgres is the Feed of the events table. Events reach its subscriptions
+// once Listen relays the notifications of the database, whichever instance
+// recorded them.
+type Postgres struct {
+	*Hub
+	db *database.Cluster
+}
+
+// NewPostgres constructs a Postgres feed for the provided cluster.
+func NewPostgres(db *database.Cluster) *Postgres {
+	return &Postgres{
+		Hub: NewHub(),
+		db:  db,
+	}
+}
+
+// Since returns up to limit events recorded after the event afterID. They are
+// read from the primary as replicas may not have the latest events yet.
+func (p *Postgres) Since(ctx context.Context, afterID int64, limit int) ([]Event, error) {
+	return Since(ctx, p.db.Primary(), afterID, limit)
+}
+
+// Listen relays the events committed by any instance to the subscriptions
+// until ctx is done. dsn must reach the primary. Notifications only say that
+// events were committed: they are relayed in order with Since, so an event
+// held back behind a running transaction is relayed once it ends, and after
+// the connection was lost the events committed meanwhile are relayed once it
+// is back.
+func (p *Postgres) Listen(ctx context.Context, dsn string, log *log.Logger) error {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("events: listener: %v", err)
+		}
+	})
+	defer l.Close()
+
+	if err := l.Listen(Channel); err != nil {
+		return errors.Wrap(err, "listening for events")
+	}
+
+	// last is the newest event relayed, from where to catch up. notified is
+	// the newest event notified; while it is ahead of last some events are
+	// held back and are polled for, as the transaction holding them back may
+	// notify nothing when it ends.
+	last, err := p.latest(ctx, visible)
+	if err != nil {
+		return err
+	}
+	notified := last
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+	poll := time.NewTicker(time.Second)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ping.C:
+			// A silent connection may be dead without pq noticing.
+			go l.Ping()
+			continue
+
+		case <-poll.C:
+			if notified <= last {
+				continue
+			}
+
+		case n := <-l.Notify:
+			// pq sends nil after reconnecting; notifications sent while it
+			// was away are lost, the newest event stands in for them.
+			if n == nil {
+				id, err := p.latest(ctx, "TRUE")
+				if err != nil {
+					log.Printf("events: catching up after reconnect: %v", err)
+				}
+				if id > notified {
+					notified = id
+				}
+			} else {
+				id, err := strconv.ParseInt(n.Extra, 10, 64)
+				if err != nil {
+					log.Printf("events: unexpected notification %q", n.Extra)
+					continue
+				}
+				if id > notified {
+					notified = id
+				}
+			}
+		}
+
+		if err := p.catchUp(ctx, &last); err != nil && ctx.Err() == nil {
+			log.Printf("events: catching up: %v", err)
+		}
+	}
+}
+
+// latest returns the ID of the newest event matching where.
+func (p *Postgres) latest(ctx context.Context, where string) (int64, error) {
+	q := `SELECT COALESCE(MAX(event_id), 0) FROM events WHERE ` + where + `;`
+
+	var id int64
+	if err := p.db.Primary().GetContext(ctx, &id, q); err != nil {
+		return 0, errors.Wrap(err, "finding the latest event")
+	}
+	return id, nil
+}
+
+// catchUp publishes the events recorded after last.
+func (p *Postgres) catchUp(ctx context.Context, last *int64) error {
+	const batch = 500
+	for {
+		list, err := p.Since(ctx, *last, batch)
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			p.Publish(e)
+			*last = e.ID
+		}
+		if len(list) < batch {
+			return nil
+		}
+	}
+}
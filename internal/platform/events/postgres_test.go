@@ -0,0 +1,89 @@
+package events_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestListen checks that the events committed to the database reach the
+// subscriptions, including those committed while the listener was cut off.
+func TestListen(t *testing.T) {
+	t.Parallel()
+
+	db, url := databasetest.SetupURL(t)
+	feed := events.NewPostgres(database.NewCluster(db))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- feed.Listen(ctx, url, log.New(ioutil.Discard, "", 0))
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Listening: %v", err)
+		}
+	}()
+
+	s := feed.Subscribe()
+	defer s.Close()
+
+	// listeners counts the connections of the feed waiting for events.
+	const listeners = `SELECT COUNT(*) FROM pg_stat_activity WHERE datname = current_database()
+AND pid <> pg_backend_pid() AND query LIKE 'LISTEN%';`
+	waitListening := func() {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			var n int
+			if err := db.Get(&n, listeners); err != nil {
+				t.Fatalf("Counting listeners: %v", err)
+			}
+			if n > 0 {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("Timed out waiting for the feed to listen")
+	}
+	appendEvent := func(subject string) {
+		t.Helper()
+		if _, err := events.Append(ctx, db, "product.created", subject, struct{}{}, time.Now()); err != nil {
+			t.Fatalf("Appending %s: %v", subject, err)
+		}
+	}
+	receive := func(subject string) {
+		t.Helper()
+		select {
+		case e, ok := <-s.C:
+			if !ok || e.Subject != subject {
+				t.Fatalf("Expected the %s event, got %+v", subject, e)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for the %s event", subject)
+		}
+	}
+
+	waitListening()
+	appendEvent("first")
+	receive("first")
+
+	// The listener reconnects a second after losing its connection; the
+	// event committed meanwhile is only relayed by catching up.
+	const terminate = `SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = current_database()
+AND pid <> pg_backend_pid() AND query LIKE 'LISTEN%';`
+	if _, err := db.Exec(terminate); err != nil {
+		t.Fatalf("Cutting the listener off: %v", err)
+	}
+	appendEvent("second")
+	receive("second")
+
+	waitListening()
+	appendEvent("third")
+	receive("third")
+}
@@ -0,0 +1,179 @@
+// Package health decides whether the service is ready for traffic. Checkers
+// of the dependencies are registered once at startup and run, concurrently
+// and each under its own timeout, every time readiness is asked for.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses reported by Registry.Ready.
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not ready"
+	StatusDraining = "shutting down"
+	StatusDegraded = "degraded"
+)
+
+// DefaultTimeout bounds checkers registered without a timeout.
+const DefaultTimeout = time.Second
+
+// CheckFunc reports whether a dependency is usable. It must return once ctx
+// is done.
+type CheckFunc func(ctx context.Context) error
+
+// Checker is a named dependency check.
+type Checker struct {
+	Name    string
+	Timeout time.Duration
+	Check   CheckFunc
+
+	// Optional checkers are reported but never make the service not ready.
+	// They suit dependencies the service can work without, such as read
+	// replicas or the trace exporter.
+	Optional bool
+}
+
+// Build identifies the running binary.
+type Build struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// Result is the outcome of a single checker.
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Optional bool    `json:"optional,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// Report is the outcome of a readiness check.
+type Report struct {
+	Status string   `json:"status"`
+	Build  Build    `json:"build"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+// Ready tells whether the report allows traffic. A degraded service is
+// still ready.
+func (r Report) Ready() bool {
+	return r.Status == StatusReady || r.Status == StatusDegraded
+}
+
+// Registry holds the checkers of the service. It is safe for concurrent use.
+type Registry struct {
+	build    Build
+	draining int32
+
+	mu       sync.RWMutex
+	checkers []Checker
+}
+
+// NewRegistry constructs a Registry without any checker.
+func NewRegistry(build Build) *Registry {
+	return &Registry{build: build}
+}
+
+// Build returns the build the registry reports.
+func (r *Registry) Build() Build {
+	return r.build
+}
+
+// Register adds a checker. Checkers are run in no particular order.
+func (r *Registry) Register(c Checker) {
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+
+	r.mu.Lock()
+	r.checkers = append(r.checkers, c)
+	r.mu.Unlock()
+}
+
+// Drain makes the service not ready for good. It is called as soon as
+// shutdown starts so load balancers stop sending new requests.
+func (r *Registry) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// Draining tells whether Drain has been called.
+func (r *Registry) Draining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+// Ready runs every checker and aggregates their results. Checkers are skipped
+// once the registry is draining.
+func (r *Registry) Ready(ctx context.Context) Report {
+	report := Report{Build: r.build}
+	if r.Draining() {
+		report.Status = StatusDraining
+		return report
+	}
+
+	r.mu.RLock()
+	checkers := make([]Checker, len(r.checkers))
+	copy(checkers, r.checkers)
+	r.mu.RUnlock()
+
+	report.Checks = make([]Result, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func(i int, c Checker) {
+			defer wg.Done()
+			report.Checks[i] = run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report.Status = StatusReady
+	for _, res := range report.Checks {
+		if res.Status == StatusReady {
+			continue
+		}
+		if !res.Optional {
+			report.Status = StatusNotReady
+			break
+		}
+		report.Status = StatusDegraded
+	}
+
+	return report
+}
+
+// run calls a single checker under its timeout. A checker that ignores its
+// context is abandoned when the timeout expires.
+func run(ctx context.Context, c Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{
+		Name:     c.Name,
+		Status:   StatusReady,
+		Optional: c.Optional,
+		Seconds:  time.Since(start).Seconds(),
+	}
+	if err != nil {
+		res.Status = StatusNotReady
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package health_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/health"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+// ok and failing are checkers used across the tests.
+func ok(ctx context.Context) error { return nil }
+
+func failing(ctx context.Context) error { return errors.New("boom") }
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		checkers []health.Checker
+		want     string
+	}{
+		{"NoCheckers", nil, health.StatusReady},
+		{"AllPass", []health.Checker{{Name: "a", Check: ok}, {Name: "b", Check: ok}}, health.StatusReady},
+		{"RequiredFails", []health.Checker{{Name: "a", Check: ok}, {Name: "b", Check: failing}}, health.StatusNotReady},
+		{"OptionalFails", []health.Checker{{Name: "a", Check: ok}, {Name: "b", Check: failing, Optional: true}}, health.StatusDegraded},
+		{"BothFail", []health.Checker{{Name: "a", Check: failing, Optional: true}, {Name: "b", Check: failing}}, health.StatusNotReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := health.NewRegistry(health.Build{})
+			for _, c := range tt.checkers {
+				r.Register(c)
+			}
+
+			report := r.Ready(context.Background())
+			if report.Status != tt.want {
+				t.Fatalf("Expected %q, got %q: %+v", tt.want, report.Status, report.Checks)
+			}
+			if len(report.Checks) != len(tt.checkers) {
+				t.Fatalf("Expected %d results, got %d", len(tt.checkers), len(report.Checks))
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	r := health.NewRegistry(health.Build{})
+
+	// The checker ignores its context so the registry must give up on it.
+	block := make(chan struct{})
+	defer close(block)
+	r.Register(health.Checker{
+		Name:    "slow",
+		Timeout: 10 * time.Millisecond,
+		Check: func(ctx context.Context) error {
+			<-block
+			return nil
+		},
+	})
+
+	start := time.Now()
+	report := r.Ready(context.Background())
+	if time.Since(start) > time.Second {
+		t.Fatalf("Expected the checker to be abandoned, waited %v", time.Since(start))
+	}
+	if report.Ready() || report.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("Expected a timeout, got %+v", report)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	r := health.NewRegistry(health.Build{Version: "v1"})
+	r.Register(health.Checker{Name: "a", Check: ok})
+	r.Drain()
+
+	report := r.Ready(context.Background())
+	if report.Ready() || report.Status != health.StatusDraining || report.Build.Version != "v1" {
+		t.Fatalf("Unexpected report once draining: %+v", report)
+	}
+}
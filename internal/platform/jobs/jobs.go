@@ -0,0 +1,253 @@
+// Package jobs runs work outside of requests. Jobs wait in a Postgres table
+// that any number of runners, in the API or in the worker, take from with
+// SELECT ... FOR UPDATE SKIP LOCKED, so each job runs on one runner at a time.
+// Failed runs are retried with backoff until the job runs out of attempts and
+// is kept as a dead letter. Domain packages register the kinds of jobs they
+// handle, optionally on a cron schedule; a scheduled job fires on one runner
+// only and never overlaps with its previous run.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is used when a specific Job is requested but does not
+	// exist.
+	ErrNotFound = errors.New("Job not found")
+
+	// ErrDuplicate is returned when queuing a Job while another with the
+	// same unique key is queued or running.
+	ErrDuplicate = errors.New("Job already queued")
+
+	// ErrNotRetryable is returned when retrying a Job that is not dead.
+	ErrNotRetryable = errors.New("Only dead jobs can be retried")
+
+	// ErrLostClaim is returned when recording the outcome of a run whose
+	// claim passed to another runner, which then owns the Job.
+	ErrLostClaim = errors.New("Job claimed by another runner")
+)
+
+// columns are the columns of a Job.
+const columns = `job_id, kind, payload, status, attempts, run_at, unique_key, locked_by, locked_until, last_error,
+created_at, updated_at, finished_at`
+
+// Enqueue queues a Job. It joins the transaction of db, if any, so work can
+// be queued atomically with the change calling for it.
+func Enqueue(ctx context.Context, db database.Queryer, nj NewJob, now time.Time) (*Job, error) {
+	payload := nj.Payload
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	runAt := nj.RunAt
+	if runAt.IsZero() {
+		runAt = now
+	}
+	var key *string
+	if nj.UniqueKey != "" {
+		key = &nj.UniqueKey
+	}
+
+	q := `INSERT INTO jobs (kind, payload, status, run_at, unique_key, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $6)
+ON CONFLICT (unique_key) WHERE status IN ('queued', 'running') DO NOTHING
+RETURNING ` + columns + `;`
+
+	var j Job
+	if err := db.GetContext(ctx, &j, q, nj.Kind, []byte(payload), StatusQueued, runAt, key, now); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDuplicate
+		}
+		return nil, errors.Wrapf(err, "queuing %s job", nj.Kind)
+	}
+	return &j, nil
+}
+
+// Claim takes up to limit due Jobs of the kinds and marks them running on
+// worker until lease passed. A Job whose lease passed while running is
+// claimed again: its runner is presumed dead.
+func Claim(ctx context.Context, db database.Queryer, kinds []string, worker string, now time.Time,
+	lease time.Duration, limit int) ([]Job, error) {
+	q := `UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_by = $2, locked_until = $3, updated_at = $1
+WHERE job_id IN (
+	SELECT job_id FROM jobs WHERE kind = ANY($4) AND run_at <= $1
+	AND (status = 'queued' OR (status = 'running' AND locked_until < $1))
+	ORDER BY run_at LIMIT $5 FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + columns + `;`
+
+	var list []Job
+	if err := db.SelectContext(ctx, &list, q, now, worker, now.Add(lease), pq.StringArray(kinds), limit); err != nil {
+		return nil, errors.Wrap(err, "claiming jobs")
+	}
+	return list, nil
+}
+
+// Finish marks a Job succeeded. It returns ErrLostClaim unless the Job is
+// still running on worker.
+func Finish(ctx context.Context, db database.Queryer, id int64, worker string, now time.Time) error {
+	const q = `UPDATE jobs SET status = 'succeeded', locked_by = NULL, locked_until = NULL, updated_at = $3,
+finished_at = $3 WHERE job_id = $1 AND status = 'running' AND locked_by = $2;`
+	return claimed(exec(ctx, db, id, q, worker, now))
+}
+
+// Fail records the error of a run. The Job is queued again at retryAt, or is
+// dead when retryAt is nil. It returns ErrLostClaim unless the Job is still
+// running on worker.
+func Fail(ctx context.Context, db database.Queryer, id int64, worker, msg string, now time.Time,
+	retryAt *time.Time) error {
+	if retryAt == nil {
+		const q = `UPDATE jobs SET status = 'dead', last_error = $3, locked_by = NULL, locked_until = NULL,
+updated_at = $4, finished_at = $4 WHERE job_id = $1 AND status = 'running' AND locked_by = $2;`
+		return claimed(exec(ctx, db, id, q, worker, msg, now))
+	}
+
+	const q = `UPDATE jobs SET status = 'queued', last_error = $3, locked_by = NULL, locked_until = NULL,
+updated_at = $4, run_at = $5 WHERE job_id = $1 AND status = 'running' AND locked_by = $2;`
+	return claimed(exec(ctx, db, id, q, worker, msg, now, *retryAt))
+}
+
+// Release queues a Job running on worker again without counting the run
+// against its attempts. It is for runs cut short by the runner stopping. It
+// returns ErrLostClaim unless the Job is still running on worker.
+func Release(ctx context.Context, db database.Queryer, id int64, worker string, now time.Time) error {
+	const q = `UPDATE jobs SET status = 'queued', attempts = attempts - 1, locked_by = NULL, locked_until = NULL,
+updated_at = $3, run_at = $3 WHERE job_id = $1 AND status = 'running' AND locked_by = $2;`
+	return claimed(exec(ctx, db, id, q, worker, now))
+}
+
+// Retry queues a dead Job again with a fresh set of attempts.
+func Retry(ctx context.Context, db database.Queryer, id int64, now time.Time) error {
+	j, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+	if j.Status != StatusDead {
+		return ErrNotRetryable
+	}
+
+	const q = `UPDATE jobs SET status = 'queued', attempts = 0, run_at = $2, updated_at = $2, finished_at = NULL
+WHERE job_id = $1 AND status = 'dead';`
+	if err := exec(ctx, db, id, q, now); err != nil {
+		if pqErr, ok := errors.Cause(err).(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return ErrDuplicate
+		}
+		return err
+	}
+	return nil
+}
+
+// Retrieve returns a single Job.
+func Retrieve(ctx context.Context, db database.Queryer, id int64) (*Job, error) {
+	q := `SELECT ` + columns + ` FROM jobs WHERE job_id = $1;`
+
+	var j Job
+	if err := db.GetContext(ctx, &j, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting job %d", id)
+	}
+	return &j, nil
+}
+
+// List returns Jobs, newest first.
+func List(ctx context.Context, db database.Queryer, f Filter) ([]Job, error) {
+	q := `SELECT ` + columns + ` FROM jobs WHERE ($1 = '' OR kind = $1) AND ($2 = '' OR status = $2)
+ORDER BY job_id DESC LIMIT $3;`
+
+	list := []Job{}
+	if err := db.SelectContext(ctx, &list, q, f.Kind, f.Status, database.PageSize(f.Limit)); err != nil {
+		return nil, errors.Wrap(err, "selecting jobs")
+	}
+	return list, nil
+}
+
+// Stats counts the Jobs of every kind by status.
+func Stats(ctx context.Context, db database.Queryer) ([]Stat, error) {
+	const q = `SELECT kind, status, COUNT(*) AS count FROM jobs GROUP BY kind, status ORDER BY kind, status;`
+
+	list := []Stat{}
+	if err := db.SelectContext(ctx, &list, q); err != nil {
+		return nil, errors.Wrap(err, "counting jobs")
+	}
+	return list, nil
+}
+
+// Prune deletes the Jobs that succeeded before before and returns how many
+// there were. Dead jobs are kept until someone looks at them.
+func Prune(ctx context.Context, db database.Queryer, before time.Time) (int64, error) {
+	const q = `DELETE FROM jobs WHERE status = 'succeeded' AND finished_at < $1;`
+
+	res, err := db.ExecContext(ctx, q, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "pruning jobs")
+	}
+	return res.RowsAffected()
+}
+
+// Fire queues the Job of the schedule name when it is due at now and moves
+// the schedule to next. A schedule seen for the first time is due at next.
+// It reports whether the schedule was due; concurrent runners skip a
+// schedule another one is firing.
+func Fire(ctx context.Context, db *database.Cluster, name string, nj NewJob, now, next time.Time) (bool, error) {
+	var fired bool
+	err := db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		const qi = `INSERT INTO job_schedules (name, next_run_at) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+		if _, err := tx.ExecContext(ctx, qi, name, next); err != nil {
+			return errors.Wrapf(err, "creating schedule %s", name)
+		}
+
+		const qs = `SELECT next_run_at FROM job_schedules WHERE name = $1 FOR UPDATE SKIP LOCKED;`
+		var due time.Time
+		if err := tx.GetContext(ctx, &due, qs, name); err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return errors.Wrapf(err, "locking schedule %s", name)
+		}
+		if due.After(now) {
+			return nil
+		}
+
+		// A run still going from the last time is not doubled.
+		if _, err := Enqueue(ctx, tx, nj, now); err != nil && err != ErrDuplicate {
+			return err
+		}
+
+		const qu = `UPDATE job_schedules SET next_run_at = $2, last_run_at = $3 WHERE name = $1;`
+		if _, err := tx.ExecContext(ctx, qu, name, next, now); err != nil {
+			return errors.Wrapf(err, "moving schedule %s", name)
+		}
+		fired = true
+		return nil
+	})
+	return fired, err
+}
+
+// exec runs a statement changing the Job id and reports ErrNotFound when
+// there is no such Job.
+func exec(ctx context.Context, db database.Queryer, id int64, q string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, q, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return errors.Wrapf(err, "updating job %d", id)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// claimed reports a run whose Job was not updated as a lost claim.
+func claimed(err error) error {
+	if err == ErrNotFound {
+		return ErrLostClaim
+	}
+	return err
+}
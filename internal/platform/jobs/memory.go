@@ -0,0 +1,318 @@
+package jobs
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements Store in memory. It is safe for concurrent use and is
+// meant for tests that should not need a database.
+type Memory struct {
+	mu        sync.Mutex
+	jobs      map[int64]*Job
+	last      int64
+	schedules map[string]time.Time
+}
+
+// NewMemory constructs an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		jobs:      make(map[int64]*Job),
+		schedules: make(map[string]time.Time),
+	}
+}
+
+// Enqueue queues a Job.
+func (m *Memory) Enqueue(ctx context.Context, nj NewJob, now time.Time) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.enqueue(nj, now)
+}
+
+// Fire queues the Job of the schedule name when it is due at now and moves
+// the schedule to next.
+func (m *Memory) Fire(ctx context.Context, name string, nj NewJob, now, next time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due, ok := m.schedules[name]
+	if !ok {
+		m.schedules[name] = next
+		due = next
+	}
+	if due.After(now) {
+		return false, nil
+	}
+
+	if _, err := m.enqueue(nj, now); err != nil && err != ErrDuplicate {
+		return false, err
+	}
+	m.schedules[name] = next
+	return true, nil
+}
+
+// Claim takes up to limit due Jobs of the kinds until lease passed.
+func (m *Memory) Claim(ctx context.Context, kinds []string, worker string, now time.Time,
+	lease time.Duration, limit int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*Job
+	for _, j := range m.sorted() {
+		switch {
+		case !contains(kinds, j.Kind) || j.RunAt.After(now):
+		case j.Status == StatusQueued, j.Status == StatusRunning && j.LockedUntil.Before(now):
+			due = append(due, j)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	list := make([]Job, 0, len(due))
+	for _, j := range due {
+		until := now.Add(lease)
+		j.Status = StatusRunning
+		j.Attempts++
+		j.LockedBy = &worker
+		j.LockedUntil = &until
+		j.UpdatedAt = now
+		list = append(list, *j)
+	}
+	return list, nil
+}
+
+// Finish marks a Job running on worker succeeded.
+func (m *Memory) Finish(ctx context.Context, id int64, worker string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.claimed(id, worker)
+	if !ok {
+		return ErrLostClaim
+	}
+	j.Status = StatusSucceeded
+	j.LockedBy, j.LockedUntil = nil, nil
+	j.UpdatedAt = now
+	j.FinishedAt = &now
+	return nil
+}
+
+// Fail records the error of a run of a Job on worker. The Job is queued
+// again at retryAt, or is dead when retryAt is nil.
+func (m *Memory) Fail(ctx context.Context, id int64, worker, msg string, now time.Time, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.claimed(id, worker)
+	if !ok {
+		return ErrLostClaim
+	}
+	j.LastError = &msg
+	j.LockedBy, j.LockedUntil = nil, nil
+	j.UpdatedAt = now
+	if retryAt == nil {
+		j.Status = StatusDead
+		j.FinishedAt = &now
+		return nil
+	}
+	j.Status = StatusQueued
+	j.RunAt = *retryAt
+	return nil
+}
+
+// Release queues a Job running on worker again without counting the run
+// against its attempts.
+func (m *Memory) Release(ctx context.Context, id int64, worker string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.claimed(id, worker)
+	if !ok {
+		return ErrLostClaim
+	}
+	j.Status = StatusQueued
+	j.Attempts--
+	j.LockedBy, j.LockedUntil = nil, nil
+	j.UpdatedAt = now
+	j.RunAt = now
+	return nil
+}
+
+// List returns Jobs, newest first.
+func (m *Memory) List(ctx context.Context, f Filter) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	all := m.sorted()
+	list := []Job{}
+	for i := len(all) - 1; i >= 0 && len(list) < database.PageSize(f.Limit); i-- {
+		j := all[i]
+		switch {
+		case f.Kind != "" && j.Kind != f.Kind:
+		case f.Status != "" && j.Status != f.Status:
+		default:
+			list = append(list, *j)
+		}
+	}
+	return list, nil
+}
+
+// Retrieve returns a single Job.
+func (m *Memory) Retrieve(ctx context.Context, id int64) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *j
+	return &cp, nil
+}
+
+// Stats counts the Jobs of every kind by status.
+func (m *Memory) Stats(ctx context.Context) ([]Stat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[Stat]int)
+	for _, j := range m.jobs {
+		counts[Stat{Kind: j.Kind, Status: j.Status}]++
+	}
+
+	list := make([]Stat, 0, len(counts))
+	for s, n := range counts {
+		s.Count = n
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind == list[j].Kind {
+			return list[i].Status < list[j].Status
+		}
+		return list[i].Kind < list[j].Kind
+	})
+	return list, nil
+}
+
+// Retry queues a dead Job again with a fresh set of attempts.
+func (m *Memory) Retry(ctx context.Context, id int64, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.Status != StatusDead {
+		return ErrNotRetryable
+	}
+	if j.UniqueKey != nil && m.pending(*j.UniqueKey) {
+		return ErrDuplicate
+	}
+	j.Status = StatusQueued
+	j.Attempts = 0
+	j.RunAt = now
+	j.UpdatedAt = now
+	j.FinishedAt = nil
+	return nil
+}
+
+// Prune deletes the Jobs that succeeded before before.
+func (m *Memory) Prune(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, j := range m.jobs {
+		if j.Status == StatusSucceeded && j.FinishedAt.Before(before) {
+			delete(m.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// enqueue queues a Job unless its unique key is taken. The caller must hold
+// the lock.
+func (m *Memory) enqueue(nj NewJob, now time.Time) (*Job, error) {
+	if nj.UniqueKey != "" && m.pending(nj.UniqueKey) {
+		return nil, ErrDuplicate
+	}
+
+	m.last++
+	j := Job{
+		ID:        m.last,
+		Kind:      nj.Kind,
+		Payload:   nj.Payload,
+		Status:    StatusQueued,
+		RunAt:     nj.RunAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if len(j.Payload) == 0 {
+		j.Payload = []byte("{}")
+	}
+	if j.RunAt.IsZero() {
+		j.RunAt = now
+	}
+	if nj.UniqueKey != "" {
+		key := nj.UniqueKey
+		j.UniqueKey = &key
+	}
+	m.jobs[j.ID] = &j
+
+	cp := j
+	return &cp, nil
+}
+
+// claimed returns the Job id if it is running on worker. The caller must
+// hold the lock.
+func (m *Memory) claimed(id int64, worker string) (*Job, bool) {
+	j, ok := m.jobs[id]
+	if !ok || j.Status != StatusRunning || j.LockedBy == nil || *j.LockedBy != worker {
+		return nil, false
+	}
+	return j, true
+}
+
+// pending reports whether a Job with the unique key is queued or running.
+// The caller must hold the lock.
+func (m *Memory) pending(key string) bool {
+	for _, j := range m.jobs {
+		if j.UniqueKey != nil && *j.UniqueKey == key && (j.Status == StatusQueued || j.Status == StatusRunning) {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the Jobs in the order they were queued. The caller must
+// hold the lock.
+func (m *Memory) sorted() []*Job {
+	list := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The statuses of a Job. A queued Job runs once its run time came; a failed
+// run queues it again later until it ran out of attempts and is dead. Dead
+// jobs are the dead letters: they only run again when retried.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// Job is a unit of work of a registered kind.
+type Job struct {
+	ID          int64           `db:"job_id" json:"id"`
+	Kind        string          `db:"kind" json:"kind"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	Status      string          `db:"status" json:"status"`
+	Attempts    int             `db:"attempts" json:"attempts"`
+	RunAt       time.Time       `db:"run_at" json:"run_at"`
+	UniqueKey   *string         `db:"unique_key" json:"unique_key,omitempty"`
+	LockedBy    *string         `db:"locked_by" json:"locked_by,omitempty"`
+	LockedUntil *time.Time      `db:"locked_until" json:"locked_until,omitempty"`
+	LastError   *string         `db:"last_error" json:"last_error,omitempty"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time       `db:"updated_at" json:"updated_at"`
+	FinishedAt  *time.Time      `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+// NewJob is what is needed to queue a Job. It runs at once when RunAt is
+// zero. While a Job with the same UniqueKey is queued or running no other is
+// queued.
+type NewJob struct {
+	Kind      string          `json:"kind" validate:"required"`
+	Payload   json.RawMessage `json:"payload"`
+	RunAt     time.Time       `json:"run_at"`
+	UniqueKey string          `json:"unique_key"`
+}
+
+// Filter narrows a list of Jobs. Empty fields match everything.
+type Filter struct {
+	Kind   string
+	Status string
+	Limit  int
+}
+
+// Stat is how many Jobs of a kind have a status.
+type Stat struct {
+	Kind   string `db:"kind" json:"kind"`
+	Status string `db:"status" json:"status"`
+	Count  int    `db:"count" json:"count"`
+}
@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"sort"
+	"time"
+)
+
+// Handler does the work of a Job. A returned error, or a panic, fails the
+// run; the Job is then retried until it runs out of attempts.
+type Handler func(ctx context.Context, j Job) error
+
+// Definition describes a kind of Job and how it runs.
+type Definition struct {
+	Kind    string
+	Handler Handler
+
+	// A Job failing MaxAttempts times in a row is dead. The attempt after
+	// the nth failure is made Backoff(n) later.
+	MaxAttempts int
+	Backoff     func(failures int) time.Duration
+
+	// Timeout bounds every run. It is also the lease of the claim: a Job
+	// still running after it is presumed lost and is claimed again.
+	Timeout time.Duration
+
+	// Schedule, when set, queues a Job of the kind on a cron spec such as
+	// "*/15 * * * *" or "@every 5m", in UTC. Only one scheduled Job of a kind
+	// is queued or running at a time.
+	Schedule string
+
+	schedule cron.Schedule
+}
+
+// Registry holds the Definitions a Runner runs.
+type Registry struct {
+	defs map[string]*Definition
+}
+
+// NewRegistry constructs an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{defs: make(map[string]*Definition)}
+}
+
+// Register adds a kind of Job. Missing settings get defaults: five attempts,
+// a backoff from 10s doubling up to an hour and a timeout of a minute.
+func (r *Registry) Register(d Definition) error {
+	if d.Kind == "" || d.Handler == nil {
+		return errors.New("jobs: a definition needs a kind and a handler")
+	}
+	if _, ok := r.defs[d.Kind]; ok {
+		return errors.Errorf("jobs: %s is already registered", d.Kind)
+	}
+
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = 5
+	}
+	if d.Backoff == nil {
+		d.Backoff = ExponentialBackoff(10*time.Second, time.Hour)
+	}
+	if d.Timeout <= 0 {
+		d.Timeout = time.Minute
+	}
+	if d.Schedule != "" {
+		s, err := cron.ParseStandard(d.Schedule)
+		if err != nil {
+			return errors.Wrapf(err, "jobs: parsing the schedule of %s", d.Kind)
+		}
+		d.schedule = s
+	}
+
+	r.defs[d.Kind] = &d
+	return nil
+}
+
+// Lookup returns the Definition of a kind.
+func (r *Registry) Lookup(kind string) (Definition, bool) {
+	d, ok := r.defs[kind]
+	if !ok {
+		return Definition{}, false
+	}
+	return *d, true
+}
+
+// Kinds returns the registered kinds in order.
+func (r *Registry) Kinds() []string {
+	kinds := make([]string, 0, len(r.defs))
+	for k := range r.defs {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Next returns when the scheduled Job of a kind runs after t. It is zero for
+// kinds without a schedule.
+func (d Definition) Next(t time.Time) time.Time {
+	if d.schedule == nil {
+		return time.Time{}
+	}
+	return d.schedule.Next(t.UTC())
+}
+
+// ExponentialBackoff waits base after the first failure and doubles the wait
+// after every other one, up to max.
+func ExponentialBackoff(base, max time.Duration) func(failures int) time.Duration {
+	return func(failures int) time.Duration {
+		d := base
+		for i := 1; i < failures && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// JobPrune is the kind of the job deleting the Jobs that succeeded long ago.
+const JobPrune = "jobs.prune"
+
+// RegisterPrune registers the job deleting, every hour, the Jobs that
+// succeeded more than retention ago.
+func RegisterPrune(r *Registry, store Store, retention time.Duration) error {
+	return r.Register(Definition{
+		Kind:     JobPrune,
+		Schedule: "@hourly",
+		Handler: func(ctx context.Context, j Job) error {
+			_, err := store.Prune(ctx, time.Now().Add(-retention))
+			return err
+		},
+	})
+}
@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// grace is how long a claim outlives the timeout of its Job, leaving the
+// runner time to record the outcome.
+const grace = 30 * time.Second
+
+// Runner fires the schedules and runs the Jobs of a Registry. Several may run
+// against the same database, in the API and in workers.
+type Runner struct {
+	Store    Store
+	Registry *Registry
+	Log      *log.Logger
+
+	// Worker names the runner in the claims it makes.
+	Worker string
+
+	// Concurrency Jobs run at a time. The runner looks for due Jobs and
+	// schedules every PollInterval.
+	Concurrency  int
+	PollInterval time.Duration
+}
+
+// NewRunner constructs a Runner named after the host and the process.
+func NewRunner(store Store, registry *Registry, log *log.Logger) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		Store:        store,
+		Registry:     registry,
+		Log:          log,
+		Worker:       fmt.Sprintf("%s:%d", host, os.Getpid()),
+		Concurrency:  4,
+		PollInterval: time.Second,
+	}
+}
+
+// Run runs Jobs until ctx is done. The Jobs in flight then have their context
+// canceled and Run waits for them to return, so handlers must honour their
+// context for the service to stop in time. A Job cut short is queued again.
+func (r *Runner) Run(ctx context.Context) {
+	sem := make(chan struct{}, r.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	t := time.NewTicker(r.PollInterval)
+	defer t.Stop()
+
+	for {
+		now := time.Now()
+		if err := r.fire(ctx, now); err != nil && ctx.Err() == nil {
+			r.Log.Printf("jobs: %v", err)
+		}
+
+		if free := r.Concurrency - len(sem); free > 0 {
+			list, err := r.Store.Claim(ctx, r.Registry.Kinds(), r.Worker, now, r.lease(), free)
+			if err != nil && ctx.Err() == nil {
+				r.Log.Printf("jobs: %v", err)
+			}
+			for _, j := range list {
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(j Job) {
+					defer wg.Done()
+					defer func() { <-sem }()
+					r.run(ctx, j, time.Now)
+				}(j)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// RunOnce fires the schedules due at now and runs up to Concurrency due Jobs
+// as if it were now. It returns how many Jobs it ran.
+func (r *Runner) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	if err := r.fire(ctx, now); err != nil {
+		return 0, err
+	}
+
+	list, err := r.Store.Claim(ctx, r.Registry.Kinds(), r.Worker, now, r.lease(), r.Concurrency)
+	if err != nil {
+		return 0, err
+	}
+
+	clock := func() time.Time { return now }
+	var wg sync.WaitGroup
+	for _, j := range list {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			r.run(ctx, j, clock)
+		}(j)
+	}
+	wg.Wait()
+
+	return len(list), nil
+}
+
+// fire queues the Jobs of the schedules due at now.
+func (r *Runner) fire(ctx context.Context, now time.Time) error {
+	for _, kind := range r.Registry.Kinds() {
+		d, _ := r.Registry.Lookup(kind)
+		next := d.Next(now)
+		if next.IsZero() {
+			continue
+		}
+
+		nj := NewJob{Kind: kind, UniqueKey: kind}
+		if _, err := r.Store.Fire(ctx, kind, nj, now, next); err != nil {
+			return errors.Wrapf(err, "firing the schedule of %s", kind)
+		}
+	}
+	return nil
+}
+
+// run runs a claimed Job until it returns, its timeout passes or ctx is
+// done, and records the outcome at the time of clock.
+func (r *Runner) run(ctx context.Context, j Job, clock func() time.Time) {
+	d, ok := r.Registry.Lookup(j.Kind)
+	if !ok {
+		return
+	}
+
+	jctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	err := call(jctx, d.Handler, j)
+	cancel()
+
+	// The outcome is recorded even when the runner is stopping. A run whose
+	// claim passed to another runner records nothing: the Job is theirs.
+	now := clock()
+	if err != nil && ctx.Err() != nil {
+		if err := r.Store.Release(context.Background(), j.ID, r.Worker, now); err != nil {
+			r.Log.Printf("jobs: %s %d: %v", j.Kind, j.ID, err)
+		}
+		return
+	}
+	if err == nil {
+		if err := r.Store.Finish(context.Background(), j.ID, r.Worker, now); err != nil {
+			r.Log.Printf("jobs: %s %d: %v", j.Kind, j.ID, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if j.Attempts < d.MaxAttempts {
+		at := now.Add(d.Backoff(j.Attempts))
+		retryAt = &at
+	}
+	r.Log.Printf("jobs: %s %d: attempt %d of %d: %v", j.Kind, j.ID, j.Attempts, d.MaxAttempts, err)
+
+	if err := r.Store.Fail(context.Background(), j.ID, r.Worker, err.Error(), now, retryAt); err != nil {
+		r.Log.Printf("jobs: %s %d: %v", j.Kind, j.ID, err)
+	}
+}
+
+// lease is how long a claim lasts: the longest timeout of the registered
+// kinds, and some.
+func (r *Runner) lease() time.Duration {
+	var max time.Duration
+	for _, kind := range r.Registry.Kinds() {
+		if d, _ := r.Registry.Lookup(kind); d.Timeout > max {
+			max = d.Timeout
+		}
+	}
+	return max + grace
+}
+
+// call runs h, turning a panic into an error.
+func call(ctx context.Context, h Handler, j Job) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.Errorf("panic: %v\n%s", rec, debug.Stack())
+		}
+	}()
+	return h(ctx, j)
+}
@@ -0,0 +1,348 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestMemoryRunner runs the runner suite against the in-memory store.
+func TestMemoryRunner(t *testing.T) {
+	testRunner(t, jobs.NewMemory())
+}
+
+// TestPostgresRunner runs the runner suite against the Postgres store.
+func TestPostgresRunner(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+	testRunner(t, jobs.NewPostgres(database.NewCluster(db)))
+}
+
+// calls counts the runs of the handlers of the suite.
+type calls struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func (c *calls) add(kind string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n[kind]++
+	return c.n[kind]
+}
+
+func (c *calls) get(kind string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n[kind]
+}
+
+// testRunner describes how jobs are queued, run, retried, dead-lettered and
+// scheduled. The store is expected to start empty.
+func testRunner(t *testing.T, store jobs.Store) {
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 6, 10, 0, 0, 0, time.UTC)
+	c := calls{n: make(map[string]int)}
+
+	reg := jobs.NewRegistry()
+	register := func(d jobs.Definition) {
+		t.Helper()
+		if err := reg.Register(d); err != nil {
+			t.Fatalf("Registering %s: %v", d.Kind, err)
+		}
+	}
+	register(jobs.Definition{
+		Kind: "echo",
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			c.add("echo")
+			var p struct{ OK bool }
+			if err := json.Unmarshal(j.Payload, &p); err != nil {
+				return err
+			}
+			if !p.OK {
+				return errors.New("not ok")
+			}
+			return nil
+		},
+		MaxAttempts: 2,
+		Backoff:     jobs.ExponentialBackoff(time.Minute, time.Hour),
+	})
+	register(jobs.Definition{
+		Kind: "panic",
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			c.add("panic")
+			panic("boom")
+		},
+		MaxAttempts: 1,
+	})
+	register(jobs.Definition{
+		Kind:     "tick",
+		Schedule: "*/15 * * * *",
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			c.add("tick")
+			return nil
+		},
+	})
+
+	if err := reg.Register(jobs.Definition{Kind: "bad", Schedule: "every day",
+		Handler: func(context.Context, jobs.Job) error { return nil }}); err == nil {
+		t.Fatal("Expected an invalid schedule to be rejected")
+	}
+
+	r := jobs.NewRunner(store, reg, log.New(ioutil.Discard, "", 0))
+	run := func(t *testing.T, at time.Time, want int) {
+		t.Helper()
+		n, err := r.RunOnce(ctx, at)
+		if err != nil {
+			t.Fatalf("Running: %v", err)
+		}
+		if n != want {
+			t.Fatalf("Expected %d jobs run, got %d", want, n)
+		}
+	}
+	enqueue := func(t *testing.T, nj jobs.NewJob) *jobs.Job {
+		t.Helper()
+		j, err := store.Enqueue(ctx, nj, now)
+		if err != nil {
+			t.Fatalf("Enqueuing %s: %v", nj.Kind, err)
+		}
+		return j
+	}
+	retrieve := func(t *testing.T, id int64) *jobs.Job {
+		t.Helper()
+		j, err := store.Retrieve(ctx, id)
+		if err != nil {
+			t.Fatalf("Retrieving job %d: %v", id, err)
+		}
+		return j
+	}
+
+	t.Run("Succeed", func(t *testing.T) {
+		j := enqueue(t, jobs.NewJob{Kind: "echo", Payload: json.RawMessage(`{"OK": true}`), UniqueKey: "echo-ok"})
+		if _, err := store.Enqueue(ctx, jobs.NewJob{Kind: "echo", UniqueKey: "echo-ok"}, now); err != jobs.ErrDuplicate {
+			t.Fatalf("Expected %v queuing a duplicate, got %v", jobs.ErrDuplicate, err)
+		}
+
+		run(t, now, 1)
+		got := retrieve(t, j.ID)
+		if got.Status != jobs.StatusSucceeded || got.Attempts != 1 || got.FinishedAt == nil {
+			t.Fatalf("Expected a job succeeded at its first attempt, got %+v", got)
+		}
+
+		// Once the job is done its key is free again.
+		enqueue(t, jobs.NewJob{Kind: "echo", Payload: json.RawMessage(`{"OK": true}`), UniqueKey: "echo-ok"})
+		run(t, now, 1)
+	})
+
+	var failing int64
+	t.Run("Retry", func(t *testing.T) {
+		failing = enqueue(t, jobs.NewJob{Kind: "echo", Payload: json.RawMessage(`{"OK": false}`)}).ID
+		run(t, now, 1)
+
+		got := retrieve(t, failing)
+		if got.Status != jobs.StatusQueued || got.LastError == nil || !got.RunAt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("Expected the job queued again a minute later, got %+v", got)
+		}
+
+		// Nothing is due before the backoff passed.
+		run(t, now.Add(30*time.Second), 0)
+		run(t, now.Add(time.Minute), 1)
+
+		got = retrieve(t, failing)
+		if got.Status != jobs.StatusDead || got.Attempts != 2 || *got.LastError != "not ok" {
+			t.Fatalf("Expected the job dead after MaxAttempts, got %+v", got)
+		}
+		run(t, now.Add(10*time.Minute), 0)
+
+		if err := store.Retry(ctx, failing, now.Add(2*time.Minute)); err != nil {
+			t.Fatalf("Retrying: %v", err)
+		}
+		if got := retrieve(t, failing); got.Status != jobs.StatusQueued || got.Attempts != 0 {
+			t.Fatalf("Expected the job queued with fresh attempts, got %+v", got)
+		}
+		run(t, now.Add(2*time.Minute), 1)
+		run(t, now.Add(3*time.Minute), 1)
+		if got := retrieve(t, failing); got.Status != jobs.StatusDead || got.Attempts != 2 {
+			t.Fatalf("Expected the retried job dead again, got %+v", got)
+		}
+
+		if err := store.Retry(ctx, 999, now); err != jobs.ErrNotFound {
+			t.Fatalf("Expected %v retrying a missing job, got %v", jobs.ErrNotFound, err)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		j := enqueue(t, jobs.NewJob{Kind: "panic"})
+		run(t, now, 1)
+
+		got := retrieve(t, j.ID)
+		if got.Status != jobs.StatusDead || got.LastError == nil {
+			t.Fatalf("Expected a panicking job to die, got %+v", got)
+		}
+		if err := store.Retry(ctx, 1, now); err != jobs.ErrNotRetryable {
+			t.Fatalf("Expected %v retrying a succeeded job, got %v", jobs.ErrNotRetryable, err)
+		}
+	})
+
+	t.Run("LostClaim", func(t *testing.T) {
+		claim := func(t *testing.T, worker string, at time.Time) {
+			t.Helper()
+			list, err := store.Claim(ctx, []string{"lost"}, worker, at, time.Minute, 1)
+			if err != nil {
+				t.Fatalf("Claiming: %v", err)
+			}
+			if len(list) != 1 {
+				t.Fatalf("Expected %s to claim the job, got %+v", worker, list)
+			}
+		}
+
+		// The first runner stalls past its lease and a second one takes over.
+		j := enqueue(t, jobs.NewJob{Kind: "lost"})
+		claim(t, "first", now)
+		claim(t, "second", now.Add(2*time.Minute))
+
+		if err := store.Finish(ctx, j.ID, "first", now.Add(3*time.Minute)); err != jobs.ErrLostClaim {
+			t.Fatalf("Expected %v finishing a lost claim, got %v", jobs.ErrLostClaim, err)
+		}
+		if err := store.Fail(ctx, j.ID, "first", "late", now.Add(3*time.Minute), nil); err != jobs.ErrLostClaim {
+			t.Fatalf("Expected %v failing a lost claim, got %v", jobs.ErrLostClaim, err)
+		}
+		if got := retrieve(t, j.ID); got.Status != jobs.StatusRunning || got.LockedBy == nil || *got.LockedBy != "second" {
+			t.Fatalf("Expected the job still running on the second runner, got %+v", got)
+		}
+
+		if err := store.Finish(ctx, j.ID, "second", now.Add(3*time.Minute)); err != nil {
+			t.Fatalf("Finishing: %v", err)
+		}
+		if err := store.Finish(ctx, j.ID, "second", now.Add(3*time.Minute)); err != jobs.ErrLostClaim {
+			t.Fatalf("Expected %v finishing twice, got %v", jobs.ErrLostClaim, err)
+		}
+	})
+
+	t.Run("Schedule", func(t *testing.T) {
+		// The schedule was first seen at now, so it is only due at 10:15.
+		run(t, now.Add(5*time.Minute), 0)
+		run(t, now.Add(15*time.Minute), 1)
+		run(t, now.Add(20*time.Minute), 0)
+		run(t, now.Add(30*time.Minute), 1)
+		if n := c.get("tick"); n != 2 {
+			t.Fatalf("Expected 2 scheduled runs, got %d", n)
+		}
+
+		list, err := store.List(ctx, jobs.Filter{Kind: "tick"})
+		if err != nil {
+			t.Fatalf("Listing: %v", err)
+		}
+		if len(list) != 2 || list[0].UniqueKey == nil || *list[0].UniqueKey != "tick" {
+			t.Fatalf("Expected the two scheduled jobs keyed by their kind, got %+v", list)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		stats, err := store.Stats(ctx)
+		if err != nil {
+			t.Fatalf("Counting: %v", err)
+		}
+		want := []jobs.Stat{
+			{Kind: "echo", Status: jobs.StatusSucceeded, Count: 2},
+			{Kind: "echo", Status: jobs.StatusDead, Count: 1},
+			{Kind: "lost", Status: jobs.StatusSucceeded, Count: 1},
+			{Kind: "panic", Status: jobs.StatusDead, Count: 1},
+			{Kind: "tick", Status: jobs.StatusSucceeded, Count: 2},
+		}
+		if len(stats) != len(want) {
+			t.Fatalf("Expected %+v, got %+v", want, stats)
+		}
+		for _, w := range want {
+			found := false
+			for _, s := range stats {
+				found = found || s == w
+			}
+			if !found {
+				t.Fatalf("Expected %+v in %+v", w, stats)
+			}
+		}
+
+		n, err := store.Prune(ctx, now.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Pruning: %v", err)
+		}
+		if n != 5 {
+			t.Fatalf("Expected the 5 succeeded jobs pruned, got %d", n)
+		}
+		if _, err := store.Retrieve(ctx, failing); err != nil {
+			t.Fatalf("Expected dead jobs to be kept, got %v", err)
+		}
+	})
+}
+
+// TestRunnerStop checks stopping a runner cancels the Jobs in flight and
+// queues them again without using up an attempt.
+func TestRunnerStop(t *testing.T) {
+	store := jobs.NewMemory()
+	started := make(chan struct{})
+
+	reg := jobs.NewRegistry()
+	err := reg.Register(jobs.Definition{
+		Kind:    "block",
+		Timeout: time.Hour,
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err != nil {
+		t.Fatalf("Registering: %v", err)
+	}
+
+	j, err := store.Enqueue(context.Background(), jobs.NewJob{Kind: "block"}, time.Now())
+	if err != nil {
+		t.Fatalf("Enqueuing: %v", err)
+	}
+
+	r := jobs.NewRunner(store, reg, log.New(ioutil.Discard, "", 0))
+	r.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the job to start")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the runner to stop")
+	}
+
+	got, err := store.Retrieve(context.Background(), j.ID)
+	if err != nil {
+		t.Fatalf("Retrieving: %v", err)
+	}
+	if got.Status != jobs.StatusQueued || got.Attempts != 0 || got.LockedBy != nil || got.LastError != nil {
+		t.Fatalf("Expected the job queued again with its attempts intact, got %+v", got)
+	}
+}
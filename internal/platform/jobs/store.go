@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"time"
+)
+
+// Store is the set of operations the Runner, the API and the admin tool need
+// to queue, run and inspect Jobs.
+type Store interface {
+	Enqueue(ctx context.Context, nj NewJob, now time.Time) (*Job, error)
+	Fire(ctx context.Context, name string, nj NewJob, now, next time.Time) (bool, error)
+
+	Claim(ctx context.Context, kinds []string, worker string, now time.Time, lease time.Duration, limit int) ([]Job, error)
+	Finish(ctx context.Context, id int64, worker string, now time.Time) error
+	Fail(ctx context.Context, id int64, worker, msg string, now time.Time, retryAt *time.Time) error
+	Release(ctx context.Context, id int64, worker string, now time.Time) error
+
+	List(ctx context.Context, f Filter) ([]Job, error)
+	Retrieve(ctx context.Context, id int64) (*Job, error)
+	Stats(ctx context.Context) ([]Stat, error)
+	Retry(ctx context.Context, id int64, now time.Time) error
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Postgres implements Store with the functions of this package. Everything
+// runs on the primary: runners must see the claims of one another.
+type Postgres struct {
+	db *database.Cluster
+}
+
+// NewPostgres constructs a Postgres store for the provided cluster.
+func NewPostgres(db *database.Cluster) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Enqueue queues a Job.
+func (s *Postgres) Enqueue(ctx context.Context, nj NewJob, now time.Time) (*Job, error) {
+	return Enqueue(ctx, s.db.Primary(), nj, now)
+}
+
+// Fire queues the Job of a schedule when it is due.
+func (s *Postgres) Fire(ctx context.Context, name string, nj NewJob, now, next time.Time) (bool, error) {
+	return Fire(ctx, s.db, name, nj, now, next)
+}
+
+// Claim takes the due Jobs of the kinds.
+func (s *Postgres) Claim(ctx context.Context, kinds []string, worker string, now time.Time,
+	lease time.Duration, limit int) ([]Job, error) {
+	return Claim(ctx, s.db.Primary(), kinds, worker, now, lease, limit)
+}
+
+// Finish marks a Job running on worker succeeded.
+func (s *Postgres) Finish(ctx context.Context, id int64, worker string, now time.Time) error {
+	return Finish(ctx, s.db.Primary(), id, worker, now)
+}
+
+// Fail records the error of a run of a Job on worker.
+func (s *Postgres) Fail(ctx context.Context, id int64, worker, msg string, now time.Time, retryAt *time.Time) error {
+	return Fail(ctx, s.db.Primary(), id, worker, msg, now, retryAt)
+}
+
+// Release queues a Job running on worker again.
+func (s *Postgres) Release(ctx context.Context, id int64, worker string, now time.Time) error {
+	return Release(ctx, s.db.Primary(), id, worker, now)
+}
+
+// List returns Jobs, newest first.
+func (s *Postgres) List(ctx context.Context, f Filter) ([]Job, error) {
+	return List(ctx, s.db.Primary(), f)
+}
+
+// Retrieve returns a single Job.
+func (s *Postgres) Retrieve(ctx context.Context, id int64) (*Job, error) {
+	return Retrieve(ctx, s.db.Primary(), id)
+}
+
+// Stats counts the Jobs of every kind by status.
+func (s *Postgres) Stats(ctx context.Context) ([]Stat, error) {
+	return Stats(ctx, s.db.Primary())
+}
+
+// Retry queues a dead Job again.
+func (s *Postgres) Retry(ctx context.Context, id int64, now time.Time) error {
+	return Retry(ctx, s.db.Primary(), id, now)
+}
+
+// Prune deletes the Jobs that succeeded long ago.
+func (s *Postgres) Prune(ctx context.Context, before time.Time) (int64, error) {
+	return Prune(ctx, s.db.Primary(), before)
+}
@@ -0,0 +1,240 @@
+// Package lifecycle starts the components of a service in order and stops
+// them in the reverse order once the service is asked to shut down, whether
+// by a signal, by a component failing or by an integrity issue.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reason tells why a service shut down.
+type Reason int
+
+// Reasons a service shuts down for.
+const (
+	// Signal is an operator or orchestrator asking the service to stop.
+	Signal Reason = iota
+
+	// Failure is a component that stopped on its own with an error.
+	Failure
+
+	// Integrity is the service detecting it can no longer be trusted to
+	// serve, such as a handler missing values the framework always sets.
+	Integrity
+)
+
+// String implements fmt.Stringer.
+func (r Reason) String() string {
+	switch r {
+	case Signal:
+		return "signal"
+	case Failure:
+		return "failure"
+	case Integrity:
+		return "integrity"
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// ExitCode is the process exit code for a shutdown with this reason.
+func (r Reason) ExitCode() int {
+	switch r {
+	case Signal:
+		return 0
+	case Integrity:
+		return 3
+	}
+	return 1
+}
+
+// ShutdownError is returned by Manager.Run when the service did not stop
+// because it was asked to, or did not stop cleanly.
+type ShutdownError struct {
+	Reason Reason
+	Err    error
+}
+
+// Error implements error.
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown on %s: %v", e.Reason, e.Err)
+}
+
+// Cause returns the underlying error for errors.Cause.
+func (e *ShutdownError) Cause() error {
+	return e.Err
+}
+
+// ExitCode is the process exit code for err as returned by Manager.Run: 0 for
+// nil, the code of the shutdown reason for a ShutdownError and 1 otherwise.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var se *ShutdownError
+	if errors.As(err, &se) {
+		if code := se.Reason.ExitCode(); code != 0 {
+			return code
+		}
+	}
+	return 1
+}
+
+// Component is a part of the service with its own lifetime.
+type Component struct {
+	Name string
+
+	// Run blocks for as long as the component works. It is nil for
+	// components that only need cleaning up. Returning, with or without an
+	// error, before shutdown started shuts the service down.
+	Run func() error
+
+	// Stop asks the component to finish its work before ctx is done. Run, if
+	// any, must return soon after.
+	Stop func(ctx context.Context) error
+}
+
+// request is the shutdown that was asked for.
+type request struct {
+	reason Reason
+	err    error
+}
+
+// Manager runs the components of a service. Components are started in the
+// order they were added and stopped in the reverse order, so the ones added
+// first, such as databases, outlive the ones depending on them.
+type Manager struct {
+	log     *log.Logger
+	timeout time.Duration
+
+	components []Component
+
+	mu       sync.Mutex
+	req      *request
+	shutdown chan struct{}
+}
+
+// New constructs a Manager giving the components timeout to stop.
+func New(log *log.Logger, timeout time.Duration) *Manager {
+	return &Manager{
+		log:      log,
+		timeout:  timeout,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Add registers a component. It must be called before Run.
+func (m *Manager) Add(c Component) {
+	m.components = append(m.components, c)
+}
+
+// Shutdown asks the service to stop. Only the first request counts; later
+// ones are logged and dropped. It never blocks.
+func (m *Manager) Shutdown(reason Reason, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.req != nil {
+		m.log.Printf("lifecycle: already shutting down, ignoring %s: %v", reason, err)
+		return
+	}
+	m.req = &request{reason: reason, err: err}
+	close(m.shutdown)
+}
+
+// Run starts every component and blocks until the service shuts down and its
+// components are stopped. SIGINT and SIGTERM shut it down. Run returns nil
+// when a signal stopped the service and every component stopped in time.
+func (m *Manager) Run() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make([]chan struct{}, len(m.components))
+	for i, c := range m.components {
+		done[i] = make(chan struct{})
+		if c.Run == nil {
+			close(done[i])
+			continue
+		}
+
+		m.log.Printf("lifecycle: starting %s", c.Name)
+		go func(c Component, done chan struct{}) {
+			defer close(done)
+			err := c.Run()
+
+			// Components return once they are stopped.
+			select {
+			case <-m.shutdown:
+				return
+			default:
+			}
+			if err == nil {
+				err = errors.New("stopped unexpectedly")
+			}
+			m.Shutdown(Failure, errors.Wrap(err, c.Name))
+		}(c, done[i])
+	}
+
+	select {
+	case sig := <-signals:
+		m.Shutdown(Signal, errors.Errorf("received %v", sig))
+	case <-m.shutdown:
+	}
+
+	m.mu.Lock()
+	req := *m.req
+	m.mu.Unlock()
+	m.log.Printf("lifecycle: shutting down on %s: %v", req.reason, req.err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	var failed []string
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+		if err := m.stop(ctx, c, done[i]); err != nil {
+			m.log.Printf("lifecycle: stopping %s: %v", c.Name, err)
+			failed = append(failed, c.Name)
+		}
+	}
+
+	if req.reason == Signal && len(failed) == 0 {
+		m.log.Printf("lifecycle: stopped")
+		return nil
+	}
+
+	err := req.err
+	if len(failed) > 0 {
+		err = errors.Wrapf(err, "components did not stop cleanly: %v", failed)
+	}
+	return &ShutdownError{Reason: req.reason, Err: err}
+}
+
+// stop stops a single component and waits for its Run to return.
+func (m *Manager) stop(ctx context.Context, c Component, done chan struct{}) error {
+	m.log.Printf("lifecycle: stopping %s", c.Name)
+	start := time.Now()
+
+	if c.Stop != nil {
+		if err := c.Stop(ctx); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for it to return")
+	}
+
+	m.log.Printf("lifecycle: stopped %s in %v", c.Name, time.Since(start))
+	return nil
+}
@@ -0,0 +1,117 @@
+package lifecycle_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/lifecycle"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps the order components were stopped in.
+type recorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+// component returns a component blocking until it is stopped.
+func (r *recorder) component(name string) lifecycle.Component {
+	stop := make(chan struct{})
+	return lifecycle.Component{
+		Name: name,
+		Run: func() error {
+			<-stop
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			r.mu.Lock()
+			r.stopped = append(r.stopped, name)
+			r.mu.Unlock()
+			close(stop)
+			return nil
+		},
+	}
+}
+
+func newManager(timeout time.Duration) *lifecycle.Manager {
+	return lifecycle.New(log.New(ioutil.Discard, "", 0), timeout)
+}
+
+func TestIntegrity(t *testing.T) {
+	var r recorder
+	m := newManager(time.Second)
+	m.Add(r.component("database"))
+	m.Add(r.component("debug"))
+	m.Add(r.component("api"))
+
+	go m.Shutdown(lifecycle.Integrity, errors.New("values missing"))
+	err := m.Run()
+
+	if diff := cmp.Diff([]string{"api", "debug", "database"}, r.stopped); diff != "" {
+		t.Fatalf("Components stopped out of order. Diff:\n%s", diff)
+	}
+	if code := lifecycle.ExitCode(err); code != lifecycle.Integrity.ExitCode() {
+		t.Fatalf("Expected exit code %d, got %d for %v", lifecycle.Integrity.ExitCode(), code, err)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	var r recorder
+	m := newManager(time.Second)
+	m.Add(r.component("database"))
+	m.Add(lifecycle.Component{
+		Name: "api",
+		Run: func() error {
+			return errors.New("address already in use")
+		},
+	})
+
+	err := m.Run()
+
+	var se *lifecycle.ShutdownError
+	if !errors.As(err, &se) || se.Reason != lifecycle.Failure {
+		t.Fatalf("Expected a failure, got %v", err)
+	}
+	if len(r.stopped) != 1 {
+		t.Fatalf("Expected the database to be stopped, got %v", r.stopped)
+	}
+	if lifecycle.ExitCode(err) != 1 {
+		t.Fatalf("Expected exit code 1, got %d", lifecycle.ExitCode(err))
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	var r recorder
+	m := newManager(10 * time.Millisecond)
+	m.Add(r.component("database"))
+	m.Add(lifecycle.Component{
+		Name: "worker",
+		Run: func() error {
+			select {}
+		},
+	})
+
+	go m.Shutdown(lifecycle.Signal, errors.New("test"))
+	err := m.Run()
+
+	if err == nil || lifecycle.ExitCode(err) != 1 {
+		t.Fatalf("Expected a worker that never returns to fail the shutdown, got %v", err)
+	}
+	if len(r.stopped) != 1 {
+		t.Fatalf("Expected the database to be stopped anyway, got %v", r.stopped)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	m := newManager(time.Second)
+	m.Add(lifecycle.Component{Name: "noop"})
+
+	go m.Shutdown(lifecycle.Signal, errors.New("received interrupt"))
+	if err := m.Run(); err != nil {
+		t.Fatalf("Expected a clean shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Package logger adds levels to the standard logger. Lines are still written
+// with a *log.Logger; a Level only decides which of them are worth writing.
+package logger
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the importance of a log line.
+type Level int32
+
+// Levels from the most to the least verbose.
+const (
+	Debug Level = iota
+	Info
+	Error
+)
+
+var names = []string{"debug", "info", "error"}
+
+// String implements fmt.Stringer.
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "unknown"
+	}
+	return names[l]
+}
+
+// ParseLevel reads a level by name, ignoring case.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range names {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, errors.Errorf("unknown log level %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Var is a Level that can be changed while the service runs. The zero value
+// is Debug. It is safe for concurrent use and a nil *Var is Info.
+type Var struct {
+	v int32
+}
+
+// NewVar constructs a Var set to l.
+func NewVar(l Level) *Var {
+	return &Var{v: int32(l)}
+}
+
+// Level returns the current level.
+func (v *Var) Level() Level {
+	if v == nil {
+		return Info
+	}
+	return Level(atomic.LoadInt32(&v.v))
+}
+
+// Set changes the level.
+func (v *Var) Set(l Level) {
+	atomic.StoreInt32(&v.v, int32(l))
+}
+
+// Enabled tells whether lines of level l are written.
+func (v *Var) Enabled(l Level) bool {
+	return l >= v.Level()
+}
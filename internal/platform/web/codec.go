@@ -0,0 +1,312 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes response values to, and decodes request bodies from, one
+// media type.
+type Codec interface {
+	// ContentType is sent with the responses the codec encoded.
+	ContentType() string
+
+	// MediaTypes are matched against the Accept and Content-Type headers of
+	// requests.
+	MediaTypes() []string
+
+	// Encode writes v to w. It returns ErrUnsupportedValue for values the
+	// format can not represent, so the next acceptable codec is tried.
+	Encode(w io.Writer, v interface{}) error
+
+	// Decode reads r into the value v points to.
+	Decode(r io.Reader, v interface{}) error
+}
+
+// ErrUnsupportedValue is returned by a Codec asked to encode a value its
+// format can not represent.
+var ErrUnsupportedValue = errors.New("value not supported by the format")
+
+// ErrNotAcceptable is returned when none of the media types a client accepts
+// can represent the response.
+var ErrNotAcceptable = NewRequestError(errors.New("none of the accepted media types can be produced"), http.StatusNotAcceptable)
+
+// codecs are tried in order when a client accepts several media types with
+// the same preference. JSON comes first so it is the default.
+var codecs = struct {
+	sync.RWMutex
+	list []Codec
+}{
+	list: []Codec{JSON, CSV, XML, MessagePack},
+}
+
+// RegisterCodec adds c to the codecs Respond and Decode can choose from. A
+// codec registered for a media type already known replaces the former one.
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	for i, known := range codecs.list {
+		for _, mt := range c.MediaTypes() {
+			if matches(known, mt) {
+				codecs.list[i] = c
+				return
+			}
+		}
+	}
+	codecs.list = append(codecs.list, c)
+}
+
+// codecFor returns the codec decoding contentType. An empty Content-Type is
+// read as JSON for the clients written before other formats were accepted.
+func codecFor(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSON, true
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	for _, c := range codecs.list {
+		if matches(c, mt) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// acceptable lists the codecs matching the Accept header, from the most to
+// the least preferred. JSON stays the default for browsers: when the most
+// preferred ranges are HTML or wildcards and name no codec, JSON comes first
+// even if the header goes on to accept another format.
+func acceptable(accept string) []Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	ranges := parseAccept(accept)
+
+	var list []Codec
+	seen := make(map[int]bool)
+	for _, mr := range ranges {
+		for i, c := range codecs.list {
+			if !seen[i] && matches(c, mr.mediaType) {
+				seen[i] = true
+				list = append(list, c)
+			}
+		}
+	}
+
+	if browsing(ranges) {
+		for i, c := range list {
+			if c == JSON {
+				copy(list[1:i+1], list[:i])
+				list[0] = JSON
+				break
+			}
+		}
+	}
+	return list
+}
+
+// browsing tells whether the most preferred media ranges are those of a
+// browser, HTML or wildcards, rather than a codec asked for by name. The
+// caller must hold the lock of the codecs.
+func browsing(ranges []mediaRange) bool {
+	var html bool
+	for _, mr := range ranges {
+		if mr.q < ranges[0].q {
+			break
+		}
+		switch mt := strings.ToLower(mr.mediaType); {
+		case strings.Contains(mt, "*"), mt == "text/html", mt == "application/xhtml+xml":
+			html = true
+		default:
+			for _, c := range codecs.list {
+				if matches(c, mt) {
+					return false
+				}
+			}
+		}
+	}
+	return html
+}
+
+// mediaRange is a media type or range of an Accept header and how much the
+// client prefers it.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// mediaRanges returns the media ranges of an Accept header from the most to
+// the least preferred, leaving out those the client refuses. Without the
+// header anything is acceptable.
+func mediaRanges(accept string) []string {
+	ranges := parseAccept(accept)
+	list := make([]string, len(ranges))
+	for i, mr := range ranges {
+		list[i] = mr.mediaType
+	}
+	return list
+}
+
+// parseAccept is mediaRanges keeping the preference of every range.
+func parseAccept(accept string) []mediaRange {
+	if strings.TrimSpace(accept) == "" {
+		return []mediaRange{{"*/*", 1}}
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{mt, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// matches tells whether the media type or range mt covers one of the media
+// types of c.
+func matches(c Codec, mt string) bool {
+	for _, own := range c.MediaTypes() {
+		switch {
+		case mt == "*/*", strings.EqualFold(mt, own):
+			return true
+		case strings.HasSuffix(mt, "/*"):
+			if strings.HasPrefix(own, strings.TrimSuffix(mt, "*")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encode encodes v with the first of the codecs able to represent it.
+func encode(list []Codec, v interface{}) (Codec, []byte, error) {
+	for _, c := range list {
+		var buf bytes.Buffer
+		err := c.Encode(&buf, v)
+		if err == ErrUnsupportedValue {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return c, buf.Bytes(), nil
+	}
+	return nil, nil, ErrNotAcceptable
+}
+
+// The codecs of the formats supported out of the box.
+var (
+	JSON        Codec = jsonCodec{}
+	XML         Codec = xmlCodec{}
+	MessagePack Codec = msgpackCodec{}
+)
+
+// jsonCodec reads and writes JSON. Unknown fields are rejected.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string  { return "application/json; charset=utf-8" }
+func (jsonCodec) MediaTypes() []string { return []string{"application/json"} }
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// xmlCodec reads and writes XML with the element names of encoding/xml.
+// Slices are wrapped in a list element so the document has a single root.
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string  { return "application/xml; charset=utf-8" }
+func (xmlCodec) MediaTypes() []string { return []string{"application/xml", "text/xml"} }
+
+func (xmlCodec) Encode(w io.Writer, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() == reflect.Map {
+		return ErrUnsupportedValue
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	if rv.Kind() == reflect.Slice {
+		v = struct {
+			XMLName xml.Name    `xml:"list"`
+			Items   interface{} `xml:"item"`
+		}{Items: v}
+	}
+
+	err := xml.NewEncoder(w).Encode(v)
+	if _, ok := err.(*xml.UnsupportedTypeError); ok {
+		return ErrUnsupportedValue
+	}
+	return err
+}
+
+func (xmlCodec) Decode(r io.Reader, v interface{}) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// msgpackCodec reads and writes MessagePack. Fields are named after their
+// json tags so both formats carry the same keys.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+func (msgpackCodec) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack"}
+}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(v)
+}
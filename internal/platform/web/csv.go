@@ -0,0 +1,283 @@
+package web
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV reads and writes structs, and slices of them, as CSV with a header
+// row. Columns are named after the db tag of each field, or its json tag
+// when it has none. Only flat structs, whose fields are numbers, strings,
+// booleans or times, are supported.
+var CSV Codec = csvCodec{}
+
+type csvCodec struct{}
+
+func (csvCodec) ContentType() string  { return "text/csv; charset=utf-8" }
+func (csvCodec) MediaTypes() []string { return []string{"text/csv"} }
+
+// csvColumn is a field of a struct written to CSV.
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvColumns returns the columns of the struct type t. It fails when t has
+// fields that do not fit in a cell, as the rows would lose them.
+func csvColumns(t reflect.Type) ([]csvColumn, bool) {
+	var columns []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.SplitN(f.Tag.Get("db"), ",", 2)[0]
+		if name == "" {
+			name = strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		}
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+		if !csvScalar(f.Type) {
+			return nil, false
+		}
+		columns = append(columns, csvColumn{name: name, index: f.Index})
+	}
+	return columns, len(columns) > 0
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// csvScalar tells whether values of t fit in a single cell.
+func csvScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType || t.Implements(textMarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// csvRows returns the struct values of v and their type, which is a struct or
+// a slice of structs.
+func csvRows(v interface{}) (reflect.Type, []reflect.Value, bool) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	switch {
+	case rv.Kind() == reflect.Struct:
+		return rv.Type(), []reflect.Value{rv}, true
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Struct:
+		rows := make([]reflect.Value, rv.Len())
+		for i := range rows {
+			rows[i] = rv.Index(i)
+		}
+		return rv.Type().Elem(), rows, true
+	}
+	return nil, nil, false
+}
+
+func (csvCodec) Encode(w io.Writer, v interface{}) error {
+	t, rows, ok := csvRows(v)
+	if !ok {
+		return ErrUnsupportedValue
+	}
+	columns, ok := csvColumns(t)
+	if !ok {
+		return ErrUnsupportedValue
+	}
+
+	cw := csv.NewWriter(w)
+
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = c.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, c := range columns {
+			record[i] = formatCell(row.FieldByIndex(c.index))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatCell writes a scalar value as text. Nil pointers are empty cells.
+func formatCell(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+
+	switch val := v.Interface().(type) {
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case encoding.TextMarshaler:
+		text, err := val.MarshalText()
+		if err != nil {
+			return ""
+		}
+		return string(text)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Decode reads a header row and the records that follow. A struct takes a
+// single record and a slice takes any number of them.
+func (csvCodec) Decode(r io.Reader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("csv: decoding needs a non-nil pointer")
+	}
+	rv = rv.Elem()
+
+	var t reflect.Type
+	switch {
+	case rv.Kind() == reflect.Struct:
+		t = rv.Type()
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Struct:
+		t = rv.Type().Elem()
+	default:
+		return errors.Errorf("csv: can not decode into %s", rv.Type())
+	}
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return errors.Wrap(err, "csv: reading header")
+	}
+
+	known, ok := csvColumns(t)
+	if !ok {
+		return errors.Errorf("csv: can not decode into %s", t)
+	}
+	byName := make(map[string]csvColumn)
+	for _, c := range known {
+		byName[strings.ToLower(c.name)] = c
+	}
+	columns := make([]csvColumn, len(header))
+	for i, name := range header {
+		c, ok := byName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return errors.Errorf("csv: unknown column %q", name)
+		}
+		columns[i] = c
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "csv")
+		}
+
+		row := reflect.New(t).Elem()
+		for i, cell := range record {
+			if err := parseCell(row.FieldByIndex(columns[i].index), cell); err != nil {
+				return errors.Wrapf(err, "csv: line %d column %s", line, columns[i].name)
+			}
+		}
+
+		if rv.Kind() == reflect.Struct {
+			if _, err := cr.Read(); err != io.EOF {
+				return errors.New("csv: expected a single record")
+			}
+			rv.Set(row)
+			return nil
+		}
+		rv.Set(reflect.Append(rv, row))
+	}
+
+	if rv.Kind() == reflect.Struct {
+		return errors.New("csv: expected a record after the header")
+	}
+	return nil
+}
+
+// parseCell sets the scalar v from its text. An empty cell leaves pointers
+// nil.
+func parseCell(v reflect.Value, cell string) error {
+	if v.Kind() == reflect.Ptr {
+		if cell == "" {
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		t, err := time.Parse(time.RFC3339Nano, cell)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(cell))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(cell)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(cell, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(cell, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(cell, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	}
+	return nil
+}
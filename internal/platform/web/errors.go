@@ -11,8 +11,9 @@ type FieldError struct {
 // ErrorResponse how we respond to clients when something goes wrong
 // ErrorResponse is the form used for API responses from failures in the API.
 type ErrorResponse struct {
-	Error  string       `json:"error"`
-	Fields []FieldError `json:"fields,omitempty"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
 }
 
 // Error is used to pass an error during the request through the
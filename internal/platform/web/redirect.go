@@ -0,0 +1,31 @@
+package web
+
+import (
+	"net"
+	"net/http"
+)
+
+// RedirectHTTPS constructs a handler sending every request to the same URL
+// over HTTPS on the port of httpsAddr. Only GET and HEAD are redirected
+// permanently; other methods get a 308 so clients do not turn them into a
+// GET.
+func RedirectHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
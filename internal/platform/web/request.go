@@ -1,7 +1,6 @@
 package web
 
 import (
-	"encoding/json"
 	"github.com/pkg/errors"
 	"net/http"
 	"reflect"
@@ -41,17 +40,37 @@ func init() {
 	})
 }
 
-// Decode reads the body of an HTTP request looking for a JSON document. The
-// body is decoded into the provided value.
+// ErrBodyTooLarge is returned when reading a request body larger than the
+// route allows.
+var ErrBodyTooLarge = NewRequestError(errors.New("request body too large"), http.StatusRequestEntityTooLarge)
+
+// ErrUnsupportedMediaType is returned when a request body is in a format no
+// codec reads.
+var ErrUnsupportedMediaType = NewRequestError(errors.New("unsupported content type"), http.StatusUnsupportedMediaType)
+
+// Decode reads the body of an HTTP request with the codec of its
+// Content-Type, JSON when it has none. The body is decoded into the provided
+// value.
 //
 // If the provided value is a struct then it is checked for validation tags.
 func Decode(r *http.Request, val interface{}) error {
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(val); err != nil {
+	codec, ok := codecFor(r.Header.Get("Content-Type"))
+	if !ok {
+		return ErrUnsupportedMediaType
+	}
+	if err := codec.Decode(r.Body, val); err != nil {
+		if errors.Cause(err) == ErrBodyTooLarge {
+			return ErrBodyTooLarge
+		}
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
+	return Validate(val)
+}
+
+// Validate checks a struct value against its validation tags. A failure is
+// returned as an *Error listing every invalid field.
+func Validate(val interface{}) error {
 	if err := validate.Struct(val); err != nil {
 		// Use a type assertion to get the real error value.
 		verrors, ok := err.(validator.ValidationErrors)
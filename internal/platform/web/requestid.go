@@ -0,0 +1,44 @@
+package web
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID between clients, the API and the
+// services it calls.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns the ID of the request being served with ctx, or an empty
+// string outside of a request.
+func RequestID(ctx context.Context) string {
+	if v, ok := ctx.Value(KeyValues).(*Values); ok {
+		return v.RequestID
+	}
+	return ""
+}
+
+// Transport sends the ID of the request being served along with outbound
+// requests made with its context, so the logs of both sides can be matched.
+type Transport struct {
+	// Base sends the requests. http.DefaultTransport is used when nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	id := RequestID(req.Context())
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request it was given.
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return base.RoundTrip(req)
+}
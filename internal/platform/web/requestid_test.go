@@ -0,0 +1,57 @@
+package web_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestTransport checks that outbound requests carry the ID of the request
+// being served, unless they set one of their own.
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(web.RequestIDHeader)
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &web.Transport{}}
+	served := context.WithValue(context.Background(), web.KeyValues, &web.Values{RequestID: "9f3c2a7e"})
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		header string
+		want   string
+	}{
+		{"Served", served, "", "9f3c2a7e"},
+		{"Outside", context.Background(), "", ""},
+		{"Explicit", served, "4b1d", "4b1d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(tt.ctx, http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set(web.RequestIDHeader, tt.header)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Sending: %v", err)
+			}
+			resp.Body.Close()
+
+			if got != tt.want {
+				t.Fatalf("Expected request ID %q, got %q", tt.want, got)
+			}
+			if tt.header == "" && req.Header.Get(web.RequestIDHeader) != "" {
+				t.Fatal("Expected the request not to be modified")
+			}
+		})
+	}
+}
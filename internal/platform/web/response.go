@@ -2,30 +2,50 @@ package web
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/pkg/errors"
 	"net/http"
 )
 
-// Respond returns the client provided data
+// StatusClientClosedRequest is recorded for requests the client canceled
+// before they were answered. Nothing is sent with it.
+const StatusClientClosedRequest = 499
+
+// Respond returns the client provided data encoded in the preferred format
+// the client accepts. ErrNotAcceptable is returned, and nothing is written,
+// when no accepted format can represent value.
 func Respond(ctx context.Context, w http.ResponseWriter, value interface{}, statusCode int) error {
+	return respond(ctx, w, value, statusCode, false)
+}
+
+// respond implements Respond. With fallback a value no accepted format can
+// represent is sent as JSON, so errors always reach the client.
+func respond(ctx context.Context, w http.ResponseWriter, value interface{}, statusCode int, fallback bool) error {
 	v, ok := ctx.Value(KeyValues).(*Values)
 	if !ok {
 		return NewShutdownError("Web values missing from context")
 	}
-	v.StatusCode = statusCode
-
-	w.Header().Set("content-type", "application/json; charset=urf8")
-	w.WriteHeader(statusCode)
 
 	if statusCode == http.StatusNoContent {
+		v.StatusCode = statusCode
+		w.WriteHeader(statusCode)
 		return nil
 	}
 
-	data, err := json.Marshal(value)
+	codec, data, err := encode(acceptable(v.accept), value)
+	if err == ErrNotAcceptable && fallback {
+		codec, data, err = encode([]Codec{JSON}, value)
+	}
 	if err != nil {
+		if err == ErrNotAcceptable {
+			return err
+		}
 		return errors.Wrap(err, "Could not marshal the value")
 	}
+	v.StatusCode = statusCode
+
+	w.Header().Set("Content-Type", codec.ContentType())
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(statusCode)
 
 	if _, err := w.Write(data); err != nil {
 		return errors.Wrap(err, "Could not write to the client")
@@ -35,15 +55,26 @@ func Respond(ctx context.Context, w http.ResponseWriter, value interface{}, stat
 }
 
 // RespondError sends an error response back to the client.
+//
+// Nothing is sent when the response already started, such as a stream that
+// failed halfway; the status can no longer change.
 func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
+	if v, ok := ctx.Value(KeyValues).(*Values); ok && v.StatusCode != 0 {
+		return nil
+	}
+
+	// Clients quote the request ID when they report a failure.
+	requestID := RequestID(ctx)
+
 	// If the error was of the type *Error, the handler has
 	// a specific status code and error to return.
 	if webErr, ok := errors.Cause(err).(*Error); ok {
 		er := ErrorResponse{
-			Error:  webErr.Err.Error(),
-			Fields: webErr.Fields,
+			Error:     webErr.Err.Error(),
+			Fields:    webErr.Fields,
+			RequestID: requestID,
 		}
-		if err := Respond(ctx, w, er, webErr.Status); err != nil {
+		if err := respond(ctx, w, er, webErr.Status, true); err != nil {
 			return err
 		}
 		return nil
@@ -51,9 +82,10 @@ func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 
 	// If not, the handler sent any arbitrary error value so use 500
 	er := ErrorResponse{
-		Error: http.StatusText(http.StatusInternalServerError),
+		Error:     http.StatusText(http.StatusInternalServerError),
+		RequestID: requestID,
 	}
-	if err := Respond(ctx, w, er, http.StatusInternalServerError); err != nil {
+	if err := respond(ctx, w, er, http.StatusInternalServerError, true); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// NDJSONContentType is sent with streams of newline delimited JSON.
+const NDJSONContentType = "application/x-ndjson"
+
+// Rows is a cursor over the rows of a query. *sqlx.Rows implements it.
+type Rows interface {
+	Next() bool
+	StructScan(dest interface{}) error
+	Err() error
+	Close() error
+}
+
+// StreamRows writes every row of rows as soon as it is read, so the response
+// takes constant memory however many rows there are. Each row is scanned into
+// dest, which is reused. Rows are sent as NDJSON when the client prefers it
+// and as a JSON array otherwise; ErrNotAcceptable is returned, before
+// anything is written, when the client accepts neither.
+//
+// Once the first byte is sent the status can no longer change, so a failure
+// past that point only cuts the stream short. rows is closed in any case.
+func StreamRows(ctx context.Context, w http.ResponseWriter, rows Rows, dest interface{}, statusCode int) error {
+	defer rows.Close()
+
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return NewShutdownError("Web values missing from context")
+	}
+
+	ndjson, ok := streamFormat(v.accept)
+	if !ok {
+		return ErrNotAcceptable
+	}
+	v.StatusCode = statusCode
+
+	contentType := JSON.ContentType()
+	if ndjson {
+		contentType = NDJSONContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(statusCode)
+
+	// Flushing after every row would defeat the buffering of the connection
+	// and of compression, so rows are flushed in batches.
+	const flushEvery = 100
+	flusher, _ := w.(http.Flusher)
+
+	enc := json.NewEncoder(w)
+	sep := []byte("[")
+	n := 0
+	for rows.Next() {
+		if err := rows.StructScan(dest); err != nil {
+			return errors.Wrap(err, "scanning row")
+		}
+		if !ndjson {
+			if _, err := w.Write(sep); err != nil {
+				return errors.Wrap(err, "Could not write to the client")
+			}
+			sep = []byte(",")
+		}
+
+		// The encoder ends every value with a newline, which is what NDJSON
+		// needs and is harmless in an array.
+		if err := enc.Encode(dest); err != nil {
+			return errors.Wrap(err, "Could not write to the client")
+		}
+
+		if n++; flusher != nil && n%flushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "reading rows")
+	}
+
+	if !ndjson {
+		end := "]"
+		if n == 0 {
+			end = "[]"
+		}
+		if _, err := w.Write([]byte(end)); err != nil {
+			return errors.Wrap(err, "Could not write to the client")
+		}
+	}
+	return nil
+}
+
+// streamFormat tells whether the client prefers NDJSON over a JSON array.
+// It fails when the client accepts neither.
+func streamFormat(accept string) (ndjson bool, ok bool) {
+	for _, mt := range mediaRanges(accept) {
+		switch {
+		case strings.EqualFold(mt, NDJSONContentType):
+			return true, true
+		case matches(JSON, mt):
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// SliceRows returns Rows over the elements of a slice, for stores that keep
+// their data in memory.
+func SliceRows(slice interface{}) Rows {
+	return &sliceRows{v: reflect.ValueOf(slice), i: -1}
+}
+
+type sliceRows struct {
+	v reflect.Value
+	i int
+}
+
+func (r *sliceRows) Next() bool {
+	r.i++
+	return r.i < r.v.Len()
+}
+
+func (r *sliceRows) StructScan(dest interface{}) error {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.Elem().Type() != r.v.Type().Elem() {
+		return errors.Errorf("can not scan %s into %T", r.v.Type().Elem(), dest)
+	}
+	d.Elem().Set(r.v.Index(r.i))
+	return nil
+}
+
+func (r *sliceRows) Err() error   { return nil }
+func (r *sliceRows) Close() error { return nil }
@@ -5,8 +5,6 @@ import (
 	"github.com/go-chi/chi"
 	"log"
 	"net/http"
-	"os"
-	"syscall"
 	"time"
 
 	"go.opencensus.io/trace"
@@ -25,10 +23,22 @@ type ctxKey int
 // KeyValues is how represent values or stored/retrieved.
 const KeyValues ctxKey = 1
 
+// keyController holds the controller of the response as the server passed
+// it, before tracing wrapped it.
+const keyController ctxKey = 2
+
 type Values struct {
 	Start      time.Time
 	StatusCode int
 	TraceID    string
+
+	// RequestID correlates the logs of a request with what the client saw,
+	// even when its trace was not sampled. It is set by the RequestID
+	// middleware.
+	RequestID string
+
+	// accept is the Accept header Respond negotiates the format with.
+	accept string
 }
 
 // ************************************************************
@@ -43,12 +53,13 @@ type App struct {
 	log      *log.Logger
 	mw       []Middleware
 	och      *ochttp.Handler // Distributed tracing
-	shutdown chan os.Signal  // Shutdown signal on integrity
+	shutdown func(error)     // Shutdown request on integrity issues
 }
 
 // NewApp constructs an App to handle a set of routes. Any Middleware provided
-// will be ran for every request.
-func NewApp(shutdown chan os.Signal, logger *log.Logger, mw ...Middleware) *App {
+// will be ran for every request. shutdown is called with the error of any
+// handler that found an integrity issue.
+func NewApp(shutdown func(error), logger *log.Logger, mw ...Middleware) *App {
 	app := App{
 		mux:      chi.NewRouter(),
 		log:      logger,
@@ -93,15 +104,16 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 		v := Values{
 			Start:   time.Now(),
 			TraceID: span.SpanContext().TraceID.String(),
+			accept:  r.Header.Get("Accept"),
 		}
 
 		// Attaching status code into the context
 		ctx = context.WithValue(ctx, KeyValues, &v)
 
 		if err := h(ctx, w, r); err != nil {
-			a.log.Printf("[%s] Unhandled Errors: %+v", v.TraceID, err)
+			a.log.Printf("[%s] Unhandled Errors: %+v", v.RequestID, err)
 			if IsShutdown(err) {
-				a.SignalShutdown()
+				a.SignalShutdown(err)
 			}
 		}
 	}
@@ -111,12 +123,24 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 
 // ServeHttp handles http service
 func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	a.och.ServeHTTP(w, r)
+	ctx := context.WithValue(r.Context(), keyController, http.NewResponseController(w))
+	a.och.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// SetWriteDeadline moves the write deadline of the response to the request
+// of ctx, for handlers streaming for longer than the server WriteTimeout. It
+// returns http.ErrNotSupported when the response has no deadline to move.
+func SetWriteDeadline(ctx context.Context, deadline time.Time) error {
+	rc, ok := ctx.Value(keyController).(*http.ResponseController)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return rc.SetWriteDeadline(deadline)
 }
 
 // SignalShutdown is used to gracefully shut down the application when an integrity
 // issue is identified.
-func (a *App) SignalShutdown() {
+func (a *App) SignalShutdown(err error) {
 	a.log.Println("error returned from handler indicated integrity issue, shutting down service")
-	a.shutdown <- syscall.SIGSTOP
+	a.shutdown(err)
 }
@@ -0,0 +1,132 @@
+package webtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/google/go-cmp/cmp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// update rewrites golden files with the responses received instead of
+// comparing them.
+var update = flag.Bool("update", false, "update golden files")
+
+// Response is the answer of the application to a Request. Every check fails
+// the test at once and returns the Response so checks can be chained.
+type Response struct {
+	*httptest.ResponseRecorder
+	t   *testing.T
+	req *http.Request
+}
+
+// Status checks the status code.
+func (r *Response) Status(want int) *Response {
+	r.t.Helper()
+
+	if r.Code != want {
+		r.t.Fatalf("%s %s: expected status %d, got %d: %s", r.req.Method, r.req.URL, want, r.Code, r.Body)
+	}
+	return r
+}
+
+// Header checks the value of a response header.
+func (r *Response) Header(key, want string) *Response {
+	r.t.Helper()
+
+	if got := r.Result().Header.Get(key); got != want {
+		r.t.Fatalf("%s %s: expected header %s %q, got %q", r.req.Method, r.req.URL, key, want, got)
+	}
+	return r
+}
+
+// Decode unmarshals the JSON body into v.
+func (r *Response) Decode(v interface{}) *Response {
+	r.t.Helper()
+
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		r.t.Fatalf("%s %s: decoding response %q: %v", r.req.Method, r.req.URL, r.Body, err)
+	}
+	return r
+}
+
+// Equal decodes the JSON body into a new value of the type of want and
+// compares them.
+func (r *Response) Equal(want interface{}, opts ...cmp.Option) *Response {
+	r.t.Helper()
+
+	got := reflect.New(reflect.TypeOf(want))
+	r.Decode(got.Interface())
+
+	if diff := cmp.Diff(want, got.Elem().Interface(), opts...); diff != "" {
+		r.t.Fatalf("%s %s: response did not match expected. Diff:\n%s", r.req.Method, r.req.URL, diff)
+	}
+	return r
+}
+
+// Golden compares the JSON body with testdata/<test name>.golden. Values of
+// the listed keys, such as generated IDs and timestamps, are masked at any
+// depth. Run the tests with -update to write the golden files.
+func (r *Response) Golden(masked ...string) *Response {
+	r.t.Helper()
+
+	var body interface{}
+	r.Decode(&body)
+	body = mask(body, masked)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(body); err != nil {
+		r.t.Fatalf("Marshaling response: %v", err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", filepath.FromSlash(r.t.Name())+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			r.t.Fatalf("Creating golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			r.t.Fatalf("Writing golden file: %v", err)
+		}
+		return r
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		r.t.Fatalf("Reading golden file, run with -update to create it: %v", err)
+	}
+
+	if diff := cmp.Diff(string(want), string(got)); diff != "" {
+		r.t.Fatalf("%s %s: response did not match %s. Diff:\n%s", r.req.Method, r.req.URL, path, diff)
+	}
+	return r
+}
+
+// mask replaces the values of the masked keys of every object in v.
+func mask(v interface{}, masked []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = mask(value, masked)
+			for _, m := range masked {
+				if strings.EqualFold(key, m) {
+					v[key] = "<masked>"
+				}
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = mask(v[i], masked)
+		}
+	}
+	return v
+}
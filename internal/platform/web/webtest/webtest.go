@@ -0,0 +1,309 @@
+// Package webtest drives the API end to end in tests. It builds the
+// application with in-memory stores or a fresh test database, signs tokens
+// with a throwaway key, logs in as seeded principals and offers fluent
+// helpers to send requests and check the responses.
+//
+//	app := webtest.New(t, build)
+//	app.Get(t, "/v1/api/products").As(webtest.Admin).Do().
+//		Status(http.StatusOK).
+//		Golden("id", "created_at", "updated_at")
+package webtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Principal is a user seeded into every App.
+type Principal struct {
+	Name     string
+	Email    string
+	Password string
+	Roles    []string
+}
+
+// The principals seeded into every App.
+var (
+	Admin = Principal{
+		Name:     "Admin Gopher",
+		Email:    "admin@example.com",
+		Password: "gophers",
+		Roles:    []string{auth.RoleAdmin, auth.RoleUser},
+	}
+	User = Principal{
+		Name:     "User Gopher",
+		Email:    "user@example.com",
+		Password: "gophers",
+		Roles:    []string{auth.RoleUser},
+	}
+)
+
+// Deps are the dependencies a Builder wires into the application.
+type Deps struct {
+	Shutdown      func(error)
+	Log           *log.Logger
+	DB            *database.Cluster // nil with in-memory stores
+	Authenticator *auth.Authenticator
+	Products      product.ProductStore
+	Sales         product.SaleStore
+	Users         user.UserStore
+	Events        events.Feed
+	Webhooks      webhook.Store
+	Jobs          jobs.Store
+}
+
+// Builder constructs the application under test, typically by calling
+// handlers.API with the provided dependencies.
+type Builder func(deps Deps) http.Handler
+
+// App is an application under test.
+type App struct {
+	Deps
+	Handler http.Handler
+
+	// TokenPath is the route exchanging basic auth credentials for a token.
+	TokenPath string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// New builds an App backed by in-memory stores.
+func New(t *testing.T, build Builder) *App {
+	t.Helper()
+
+	products := product.NewMemory()
+	return newApp(t, build, Deps{
+		Products: products,
+		Sales:    products,
+		Users:    user.NewMemory(),
+		Events:   products.Events(),
+		Webhooks: webhook.NewMemory(),
+		Jobs:     jobs.NewMemory(),
+	})
+}
+
+// NewWithDatabase builds an App backed by a fresh, migrated database. The test
+// is skipped when no database server is available. Its events feed replays
+// events but nothing listens for new ones.
+func NewWithDatabase(t *testing.T, build Builder) *App {
+	t.Helper()
+
+	db := database.NewCluster(databasetest.Setup(t))
+	products := product.NewPostgres(db)
+	return newApp(t, build, Deps{
+		DB:       db,
+		Products: products,
+		Sales:    products,
+		Users:    user.NewPostgres(db),
+		Events:   events.NewPostgres(db),
+		Webhooks: webhook.NewPostgres(db),
+		Jobs:     jobs.NewPostgres(db),
+	})
+}
+
+// newApp completes deps with a throwaway signing key and a logger writing to
+// the test log, seeds the principals and builds the application.
+func newApp(t *testing.T, build Builder, deps Deps) *App {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Generating signing key: %v", err)
+	}
+
+	const kid = "webtest"
+	deps.Authenticator, err = auth.NewAuthenticator(key, kid, "RS256", auth.NewSimpleKeyLookup(kid, &key.PublicKey))
+	if err != nil {
+		t.Fatalf("Creating authenticator: %v", err)
+	}
+	deps.Shutdown = func(err error) {
+		t.Errorf("Integrity shutdown requested: %v", err)
+	}
+	deps.Log = log.New(testWriter{t}, "", log.Lshortfile)
+
+	for _, p := range []Principal{Admin, User} {
+		nu := user.NewUser{
+			Name:            p.Name,
+			Email:           p.Email,
+			Roles:           p.Roles,
+			Password:        p.Password,
+			ConfirmPassword: p.Password,
+		}
+		if _, err := deps.Users.Create(context.Background(), nu, time.Now()); err != nil {
+			t.Fatalf("Seeding %s: %v", p.Email, err)
+		}
+	}
+
+	return &App{
+		Deps:      deps,
+		Handler:   build(deps),
+		TokenPath: "/v1/api/users",
+		tokens:    make(map[string]string),
+	}
+}
+
+// Claims returns the claims of a seeded principal, for example to seed data
+// owned by them straight into the stores.
+func (a *App) Claims(t *testing.T, p Principal) auth.Claims {
+	t.Helper()
+
+	claims, err := a.Users.Authenticate(context.Background(), time.Now(), p.Email, p.Password)
+	if err != nil {
+		t.Fatalf("Authenticating %s: %v", p.Email, err)
+	}
+	return claims
+}
+
+// Token logs in as p through the token route and returns the bearer token.
+// Tokens are cached for the life of the App.
+func (a *App) Token(t *testing.T, p Principal) string {
+	t.Helper()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if tkn, ok := a.tokens[p.Email]; ok {
+		return tkn
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	a.Get(t, a.TokenPath).BasicAuth(p.Email, p.Password).Do().
+		Status(http.StatusOK).
+		Decode(&resp)
+
+	a.tokens[p.Email] = resp.Token
+	return resp.Token
+}
+
+// Request starts a request to the App.
+func (a *App) Request(t *testing.T, method, target string) *Request {
+	return &Request{
+		t:   t,
+		app: a,
+		req: httptest.NewRequest(method, target, nil),
+	}
+}
+
+// Get starts a GET request.
+func (a *App) Get(t *testing.T, target string) *Request {
+	return a.Request(t, http.MethodGet, target)
+}
+
+// Post starts a POST request carrying body as JSON.
+func (a *App) Post(t *testing.T, target string, body interface{}) *Request {
+	return a.Request(t, http.MethodPost, target).JSON(body)
+}
+
+// Put starts a PUT request carrying body as JSON.
+func (a *App) Put(t *testing.T, target string, body interface{}) *Request {
+	return a.Request(t, http.MethodPut, target).JSON(body)
+}
+
+// Delete starts a DELETE request.
+func (a *App) Delete(t *testing.T, target string) *Request {
+	return a.Request(t, http.MethodDelete, target)
+}
+
+// testWriter sends the application log to the test log so it is only shown
+// for failing tests or with -v.
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// Request is a request being built.
+type Request struct {
+	t   *testing.T
+	app *App
+	req *http.Request
+	as  *Principal
+}
+
+// As sends the request with a token of p.
+func (r *Request) As(p Principal) *Request {
+	r.as = &p
+	return r
+}
+
+// BasicAuth sends the request with basic auth credentials.
+func (r *Request) BasicAuth(email, password string) *Request {
+	r.req.SetBasicAuth(email, password)
+	return r
+}
+
+// Header sets a request header.
+func (r *Request) Header(key, value string) *Request {
+	r.req.Header.Set(key, value)
+	return r
+}
+
+// JSON sets the body of the request. Strings and byte slices are sent as they
+// are, anything else is marshaled.
+func (r *Request) JSON(body interface{}) *Request {
+	r.t.Helper()
+
+	var data []byte
+	switch body := body.(type) {
+	case nil:
+		return r
+	case string:
+		data = []byte(body)
+	case []byte:
+		data = body
+	default:
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			r.t.Fatalf("Marshaling request body: %v", err)
+		}
+	}
+
+	return r.Body("application/json", bytes.NewReader(data))
+}
+
+// Body sets a raw body of the given content type.
+func (r *Request) Body(contentType string, body io.Reader) *Request {
+	r.req.Body = io.NopCloser(body)
+	r.req.ContentLength = -1
+	r.req.Header.Set("Content-Type", contentType)
+	return r
+}
+
+// Do sends the request to the application.
+func (r *Request) Do() *Response {
+	r.t.Helper()
+
+	if r.as != nil {
+		r.req.Header.Set("Authorization", "Bearer "+r.app.Token(r.t, *r.as))
+	}
+
+	rec := httptest.NewRecorder()
+	r.app.Handler.ServeHTTP(rec, r.req)
+
+	return &Response{ResponseRecorder: rec, t: r.t, req: r.req}
+}
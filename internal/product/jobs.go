@@ -0,0 +1,55 @@
+package product
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// JobLowStock is the kind of the job recording the Products running low.
+const JobLowStock = "product.low_stock"
+
+// RegisterJobs registers the jobs of the package: every quarter of an hour the
+// Products with threshold units or fewer left are recorded as running low.
+func RegisterJobs(r *jobs.Registry, db *database.Cluster, threshold int) error {
+	return r.Register(jobs.Definition{
+		Kind:     JobLowStock,
+		Schedule: "*/15 * * * *",
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			return db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+				_, err := LowStock(ctx, tx, threshold, time.Now())
+				return err
+			})
+		},
+	})
+}
+
+// LowStock records EventProductLowStock for the Products with threshold units
+// or fewer left, and returns them. Sold out Products are left out as they
+// have their own event. A Product is recorded once, then again only after it
+// was updated, say restocked, or its earlier event was pruned.
+func LowStock(ctx context.Context, db database.Queryer, threshold int, now time.Time) ([]Product, error) {
+	const q = `SELECT p.product_id, p.name, p.cost, p.quantity, COALESCE(p.user_id::TEXT, '') AS user_id,
+COALESCE(SUM(s.quantity), 0) AS sold, COALESCE(SUM(s.paid), 0) AS revenue, p.created_at, p.updated_at
+FROM products AS p LEFT JOIN sales AS s ON s.product_id = p.product_id
+WHERE NOT EXISTS (
+	SELECT 1 FROM events AS e WHERE e.subject = p.product_id::TEXT AND e.type = $2 AND e.created_at >= p.updated_at
+)
+GROUP BY p.product_id
+HAVING p.quantity - COALESCE(SUM(s.quantity), 0) BETWEEN 1 AND $1
+ORDER BY p.product_id;`
+
+	list := []Product{}
+	if err := db.SelectContext(ctx, &list, q, threshold, EventProductLowStock); err != nil {
+		return nil, errors.Wrap(err, "selecting products low on stock")
+	}
+
+	for _, p := range list {
+		if err := record(ctx, db, EventProductLowStock, p.ID, p, now); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,270 @@
+package product
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements ProductStore and SaleStore in memory. It is safe for
+// concurrent use and is meant for tests that should not need a database.
+// Changes are recorded in its own events feed.
+type Memory struct {
+	mu       sync.RWMutex
+	products map[string]Product
+	sales    map[string][]Sale
+	events   *events.Memory
+}
+
+// NewMemory constructs an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		products: make(map[string]Product),
+		sales:    make(map[string][]Sale),
+		events:   events.NewMemory(),
+	}
+}
+
+// Events returns the feed of the changes made to the store.
+func (m *Memory) Events() *events.Memory {
+	return m.events
+}
+
+// List returns all the Products ordered by creation time.
+func (m *Memory) List(ctx context.Context) ([]Product, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]Product, 0, len(m.products))
+	for id := range m.products {
+		list = append(list, m.aggregate(id))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+// ListRows returns a cursor over a copy of all the Products.
+func (m *Memory) ListRows(ctx context.Context) (web.Rows, error) {
+	list, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return web.SliceRows(list), nil
+}
+
+// Retrieve returns a single Product.
+func (m *Memory) Retrieve(ctx context.Context, id string) (*Product, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidUUID
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.products[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	p := m.aggregate(id)
+	return &p, nil
+}
+
+// Create makes a new Product owned by user.
+func (m *Memory) Create(ctx context.Context, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
+	p := Product{
+		ID:        uuid.New().String(),
+		Name:      np.Name,
+		Cost:      np.Cost,
+		Quantity:  np.Quantity,
+		UserID:    user.Subject,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.products[p.ID] = p
+	if _, err := m.events.Append(EventProductCreated, p.ID, p, now); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// CreateMany makes several Products owned by user at once.
+func (m *Memory) CreateMany(ctx context.Context, user auth.Claims, nps []NewProduct, now time.Time) ([]Product, error) {
+	list := make([]Product, 0, len(nps))
+	for _, np := range nps {
+		list = append(list, Product{
+			ID:        uuid.New().String(),
+			Name:      np.Name,
+			Cost:      np.Cost,
+			Quantity:  np.Quantity,
+			UserID:    user.Subject,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range list {
+		m.products[p.ID] = p
+		if _, err := m.events.Append(EventProductCreated, p.ID, p, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// Update modifies an existing Product. Only admins and the owner of the
+// Product may change it.
+func (m *Memory) Update(ctx context.Context, user auth.Claims, id string, update UpdateProduct,
+	now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidUUID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	if !user.HasRole(auth.RoleAdmin) && p.UserID != user.Subject {
+		return ErrForbidden
+	}
+
+	if update.Name != nil {
+		p.Name = *update.Name
+	}
+	if update.Cost != nil {
+		p.Cost = *update.Cost
+	}
+	if update.Quantity != nil {
+		p.Quantity = *update.Quantity
+	}
+	p.UpdatedAt = now
+
+	m.products[id] = p
+	if _, err := m.events.Append(EventProductUpdated, id, p, now); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes a Product and its Sales. Deleting a missing Product is not
+// an error.
+func (m *Memory) Delete(ctx context.Context, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidUUID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[id]; !ok {
+		return nil
+	}
+	delete(m.products, id)
+	delete(m.sales, id)
+	if _, err := m.events.Append(EventProductDeleted, id, deleted{ID: id}, now); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddSale records a Sale of an existing Product made by user. Buyers are not
+// checked against any User.
+func (m *Memory) AddSale(ctx context.Context, user auth.Claims, productID string, ns NewSale,
+	now time.Time) (*Sale, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidUUID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[productID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	s := Sale{
+		ID:        uuid.New().String(),
+		ProductID: productID,
+		Paid:      ns.Paid,
+		Quantity:  ns.Quantity,
+		BuyerID:   ns.BuyerID,
+		CreatedAt: now,
+	}
+	if user.Subject != "" {
+		s.SellerID = &user.Subject
+	}
+	m.sales[productID] = append(m.sales[productID], s)
+	if _, err := m.events.Append(EventSaleRecorded, productID, s, now); err != nil {
+		return nil, err
+	}
+
+	p := m.aggregate(productID)
+	if p.Sold >= p.Quantity && p.Sold-s.Quantity < p.Quantity {
+		if _, err := m.events.Append(EventProductSoldOut, productID, p, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// ListSales returns the Sales of a Product in the order they were recorded.
+func (m *Memory) ListSales(ctx context.Context, productID string) ([]Sale, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidUUID
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := make([]Sale, len(m.sales[productID]))
+	copy(list, m.sales[productID])
+
+	return list, nil
+}
+
+// SalesRows returns a cursor over a copy of the Sales of a Product.
+func (m *Memory) SalesRows(ctx context.Context, productID string) (web.Rows, error) {
+	list, err := m.ListSales(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	return web.SliceRows(list), nil
+}
+
+// aggregate returns a copy of a Product with its sold and revenue totals. The
+// caller must hold the lock.
+func (m *Memory) aggregate(id string) Product {
+	p := m.products[id]
+	for _, s := range m.sales[id] {
+		p.Sold += s.Quantity
+		p.Revenue += s.Paid
+	}
+	return p
+}
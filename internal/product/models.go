@@ -2,7 +2,8 @@ package product
 
 import "time"
 
-// Product represents the product model
+// Product represents the product model. Cost and revenue are in cents. A
+// Product without an owner has an empty UserID.
 type Product struct {
 	ID        string    `db:"product_id" json:"id"`
 	Name      string    `db:"name" json:"name"`
@@ -34,17 +35,21 @@ type UpdateProduct struct {
 	Quantity *int    `json:"quantity" validate:"omitempty,gte=1"`
 }
 
-// Sale represents sale model in our database.
+// Sale represents sale model in our database. Amounts are in cents. The
+// seller is the User who recorded the Sale; the buyer is optional.
 type Sale struct {
 	ID        string    `db:"sale_id" json:"id"`
 	ProductID string    `db:"product_id" json:"product_id"`
 	Paid      int       `db:"paid" json:"paid"`
 	Quantity  int       `db:"quantity" json:"quantity"`
+	SellerID  *string   `db:"seller_id" json:"seller_id,omitempty"`
+	BuyerID   *string   `db:"buyer_id" json:"buyer_id,omitempty"`
 	CreatedAt time.Time `db:"created_at" json:"created_at"`
 }
 
 // NewSale is what we require from clients to make a new Sale.
 type NewSale struct {
-	Quantity int `json:"quantity" validate:"gte=1"`
-	Paid     int `json:"paid" validate:"gte=0"`
+	Quantity int     `json:"quantity" validate:"gte=1"`
+	Paid     int     `json:"paid" validate:"gte=0"`
+	BuyerID  *string `json:"buyer_id" validate:"omitempty,uuid"`
 }
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"log"
 	"time"
@@ -16,16 +20,46 @@ var (
 	ErrNotFound    = errors.New("Not found")
 	ErrInvalidUUID = errors.New("Invalid ID")
 	ErrForbidden   = errors.New("Not allowed action")
+
+	// ErrUnknownBuyer is returned when a Sale names a buyer that is not a User.
+	ErrUnknownBuyer = errors.New("Unknown buyer")
+)
+
+// The types of the events recorded for changes to Products and Sales. The
+// data of product events is the Product, of sale events the Sale. Deleted
+// Products only carry their ID. A Product sells out when a Sale brings its
+// sold quantity to its quantity; it runs low, as found by LowStock, when a few
+// units are left.
+const (
+	EventProductCreated  = "product.created"
+	EventProductUpdated  = "product.updated"
+	EventProductDeleted  = "product.deleted"
+	EventProductSoldOut  = "product.sold_out"
+	EventProductLowStock = "product.low_stock"
+	EventSaleRecorded    = "sale.recorded"
 )
 
+// EventTypes lists every event type, for example for webhook endpoints to
+// subscribe to.
+var EventTypes = []string{
+	EventProductCreated,
+	EventProductUpdated,
+	EventProductDeleted,
+	EventProductSoldOut,
+	EventProductLowStock,
+	EventSaleRecorded,
+}
+
+// deleted is the data of EventProductDeleted.
+type deleted struct {
+	ID string `json:"id"`
+}
+
 // List queries a database for products
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+func List(ctx context.Context, db database.Queryer) ([]Product, error) {
 	var list []Product
-	const q = `SELECT p.product_id, p.name, p.cost, p.quantity, p.user_id, COALESCE(SUM(s.quantity), 0) AS sold, 
-COALESCE(SUM(s.paid), 0) AS revenue, p.created_at, 
-p.updated_at FROM products AS p LEFT JOIN sales AS s on p.product_id = s.product_id GROUP BY p.product_id;`
 
-	if err := db.SelectContext(ctx, &list, q); err != nil {
+	if err := db.SelectContext(ctx, &list, listQuery); err != nil {
 		log.Println("internal: Could not query the database", err)
 		return nil, err
 	}
@@ -33,14 +67,32 @@ p.updated_at FROM products AS p LEFT JOIN sales AS s on p.product_id = s.product
 	return list, nil
 }
 
+// ListRows queries the products like List but returns the cursor so they can
+// be sent as they are read. The caller must close it.
+func ListRows(ctx context.Context, db database.Queryer) (*sqlx.Rows, error) {
+	rows, err := db.QueryxContext(ctx, listQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not query the database")
+	}
+
+	return rows, nil
+}
+
+// listQuery selects every product with its sales aggregates.
+const listQuery = `SELECT p.product_id, p.name, p.cost, p.quantity, COALESCE(p.user_id::TEXT, '') AS user_id,
+COALESCE(SUM(s.quantity), 0) AS sold, 
+COALESCE(SUM(s.paid), 0) AS revenue, p.created_at, 
+p.updated_at FROM products AS p LEFT JOIN sales AS s on p.product_id = s.product_id GROUP BY p.product_id;`
+
 // Retrieve returns a product
-func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
+func Retrieve(ctx context.Context, db database.Queryer, id string) (*Product, error) {
 	if _, err := uuid.Parse(id); err != nil {
 		return nil, ErrInvalidUUID
 	}
 
 	var p Product
-	q := `SELECT p.product_id, p.name, p.cost, p.quantity, p.user_id, COALESCE(SUM(s.paid), 0) AS revenue, 
+	q := `SELECT p.product_id, p.name, p.cost, p.quantity, COALESCE(p.user_id::TEXT, '') AS user_id,
+COALESCE(SUM(s.paid), 0) AS revenue, 
 COALESCE(SUM(s.quantity), 0) AS sold,
 p.created_at, 
 p.updated_at FROM products AS p LEFT JOIN sales AS s ON s.product_id = p.product_id WHERE p.product_id = $1 GROUP BY p.
@@ -57,7 +109,7 @@ product_id`
 }
 
 // Create makes a new Product.
-func Create(ctx context.Context, db *sqlx.DB, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
+func Create(ctx context.Context, db database.Queryer, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
 
 	p := Product{
 		ID:        uuid.New().String(),
@@ -76,12 +128,16 @@ $4, $5, $6, $7);`
 		return nil, errors.Wrap(err, "Cannot create a new product")
 	}
 
+	if err := record(ctx, db, EventProductCreated, p.ID, p, now); err != nil {
+		return nil, err
+	}
+
 	return &p, nil
 }
 
 // Update modifies data about a Product. It will error if the specified
 // ID is invalid or does not reference an existing Product.
-func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, update UpdateProduct,
+func Update(ctx context.Context, db database.Queryer, user auth.Claims, id string, update UpdateProduct,
 	now time.Time) error {
 
 	p, err := Retrieve(ctx, db, id)
@@ -117,27 +173,41 @@ func Update(ctx context.Context, db *sqlx.DB, user auth.Claims, id string, updat
 		return errors.Wrap(err, "Updating failed")
 	}
 
+	if err := record(ctx, db, EventProductUpdated, id, p, now); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// Delete removes a Product
-func Delete(ctx context.Context, db *sqlx.DB, ProductID string) error {
+// Delete removes a Product. An event is only recorded when the Product
+// existed.
+func Delete(ctx context.Context, db database.Queryer, ProductID string, now time.Time) error {
 	if _, err := uuid.Parse(ProductID); err != nil {
 		return ErrInvalidUUID
 	}
 	const q = `DELETE FROM products WHERE product_id = $1`
 
-	if _, err := db.ExecContext(ctx, q, ProductID); err != nil {
+	res, err := db.ExecContext(ctx, q, ProductID)
+	if err != nil {
 		return errors.Wrapf(err, "deleting a product %q", ProductID)
 	}
 
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if err := record(ctx, db, EventProductDeleted, ProductID, deleted{ID: ProductID}, now); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// AddSale creates a new Sale.
-func AddSale(ctx context.Context, db *sqlx.DB, ProductID string, ns NewSale,
-	now time.Time) (*NewSale,
-	error) {
+// AddSale creates a new Sale made by the user of the claims. It returns
+// ErrNotFound if the Product does not exist and ErrUnknownBuyer if the buyer
+// is not a User.
+func AddSale(ctx context.Context, db database.Queryer, user auth.Claims, ProductID string, ns NewSale,
+	now time.Time) (*Sale, error) {
 	if _, err := uuid.Parse(ProductID); err != nil {
 		return nil, ErrInvalidUUID
 	}
@@ -147,25 +217,64 @@ func AddSale(ctx context.Context, db *sqlx.DB, ProductID string, ns NewSale,
 		ProductID: ProductID,
 		Paid:      ns.Paid,
 		Quantity:  ns.Quantity,
+		BuyerID:   ns.BuyerID,
 		CreatedAt: now,
 	}
+	if user.Subject != "" {
+		s.SellerID = &user.Subject
+	}
 
-	const q = `INSERT INTO sales (sale_id, product_id, paid, quantity, created_at) VALUES ($1, $2, $3, $4, $5);`
-
-	if _, err := db.ExecContext(ctx, q, s.ID, s.ProductID, s.Paid, s.Quantity, s.CreatedAt); err != nil {
+	const q = `INSERT INTO sales (sale_id, product_id, paid, quantity, seller_id, buyer_id, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);`
+
+	if _, err := db.ExecContext(ctx, q, s.ID, s.ProductID, s.Paid, s.Quantity, s.SellerID, s.BuyerID,
+		s.CreatedAt); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "foreign_key_violation" {
+			switch pqErr.Constraint {
+			case "sales_buyer_id_fkey":
+				return nil, ErrUnknownBuyer
+			case "sales_seller_id_fkey":
+				return nil, ErrForbidden
+			}
+			return nil, ErrNotFound
+		}
 		return nil, errors.Wrap(err, "Could not create new sale")
 	}
 
-	return &ns, nil
+	if err := record(ctx, db, EventSaleRecorded, ProductID, s, now); err != nil {
+		return nil, err
+	}
+
+	p, err := Retrieve(ctx, db, ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Sold >= p.Quantity && p.Sold-s.Quantity < p.Quantity {
+		if err := record(ctx, db, EventProductSoldOut, ProductID, p, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
+
+// record appends an event about a change and queues its webhook deliveries.
+// It runs on the handle of the change so all of them commit together.
+func record(ctx context.Context, db database.Queryer, typ, subject string, data interface{}, now time.Time) error {
+	e, err := events.Append(ctx, db, typ, subject, data, now)
+	if err != nil {
+		return err
+	}
+	return webhook.Enqueue(ctx, db, *e, now)
 }
 
 // ListSales returns all sales for a Product.
-func ListSales(ctx context.Context, db *sqlx.DB, ProductID string) ([]Sale, error) {
+func ListSales(ctx context.Context, db database.Queryer, ProductID string) ([]Sale, error) {
 	if _, err := uuid.Parse(ProductID); err != nil {
 		return nil, ErrInvalidUUID
 	}
 
-	q := `SELECT product_id, sale_id, paid, quantity, created_at FROM sales WHERE product_id = $1;`
+	q := `SELECT product_id, sale_id, paid, quantity, seller_id, buyer_id, created_at FROM sales WHERE product_id = $1;`
 	var list []Sale
 
 	if err := db.SelectContext(ctx, &list, q, ProductID); err != nil {
@@ -174,3 +283,19 @@ func ListSales(ctx context.Context, db *sqlx.DB, ProductID string) ([]Sale, erro
 
 	return list, nil
 }
+
+// SalesRows queries the sales of a Product like ListSales but returns the
+// cursor so they can be sent as they are read. The caller must close it.
+func SalesRows(ctx context.Context, db database.Queryer, productID string) (*sqlx.Rows, error) {
+	if _, err := uuid.Parse(productID); err != nil {
+		return nil, ErrInvalidUUID
+	}
+
+	q := `SELECT product_id, sale_id, paid, quantity, seller_id, buyer_id, created_at FROM sales WHERE product_id = $1;`
+	rows, err := db.QueryxContext(ctx, q, productID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not query the database")
+	}
+
+	return rows, nil
+}
@@ -2,6 +2,7 @@ package product_test
 
 import (
 	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
 	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
 	"github.com/esmaeilmirzaee/grage/internal/product"
 	"github.com/esmaeilmirzaee/grage/internal/schema"
@@ -10,10 +11,16 @@ import (
 	"time"
 )
 
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
 // TestProducts creates and restore a new Product from the testing database
 func TestProducts(t *testing.T) {
-	db, cleanup := databasetest.Setup(t)
-	defer cleanup()
+	t.Parallel()
+
+	db := databasetest.Setup(t)
 	ctx := context.Background()
 
 	np := product.NewProduct{
@@ -24,7 +31,16 @@ func TestProducts(t *testing.T) {
 
 	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
 
-	p0, err := product.Create(ctx, db, np, now)
+	claims := auth.NewClaims("e612a422-2239-45e3-a8e0-c0c56c71454a", []string{auth.RoleAdmin}, now, time.Hour)
+
+	// Products reference the user who created them.
+	const q = `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at)
+VALUES ($1, 'Admin Gopher', 'admin@example.com', '', '{ADMIN}', $2, $2)`
+	if _, err := db.ExecContext(ctx, q, claims.Subject, now); err != nil {
+		t.Fatalf("Could not create user %s", err)
+	}
+
+	p0, err := product.Create(ctx, db, claims, np, now)
 	if err != nil {
 		t.Fatalf("Could not create new product %s", err)
 	}
@@ -43,8 +59,9 @@ func TestProducts(t *testing.T) {
 // TestList uses seed to function to seed the testing database and finally checks
 // the length of seed and retrieved Products
 func TestList(t *testing.T) {
-	db, cleanup := databasetest.Setup(t)
-	defer cleanup()
+	t.Parallel()
+
+	db := databasetest.Setup(t)
 	ctx := context.Background()
 
 	if err := schema.Seed(db); err != nil {
@@ -60,3 +77,59 @@ func TestList(t *testing.T) {
 		t.Fatalf("Expected %v but got %v", exp, got)
 	}
 }
+
+// TestLowStock checks that Products running low are recorded once, and again
+// after they were updated.
+func TestLowStock(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+
+	claims := auth.NewClaims("e612a422-2239-45e3-a8e0-c0c56c71454a", []string{auth.RoleAdmin}, now, time.Hour)
+	const q = `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at)
+VALUES ($1, 'Admin Gopher', 'admin@example.com', '', '{ADMIN}', $2, $2)`
+	if _, err := db.ExecContext(ctx, q, claims.Subject, now); err != nil {
+		t.Fatalf("Could not create user %s", err)
+	}
+
+	p, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Comic Books", Cost: 5, Quantity: 11}, now)
+	if err != nil {
+		t.Fatalf("Could not create new product %s", err)
+	}
+	if _, err := product.Create(ctx, db, claims, product.NewProduct{Name: "Puzzles", Cost: 8, Quantity: 2}, now); err != nil {
+		t.Fatalf("Could not create new product %s", err)
+	}
+
+	lowStock := func(at time.Time, want int) {
+		t.Helper()
+		list, err := product.LowStock(ctx, db, 2, at)
+		if err != nil {
+			t.Fatalf("Could not find products low on stock %s", err)
+		}
+		if len(list) != want {
+			t.Fatalf("Expected %d products low on stock, got %+v", want, list)
+		}
+	}
+
+	// Puzzles have few units from the start; Comic Books only after the sale.
+	lowStock(now, 1)
+	if _, err := product.AddSale(ctx, db, claims, p.ID, product.NewSale{Paid: 45, Quantity: 9}, now); err != nil {
+		t.Fatalf("Could not add sale %s", err)
+	}
+	lowStock(now.Add(time.Minute), 1)
+	lowStock(now.Add(2*time.Minute), 0)
+
+	quantity := 12
+	if err := product.Update(ctx, db, claims, p.ID, product.UpdateProduct{Quantity: &quantity}, now.Add(3*time.Minute)); err != nil {
+		t.Fatalf("Could not update product %s", err)
+	}
+	lowStock(now.Add(4*time.Minute), 0)
+
+	quantity = 10
+	if err := product.Update(ctx, db, claims, p.ID, product.UpdateProduct{Quantity: &quantity}, now.Add(5*time.Minute)); err != nil {
+		t.Fatalf("Could not update product %s", err)
+	}
+	lowStock(now.Add(6*time.Minute), 1)
+}
@@ -0,0 +1,148 @@
+package product
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"time"
+)
+
+// ProductStore is the set of operations the API needs to manage Products.
+type ProductStore interface {
+	List(ctx context.Context) ([]Product, error)
+
+	// ListRows returns the same Products as List one at a time, for
+	// exports too large to hold in memory.
+	ListRows(ctx context.Context) (web.Rows, error)
+
+	Retrieve(ctx context.Context, id string) (*Product, error)
+	Create(ctx context.Context, user auth.Claims, np NewProduct, now time.Time) (*Product, error)
+	CreateMany(ctx context.Context, user auth.Claims, nps []NewProduct, now time.Time) ([]Product, error)
+	Update(ctx context.Context, user auth.Claims, id string, update UpdateProduct, now time.Time) error
+	Delete(ctx context.Context, id string, now time.Time) error
+}
+
+// SaleStore is the set of operations the API needs to record Sales.
+type SaleStore interface {
+	AddSale(ctx context.Context, user auth.Claims, productID string, ns NewSale, now time.Time) (*Sale, error)
+	ListSales(ctx context.Context, productID string) ([]Sale, error)
+
+	// SalesRows returns the same Sales as ListSales one at a time, for
+	// histories too large to hold in memory.
+	SalesRows(ctx context.Context, productID string) (web.Rows, error)
+}
+
+// Postgres implements ProductStore and SaleStore with the functions of this
+// package. Reads are sent to a replica when the cluster has a healthy one.
+// Every method joins the transaction carried by its context, if any, so
+// several calls can be made atomic with database.WithTx. Changes are recorded
+// in the events table in the same transaction as the change itself.
+type Postgres struct {
+	db *database.Cluster
+}
+
+// NewPostgres constructs a Postgres store for the provided cluster.
+func NewPostgres(db *database.Cluster) *Postgres {
+	return &Postgres{db: db}
+}
+
+// List returns all the Products.
+func (s *Postgres) List(ctx context.Context) ([]Product, error) {
+	return List(ctx, s.reader(ctx))
+}
+
+// ListRows returns a cursor over all the Products.
+func (s *Postgres) ListRows(ctx context.Context) (web.Rows, error) {
+	return ListRows(ctx, s.reader(ctx))
+}
+
+// Retrieve returns a single Product.
+func (s *Postgres) Retrieve(ctx context.Context, id string) (*Product, error) {
+	return Retrieve(ctx, s.reader(ctx), id)
+}
+
+// Create makes a new Product owned by user.
+func (s *Postgres) Create(ctx context.Context, user auth.Claims, np NewProduct, now time.Time) (*Product, error) {
+	var p *Product
+	err := s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		var err error
+		p, err = Create(ctx, tx, user, np, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// CreateMany makes several Products owned by user in a single transaction.
+// Either all of them are created or none is.
+func (s *Postgres) CreateMany(ctx context.Context, user auth.Claims, nps []NewProduct,
+	now time.Time) ([]Product, error) {
+	list := make([]Product, 0, len(nps))
+	err := s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		// A retried transaction starts over.
+		list = list[:0]
+		for _, np := range nps {
+			p, err := Create(ctx, tx, user, np, now)
+			if err != nil {
+				return err
+			}
+			list = append(list, *p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Update modifies an existing Product.
+func (s *Postgres) Update(ctx context.Context, user auth.Claims, id string, update UpdateProduct,
+	now time.Time) error {
+	return s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		return Update(ctx, tx, user, id, update, now)
+	})
+}
+
+// Delete removes a Product and its Sales.
+func (s *Postgres) Delete(ctx context.Context, id string, now time.Time) error {
+	return s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		return Delete(ctx, tx, id, now)
+	})
+}
+
+// AddSale records a Sale of a Product made by user.
+func (s *Postgres) AddSale(ctx context.Context, user auth.Claims, productID string, ns NewSale,
+	now time.Time) (*Sale, error) {
+	var sale *Sale
+	err := s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		var err error
+		sale, err = AddSale(ctx, tx, user, productID, ns, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sale, nil
+}
+
+// ListSales returns the Sales of a Product.
+func (s *Postgres) ListSales(ctx context.Context, productID string) ([]Sale, error) {
+	return ListSales(ctx, s.reader(ctx), productID)
+}
+
+// SalesRows returns a cursor over the Sales of a Product.
+func (s *Postgres) SalesRows(ctx context.Context, productID string) (web.Rows, error) {
+	return SalesRows(ctx, s.reader(ctx), productID)
+}
+
+// reader returns the handle for a read-only call.
+func (s *Postgres) reader(ctx context.Context) database.Queryer {
+	return database.QueryerFrom(ctx, s.db.Reader(ctx))
+}
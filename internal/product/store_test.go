@@ -0,0 +1,197 @@
+package product_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/google/go-cmp/cmp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMemoryStore runs the store conformance suite against the in-memory
+// implementation.
+func TestMemoryStore(t *testing.T) {
+	m := product.NewMemory()
+	testStores(t, m, m)
+}
+
+// TestPostgresStore runs the store conformance suite against the Postgres
+// implementation.
+func TestPostgresStore(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+
+	// Products and sales reference the users of the suite.
+	const q = `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at)
+VALUES ($1, $2, $2, '', '{}', now(), now())`
+	for _, id := range []string{ownerID, adminID} {
+		if _, err := db.Exec(q, id, id+"@example.com"); err != nil {
+			t.Fatalf("Creating user %s: %v", id, err)
+		}
+	}
+
+	s := product.NewPostgres(database.NewCluster(db))
+	testStores(t, s, s)
+}
+
+// Users the suite acts as. Only the owner and the admin write anything.
+const (
+	ownerID    = "6a84703c-caaf-4c94-a0a7-b131e395abdf"
+	strangerID = "5cf37266-3473-4006-984f-9325122678b7"
+	adminID    = "e612a422-2239-45e3-a8e0-c0c56c71454a"
+)
+
+// testStores describes the behavior every ProductStore and SaleStore must
+// share. The stores are expected to start empty.
+func testStores(t *testing.T, products product.ProductStore, sales product.SaleStore) {
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	owner := auth.NewClaims(ownerID, []string{auth.RoleUser}, now, time.Hour)
+	stranger := auth.NewClaims(strangerID, []string{auth.RoleUser}, now, time.Hour)
+	admin := auth.NewClaims(adminID, []string{auth.RoleAdmin}, now, time.Hour)
+
+	var created *product.Product
+
+	t.Run("CreateRetrieve", func(t *testing.T) {
+		p, err := products.Create(ctx, owner, product.NewProduct{Name: "Comic Books", Cost: 10, Quantity: 5}, now)
+		if err != nil {
+			t.Fatalf("Creating product: %v", err)
+		}
+		created = p
+
+		got, err := products.Retrieve(ctx, p.ID)
+		if err != nil {
+			t.Fatalf("Retrieving product: %v", err)
+		}
+		if diff := cmp.Diff(p, got); diff != "" {
+			t.Fatalf("Retrieved product did not match the created one. Diff:\n%s", diff)
+		}
+	})
+
+	t.Run("RetrieveErrors", func(t *testing.T) {
+		if _, err := products.Retrieve(ctx, "not-a-uuid"); err != product.ErrInvalidUUID {
+			t.Fatalf("Expected %v, got %v", product.ErrInvalidUUID, err)
+		}
+		if _, err := products.Retrieve(ctx, "2fd0f9bf-d9e2-4cbd-8b2c-1a9b1d5e1c2a"); err != product.ErrNotFound {
+			t.Fatalf("Expected %v, got %v", product.ErrNotFound, err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		name := "Graphic Novels"
+		if err := products.Update(ctx, stranger, created.ID, product.UpdateProduct{Name: &name}, now); err != product.ErrForbidden {
+			t.Fatalf("Expected %v for a stranger, got %v", product.ErrForbidden, err)
+		}
+		if err := products.Update(ctx, owner, created.ID, product.UpdateProduct{Name: &name}, now.Add(time.Hour)); err != nil {
+			t.Fatalf("Updating as owner: %v", err)
+		}
+		cost := 12
+		if err := products.Update(ctx, admin, created.ID, product.UpdateProduct{Cost: &cost}, now.Add(2*time.Hour)); err != nil {
+			t.Fatalf("Updating as admin: %v", err)
+		}
+
+		got, err := products.Retrieve(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Retrieving product: %v", err)
+		}
+		if got.Name != name || got.Cost != cost || got.Quantity != created.Quantity {
+			t.Fatalf("Unexpected product after update: %+v", got)
+		}
+		if !got.UpdatedAt.Equal(now.Add(2 * time.Hour)) {
+			t.Fatalf("Expected updated_at %v, got %v", now.Add(2*time.Hour), got.UpdatedAt)
+		}
+	})
+
+	t.Run("Sales", func(t *testing.T) {
+		if _, err := sales.AddSale(ctx, admin, "2fd0f9bf-d9e2-4cbd-8b2c-1a9b1d5e1c2a", product.NewSale{Quantity: 1}, now); err != product.ErrNotFound {
+			t.Fatalf("Expected %v for a missing product, got %v", product.ErrNotFound, err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := sales.AddSale(ctx, admin, created.ID, product.NewSale{Quantity: 1, Paid: 12}, now); err != nil {
+					t.Errorf("Adding sale: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		list, err := sales.ListSales(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Listing sales: %v", err)
+		}
+		if len(list) != 4 {
+			t.Fatalf("Expected 4 sales, got %d", len(list))
+		}
+		for _, s := range list {
+			if s.SellerID == nil || *s.SellerID != adminID {
+				t.Fatalf("Expected the sale to be made by %s, got %v", adminID, s.SellerID)
+			}
+		}
+
+		got, err := products.Retrieve(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Retrieving product: %v", err)
+		}
+		if got.Sold != 4 || got.Revenue != 48 {
+			t.Fatalf("Expected sold 4 and revenue 48, got %d and %d", got.Sold, got.Revenue)
+		}
+	})
+
+	t.Run("ListDelete", func(t *testing.T) {
+		if _, err := products.Create(ctx, admin, product.NewProduct{Name: "McDonalds Toys", Cost: 75, Quantity: 120}, now); err != nil {
+			t.Fatalf("Creating product: %v", err)
+		}
+
+		list, err := products.List(ctx)
+		if err != nil {
+			t.Fatalf("Listing products: %v", err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("Expected 2 products, got %d", len(list))
+		}
+
+		if err := products.Delete(ctx, created.ID, now); err != nil {
+			t.Fatalf("Deleting product: %v", err)
+		}
+		if _, err := products.Retrieve(ctx, created.ID); err != product.ErrNotFound {
+			t.Fatalf("Expected %v after delete, got %v", product.ErrNotFound, err)
+		}
+
+		left, err := sales.ListSales(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Listing sales: %v", err)
+		}
+		if len(left) != 0 {
+			t.Fatalf("Expected sales to be removed with their product, got %d", len(left))
+		}
+	})
+
+	t.Run("CreateMany", func(t *testing.T) {
+		nps := []product.NewProduct{
+			{Name: "Puzzle", Cost: 5, Quantity: 1},
+			{Name: "Kite", Cost: 7, Quantity: 2},
+		}
+		list, err := products.CreateMany(ctx, owner, nps, now)
+		if err != nil {
+			t.Fatalf("Creating products: %v", err)
+		}
+		if len(list) != 2 || list[0].Name != "Puzzle" || list[1].UserID != ownerID {
+			t.Fatalf("Unexpected products created: %+v", list)
+		}
+
+		for _, p := range list {
+			if _, err := products.Retrieve(ctx, p.ID); err != nil {
+				t.Fatalf("Retrieving product %s: %v", p.ID, err)
+			}
+		}
+	})
+}
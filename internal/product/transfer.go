@@ -0,0 +1,325 @@
+package product
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is an encoding Products are imported from and exported to.
+type Format string
+
+// The supported formats. CSV files start with a header naming the columns;
+// NDJSON files hold one JSON object per line.
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// MaxImportRows bounds the number of Products a single import may create.
+var MaxImportRows = 10000
+
+var (
+	// ErrUnknownFormat is returned for a format that is not supported.
+	ErrUnknownFormat = errors.New("Unknown format, expected csv or ndjson")
+
+	// ErrTooManyRows is returned when an import exceeds MaxImportRows.
+	ErrTooManyRows = errors.New("Too many rows to import")
+)
+
+// MalformedError is returned when the file of an import can not be read.
+type MalformedError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *MalformedError) Error() string {
+	return e.Err.Error()
+}
+
+// ParseFormat recognizes a format by name, file extension or media type.
+func ParseFormat(s string) (Format, error) {
+	if mt, _, err := mime.ParseMediaType(s); err == nil {
+		s = mt
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "csv", "text/csv":
+		return FormatCSV, nil
+	case "ndjson", "jsonl", "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return FormatNDJSON, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType is the media type of a format.
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// ImportOptions control how an import treats invalid rows.
+type ImportOptions struct {
+	// DryRun validates every row without creating anything.
+	DryRun bool
+
+	// BestEffort creates the valid rows even when others are invalid. By
+	// default an invalid row makes the whole import fail.
+	BestEffort bool
+}
+
+// RowError lists the problems with one row of an import. Line is the line
+// of the file the row starts on.
+type RowError struct {
+	Line   int              `json:"line"`
+	Fields []web.FieldError `json:"fields"`
+}
+
+// ImportResult reports what an import did, or would do on a dry run.
+type ImportResult struct {
+	Rows     int        `json:"rows"`
+	Valid    int        `json:"valid"`
+	Imported int        `json:"imported"`
+	DryRun   bool       `json:"dry_run"`
+	Products []Product  `json:"products,omitempty"`
+	Errors   []RowError `json:"errors,omitempty"`
+}
+
+// row is a decoded row and the problems found with it.
+type row struct {
+	line    int
+	product NewProduct
+	fields  []web.FieldError
+}
+
+// Import reads Products in the given format and creates the valid ones owned
+// by user. Every row is checked like a NewProduct sent to the API. Without
+// BestEffort nothing is created if any row is invalid. The error is only set
+// when the file can not be read at all.
+func Import(ctx context.Context, store ProductStore, user auth.Claims, r io.Reader, format Format,
+	opts ImportOptions, now time.Time) (*ImportResult, error) {
+	rows, err := decodeRows(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ImportResult{
+		Rows:   len(rows),
+		DryRun: opts.DryRun,
+	}
+
+	var valid []NewProduct
+	for _, row := range rows {
+		if len(row.fields) == 0 {
+			if err := web.Validate(row.product); err != nil {
+				werr, ok := errors.Cause(err).(*web.Error)
+				if !ok {
+					return nil, err
+				}
+				row.fields = werr.Fields
+			}
+		}
+
+		if len(row.fields) > 0 {
+			result.Errors = append(result.Errors, RowError{Line: row.line, Fields: row.fields})
+			continue
+		}
+		valid = append(valid, row.product)
+	}
+	result.Valid = len(valid)
+
+	if opts.DryRun || len(valid) == 0 || (len(result.Errors) > 0 && !opts.BestEffort) {
+		return &result, nil
+	}
+
+	result.Products, err = store.CreateMany(ctx, user, valid, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating products")
+	}
+	result.Imported = len(result.Products)
+
+	return &result, nil
+}
+
+// decodeRows reads every row of r. Values of the wrong type are reported as
+// field errors of their row.
+func decodeRows(r io.Reader, format Format) ([]row, error) {
+	var rows []row
+	add := func(rw row) error {
+		if len(rows) == MaxImportRows {
+			return ErrTooManyRows
+		}
+		rows = append(rows, rw)
+		return nil
+	}
+
+	switch format {
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, &MalformedError{errors.Wrap(err, "reading csv header")}
+		}
+
+		columns := make(map[string]int)
+		for i, name := range header {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		for _, name := range []string{"name", "cost", "quantity"} {
+			if _, ok := columns[name]; !ok {
+				return nil, &MalformedError{errors.Errorf("csv header is missing the %s column", name)}
+			}
+		}
+
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, &MalformedError{errors.Wrap(err, "reading csv")}
+			}
+
+			line, _ := cr.FieldPos(0)
+			if err := add(csvRow(line, columns, record)); err != nil {
+				return nil, err
+			}
+		}
+
+	case FormatNDJSON:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			data := bytes.TrimSpace(sc.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+
+			rw := row{line: line}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&rw.product); err != nil {
+				rw.fields = []web.FieldError{{Field: jsonField(err), Error: err.Error()}}
+			}
+			if err := add(rw); err != nil {
+				return nil, err
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, &MalformedError{errors.Wrap(err, "reading ndjson")}
+		}
+		return rows, nil
+	}
+
+	return nil, ErrUnknownFormat
+}
+
+// csvRow converts a CSV record using the column positions of the header.
+func csvRow(line int, columns map[string]int, record []string) row {
+	rw := row{line: line}
+	value := func(name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	number := func(name string, dst *int) {
+		n, err := strconv.Atoi(value(name))
+		if err != nil {
+			rw.fields = append(rw.fields, web.FieldError{Field: name, Error: name + " must be a whole number"})
+			return
+		}
+		*dst = n
+	}
+
+	rw.product.Name = value("name")
+	number("cost", &rw.product.Cost)
+	number("quantity", &rw.product.Quantity)
+
+	return rw
+}
+
+// jsonField names the field a JSON decoding error is about, if it can tell.
+func jsonField(err error) string {
+	if terr, ok := err.(*json.UnmarshalTypeError); ok {
+		return terr.Field
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		return strings.Trim(strings.TrimPrefix(msg, "json: unknown field "), `"`)
+	}
+	return ""
+}
+
+// Export writes the Products of rows with their sold and revenue aggregates
+// as they are read, so an export takes constant memory however many Products
+// there are. rows is closed in any case.
+func Export(rows web.Rows, w io.Writer, format Format) error {
+	defer rows.Close()
+
+	if format != FormatCSV && format != FormatNDJSON {
+		return ErrUnknownFormat
+	}
+
+	var p Product
+	if format == FormatNDJSON {
+		enc := json.NewEncoder(w)
+		for rows.Next() {
+			if err := rows.StructScan(&p); err != nil {
+				return errors.Wrap(err, "scanning product")
+			}
+			if err := enc.Encode(p); err != nil {
+				return errors.Wrap(err, "writing product")
+			}
+		}
+		return errors.Wrap(rows.Err(), "reading products")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "name", "cost", "quantity", "sold", "revenue", "user_id",
+		"created_at", "updated_at"}); err != nil {
+		return errors.Wrap(err, "writing header")
+	}
+	for rows.Next() {
+		if err := rows.StructScan(&p); err != nil {
+			return errors.Wrap(err, "scanning product")
+		}
+		record := []string{
+			p.ID,
+			p.Name,
+			fmt.Sprint(p.Cost),
+			fmt.Sprint(p.Quantity),
+			fmt.Sprint(p.Sold),
+			fmt.Sprint(p.Revenue),
+			p.UserID,
+			p.CreatedAt.UTC().Format(time.RFC3339),
+			p.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "writing product")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "reading products")
+	}
+	cw.Flush()
+	return cw.Error()
+}
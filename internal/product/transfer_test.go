@@ -0,0 +1,136 @@
+package product_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestImport checks validation and the import modes.
+func TestImport(t *testing.T) {
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	owner := auth.NewClaims(ownerID, []string{auth.RoleUser}, now, time.Hour)
+
+	const csv = `name,quantity,cost
+Comic Books,12,50
+,1,10
+McDonalds Toys,three,75
+`
+	wantErrors := []product.RowError{
+		{Line: 3, Fields: []web.FieldError{{Field: "name", Error: "name is a required field"}}},
+		{Line: 4, Fields: []web.FieldError{{Field: "quantity", Error: "quantity must be a whole number"}}},
+	}
+
+	tests := []struct {
+		name     string
+		opts     product.ImportOptions
+		imported int
+	}{
+		{"AllOrNothing", product.ImportOptions{}, 0},
+		{"BestEffort", product.ImportOptions{BestEffort: true}, 1},
+		{"DryRun", product.ImportOptions{DryRun: true, BestEffort: true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := product.NewMemory()
+			ctx := context.Background()
+
+			result, err := product.Import(ctx, store, owner, strings.NewReader(csv), product.FormatCSV, tt.opts, now)
+			if err != nil {
+				t.Fatalf("Importing: %v", err)
+			}
+			if result.Rows != 3 || result.Valid != 1 || result.Imported != tt.imported {
+				t.Fatalf("Expected 3 rows, 1 valid and %d imported, got %+v", tt.imported, result)
+			}
+			if diff := cmp.Diff(wantErrors, result.Errors); diff != "" {
+				t.Fatalf("Unexpected row errors. Diff:\n%s", diff)
+			}
+
+			list, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("Listing: %v", err)
+			}
+			if len(list) != tt.imported {
+				t.Fatalf("Expected %d products in the store, got %d", tt.imported, len(list))
+			}
+		})
+	}
+
+	t.Run("NDJSON", func(t *testing.T) {
+		const ndjson = `{"name": "Comic Books", "cost": 50, "quantity": 12}
+
+{"name": "McDonalds Toys", "cost": "cheap", "quantity": 1}
+{"name": "Puzzle", "cost": 5, "quantity": 1, "color": "red"}
+`
+		result, err := product.Import(context.Background(), product.NewMemory(), owner, strings.NewReader(ndjson),
+			product.FormatNDJSON, product.ImportOptions{BestEffort: true}, now)
+		if err != nil {
+			t.Fatalf("Importing: %v", err)
+		}
+		if result.Imported != 1 || len(result.Errors) != 2 {
+			t.Fatalf("Expected 1 import and 2 errors, got %+v", result)
+		}
+		if e := result.Errors[0]; e.Line != 3 || e.Fields[0].Field != "cost" {
+			t.Fatalf("Expected a cost error on line 3, got %+v", e)
+		}
+		if e := result.Errors[1]; e.Line != 4 || e.Fields[0].Field != "color" {
+			t.Fatalf("Expected a color error on line 4, got %+v", e)
+		}
+	})
+
+	t.Run("MissingColumn", func(t *testing.T) {
+		_, err := product.Import(context.Background(), product.NewMemory(), owner, strings.NewReader("name,cost\n"),
+			product.FormatCSV, product.ImportOptions{}, now)
+		if _, ok := err.(*product.MalformedError); !ok {
+			t.Fatalf("Expected a MalformedError, got %v", err)
+		}
+	})
+}
+
+// TestExport checks that an export includes the sales aggregates and can be
+// imported again.
+func TestExport(t *testing.T) {
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	owner := auth.NewClaims(ownerID, []string{auth.RoleUser}, now, time.Hour)
+	ctx := context.Background()
+
+	store := product.NewMemory()
+	p, err := store.Create(ctx, owner, product.NewProduct{Name: "Comic Books", Cost: 50, Quantity: 12}, now)
+	if err != nil {
+		t.Fatalf("Creating product: %v", err)
+	}
+	if _, err := store.AddSale(ctx, owner, p.ID, product.NewSale{Quantity: 2, Paid: 90}, now); err != nil {
+		t.Fatalf("Adding sale: %v", err)
+	}
+
+	rows, err := store.ListRows(ctx)
+	if err != nil {
+		t.Fatalf("Listing: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := product.Export(rows, &buf, product.FormatCSV); err != nil {
+		t.Fatalf("Exporting: %v", err)
+	}
+
+	want := "id,name,cost,quantity,sold,revenue,user_id,created_at,updated_at\n" +
+		p.ID + ",Comic Books,50,12,2,90," + ownerID + ",2021-12-05T00:00:00Z,2021-12-05T00:00:00Z\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Fatalf("Unexpected export. Diff:\n%s", diff)
+	}
+
+	// Extra columns such as the aggregates are ignored on the way back in.
+	result, err := product.Import(ctx, product.NewMemory(), owner, &buf, product.FormatCSV, product.ImportOptions{}, now)
+	if err != nil {
+		t.Fatalf("Importing export: %v", err)
+	}
+	if result.Imported != 1 {
+		t.Fatalf("Expected the export to import again, got %+v", result)
+	}
+}
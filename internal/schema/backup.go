@@ -0,0 +1,365 @@
+package schema
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
+// Backups are gzip compressed JSON lines. The first line is a BackupHeader,
+// then come the rows of every table, one record per line, in the order of
+// backupTables so a row only ever references rows before it. The last line is
+// a trailer counting the rows, which tells a complete archive from a
+// truncated one.
+const (
+	backupFormat        = "garage-backup"
+	backupFormatVersion = 1
+)
+
+var (
+	// ErrVersionMismatch is returned when restoring an archive taken at
+	// another schema version than the one of the database.
+	ErrVersionMismatch = errors.New("schema version of the archive does not match the database")
+
+	// ErrNotEmpty is returned when restoring into a database holding data.
+	ErrNotEmpty = errors.New("database is not empty")
+)
+
+// BackupHeader describes an archive.
+type BackupHeader struct {
+	Format        string    `json:"format"`
+	FormatVersion int       `json:"format_version"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// BackupSummary is what a backup wrote or a restore loaded.
+type BackupSummary struct {
+	BackupHeader
+	Rows map[string]int `json:"rows"`
+}
+
+// backupRecord is a line of an archive after the header.
+type backupRecord struct {
+	Table string          `json:"table,omitempty"`
+	Row   json.RawMessage `json:"row,omitempty"`
+	End   map[string]int  `json:"end,omitempty"`
+}
+
+// The rows of every table as they are archived.
+type (
+	backupUser struct {
+		ID         string         `db:"user_id" json:"user_id"`
+		Name       string         `db:"name" json:"name"`
+		Email      string         `db:"email" json:"email"`
+		Password   string         `db:"password" json:"password"`
+		Roles      pq.StringArray `db:"roles" json:"roles"`
+		CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+		UpdatedAt  time.Time      `db:"updated_at" json:"updated_at"`
+		DisabledAt *time.Time     `db:"disabled_at" json:"disabled_at"`
+	}
+	backupProduct struct {
+		ID        string    `db:"product_id" json:"product_id"`
+		Name      string    `db:"name" json:"name"`
+		Cost      int64     `db:"cost" json:"cost"`
+		Quantity  int       `db:"quantity" json:"quantity"`
+		UserID    *string   `db:"user_id" json:"user_id"`
+		CreatedAt time.Time `db:"created_at" json:"created_at"`
+		UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	}
+	backupSale struct {
+		ID        string    `db:"sale_id" json:"sale_id"`
+		ProductID string    `db:"product_id" json:"product_id"`
+		Paid      int64     `db:"paid" json:"paid"`
+		Quantity  int       `db:"quantity" json:"quantity"`
+		SellerID  *string   `db:"seller_id" json:"seller_id"`
+		BuyerID   *string   `db:"buyer_id" json:"buyer_id"`
+		CreatedAt time.Time `db:"created_at" json:"created_at"`
+	}
+)
+
+// backupTable knows how to read, check and write the rows of a table.
+type backupTable struct {
+	name   string
+	query  string
+	insert string
+	new    func() interface{}
+
+	// refs returns the key of a row and the keys it references by table.
+	refs func(row interface{}) (key string, refs map[string][]*string)
+}
+
+// backupTables lists the archived tables, referenced tables first.
+var backupTables = []backupTable{
+	{
+		name: "users",
+		query: `SELECT user_id::TEXT, name, email, password, roles, created_at, updated_at, disabled_at
+FROM users ORDER BY user_id`,
+		insert: `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at, disabled_at)
+VALUES (:user_id, :name, :email, :password, :roles, :created_at, :updated_at, :disabled_at)`,
+		new: func() interface{} { return &backupUser{} },
+		refs: func(row interface{}) (string, map[string][]*string) {
+			return row.(*backupUser).ID, nil
+		},
+	},
+	{
+		name: "products",
+		query: `SELECT product_id::TEXT, name, cost, quantity, user_id::TEXT, created_at, updated_at
+FROM products ORDER BY product_id`,
+		insert: `INSERT INTO products (product_id, name, cost, quantity, user_id, created_at, updated_at)
+VALUES (:product_id, :name, :cost, :quantity, :user_id, :created_at, :updated_at)`,
+		new: func() interface{} { return &backupProduct{} },
+		refs: func(row interface{}) (string, map[string][]*string) {
+			p := row.(*backupProduct)
+			return p.ID, map[string][]*string{"users": {p.UserID}}
+		},
+	},
+	{
+		name: "sales",
+		query: `SELECT sale_id::TEXT, product_id::TEXT, paid, quantity, seller_id::TEXT, buyer_id::TEXT, created_at
+FROM sales ORDER BY sale_id`,
+		insert: `INSERT INTO sales (sale_id, product_id, paid, quantity, seller_id, buyer_id, created_at)
+VALUES (:sale_id, :product_id, :paid, :quantity, :seller_id, :buyer_id, :created_at)`,
+		new: func() interface{} { return &backupSale{} },
+		refs: func(row interface{}) (string, map[string][]*string) {
+			s := row.(*backupSale)
+			return s.ID, map[string][]*string{
+				"products": {&s.ProductID},
+				"users":    {s.SellerID, s.BuyerID},
+			}
+		},
+	},
+}
+
+// Backup writes a consistent snapshot of every table to w.
+func Backup(ctx context.Context, db *sqlx.DB, w io.Writer, now time.Time) (*BackupSummary, error) {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := BackupSummary{
+		BackupHeader: BackupHeader{
+			Format:        backupFormat,
+			FormatVersion: backupFormatVersion,
+			SchemaVersion: version,
+			CreatedAt:     now.UTC(),
+		},
+		Rows: make(map[string]int),
+	}
+	for _, t := range backupTables {
+		summary.Rows[t.name] = 0
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(summary.BackupHeader); err != nil {
+		return nil, errors.Wrap(err, "writing header")
+	}
+
+	// A repeatable read transaction sees every table as of the same moment.
+	opts := sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	err = database.WithTxOptions(ctx, db, &opts, func(ctx context.Context, tx database.Queryer) error {
+		for _, t := range backupTables {
+			rows, err := tx.QueryxContext(ctx, t.query)
+			if err != nil {
+				return errors.Wrapf(err, "reading %s", t.name)
+			}
+
+			for rows.Next() {
+				row := t.new()
+				if err := rows.StructScan(row); err != nil {
+					rows.Close()
+					return errors.Wrapf(err, "scanning %s", t.name)
+				}
+
+				data, err := json.Marshal(row)
+				if err != nil {
+					rows.Close()
+					return errors.Wrapf(err, "encoding %s", t.name)
+				}
+				if err := enc.Encode(backupRecord{Table: t.name, Row: data}); err != nil {
+					rows.Close()
+					return errors.Wrap(err, "writing archive")
+				}
+				summary.Rows[t.name]++
+			}
+			if err := rows.Err(); err != nil {
+				return errors.Wrapf(err, "reading %s", t.name)
+			}
+			rows.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := enc.Encode(backupRecord{End: summary.Rows}); err != nil {
+		return nil, errors.Wrap(err, "writing trailer")
+	}
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "compressing archive")
+	}
+
+	return &summary, nil
+}
+
+// RestoreOptions control how an archive is restored.
+type RestoreOptions struct {
+	// Migrate brings a database without any migration to the schema version
+	// of the archive first.
+	Migrate bool
+
+	// Force restores into a database at another schema version than the
+	// archive, which fails if the tables differ too much.
+	Force bool
+}
+
+// Restore loads an archive into an empty database in a single transaction.
+// Every reference is checked against the rows loaded before it, so a broken
+// or truncated archive leaves the database untouched. The database must be at
+// the schema version of the archive unless forced.
+func Restore(ctx context.Context, db *sqlx.DB, r io.Reader, opts RestoreOptions) (*BackupSummary, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading archive")
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(zr))
+
+	var summary BackupSummary
+	if err := dec.Decode(&summary.BackupHeader); err != nil {
+		return nil, errors.Wrap(err, "reading header")
+	}
+	if summary.Format != backupFormat {
+		return nil, errors.Errorf("not a backup archive, format is %q", summary.Format)
+	}
+	if summary.FormatVersion > backupFormatVersion {
+		return nil, errors.Errorf("archive format version %d is newer than the supported %d",
+			summary.FormatVersion, backupFormatVersion)
+	}
+
+	m, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 && opts.Migrate {
+		if err := m.To(ctx, summary.SchemaVersion); err != nil {
+			return nil, errors.Wrap(err, "migrating to the version of the archive")
+		}
+		version = summary.SchemaVersion
+	}
+	if version != summary.SchemaVersion && !opts.Force {
+		return nil, errors.Wrapf(ErrVersionMismatch, "archive is at version %d, database at %d",
+			summary.SchemaVersion, version)
+	}
+
+	tables := make(map[string]backupTable)
+	order := make(map[string]int)
+	for i, t := range backupTables {
+		tables[t.name] = t
+		order[t.name] = i
+	}
+
+	// The archive is read as it is loaded and can not be read again, so the
+	// load is not retried.
+	err = database.WithTxOnce(ctx, db, func(ctx context.Context, tx database.Queryer) error {
+		for _, t := range backupTables {
+			var n int
+			if err := tx.GetContext(ctx, &n, `SELECT count(*) FROM `+t.name); err != nil {
+				return errors.Wrapf(err, "counting %s", t.name)
+			}
+			if n > 0 {
+				return errors.Wrapf(ErrNotEmpty, "%s has %d rows", t.name, n)
+			}
+		}
+
+		keys := make(map[string]map[string]bool)
+		summary.Rows = make(map[string]int)
+		last := 0
+		for line := 2; ; line++ {
+			var rec backupRecord
+			if err := dec.Decode(&rec); err != nil {
+				if err == io.EOF {
+					return errors.New("archive is truncated, the trailer is missing")
+				}
+				return errors.Wrapf(err, "reading line %d", line)
+			}
+
+			if rec.End != nil {
+				for name, n := range rec.End {
+					if summary.Rows[name] != n {
+						return errors.Errorf("archive is incomplete, %s has %d rows instead of %d",
+							name, summary.Rows[name], n)
+					}
+				}
+				return nil
+			}
+
+			t, ok := tables[rec.Table]
+			if !ok {
+				return errors.Errorf("line %d: unknown table %q", line, rec.Table)
+			}
+			if order[t.name] < last {
+				return errors.Errorf("line %d: %s rows must come before %s rows", line, t.name, backupTables[last].name)
+			}
+			last = order[t.name]
+
+			row := t.new()
+			if err := json.Unmarshal(rec.Row, row); err != nil {
+				return errors.Wrapf(err, "line %d: decoding %s", line, t.name)
+			}
+
+			key, refs := t.refs(row)
+			for table, ids := range refs {
+				for _, id := range ids {
+					if id != nil && !keys[table][*id] {
+						return errors.Errorf("line %d: %s %s references missing %s %s", line, t.name, key, table, *id)
+					}
+				}
+			}
+			if keys[t.name] == nil {
+				keys[t.name] = make(map[string]bool)
+			}
+			if keys[t.name][key] {
+				return errors.Errorf("line %d: duplicate %s %s", line, t.name, key)
+			}
+			keys[t.name][key] = true
+
+			if _, err := sqlx.NamedExecContext(ctx, tx, t.insert, row); err != nil {
+				return errors.Wrapf(err, "line %d: inserting %s %s", line, t.name, key)
+			}
+			summary.Rows[t.name]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// String summarizes the archive for people.
+func (s BackupSummary) String() string {
+	return fmt.Sprintf("schema version %d taken at %s: %d users, %d products, %d sales",
+		s.SchemaVersion, s.CreatedAt.Format(time.RFC3339), s.Rows["users"], s.Rows["products"], s.Rows["sales"])
+}
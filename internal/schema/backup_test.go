@@ -0,0 +1,123 @@
+package schema_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestBackupRestore round trips the dev fixtures through an archive.
+func TestBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+
+	src := databasetest.Setup(t)
+	if err := schema.Seed(src); err != nil {
+		t.Fatalf("Seeding: %v", err)
+	}
+
+	var archive bytes.Buffer
+	summary, err := schema.Backup(ctx, src, &archive, now)
+	if err != nil {
+		t.Fatalf("Backing up: %v", err)
+	}
+	if summary.Rows["users"] != 2 || summary.Rows["products"] != 2 {
+		t.Fatalf("Expected the dev fixtures in the archive, got %v", summary.Rows)
+	}
+
+	dst := databasetest.Setup(t)
+	restored, err := schema.Restore(ctx, dst, bytes.NewReader(archive.Bytes()), schema.RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restoring: %v", err)
+	}
+	if diff := cmp.Diff(summary.Rows, restored.Rows); diff != "" {
+		t.Fatalf("Restored rows do not match the archive. Diff:\n%s", diff)
+	}
+
+	// Backing up the copy gives the same archive.
+	var again bytes.Buffer
+	if _, err := schema.Backup(ctx, dst, &again, now); err != nil {
+		t.Fatalf("Backing up the copy: %v", err)
+	}
+	if diff := cmp.Diff(decompress(t, archive.Bytes()), decompress(t, again.Bytes())); diff != "" {
+		t.Fatalf("Archive of the copy differs. Diff:\n%s", diff)
+	}
+
+	// Restoring twice finds the data of the first restore.
+	_, err = schema.Restore(ctx, dst, bytes.NewReader(archive.Bytes()), schema.RestoreOptions{})
+	if errors.Cause(err) != schema.ErrNotEmpty {
+		t.Fatalf("Expected %v, got %v", schema.ErrNotEmpty, err)
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		lines := strings.Split(decompress(t, archive.Bytes()), "\n")
+		truncated := compress(t, strings.Join(lines[:len(lines)-2], "\n"))
+
+		db := databasetest.Setup(t)
+		if _, err := schema.Restore(ctx, db, bytes.NewReader(truncated), schema.RestoreOptions{}); err == nil {
+			t.Fatal("Expected a truncated archive to fail")
+		}
+
+		var users int
+		if err := db.Get(&users, `SELECT count(*) FROM users`); err != nil {
+			t.Fatalf("Counting users: %v", err)
+		}
+		if users != 0 {
+			t.Fatalf("Expected a failed restore to leave the database empty, found %d users", users)
+		}
+	})
+
+	t.Run("VersionMismatch", func(t *testing.T) {
+		header := `{"format":"garage-backup","format_version":1,"schema_version":1,"created_at":"2021-12-05T00:00:00Z"}` +
+			"\n" + `{"end":{}}` + "\n"
+
+		db := databasetest.Setup(t)
+		_, err := schema.Restore(ctx, db, bytes.NewReader(compress(t, header)), schema.RestoreOptions{})
+		if errors.Cause(err) != schema.ErrVersionMismatch {
+			t.Fatalf("Expected %v, got %v", schema.ErrVersionMismatch, err)
+		}
+	})
+}
+
+func decompress(t *testing.T, data []byte) string {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Opening archive: %v", err)
+	}
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Reading archive: %v", err)
+	}
+	return string(out)
+}
+
+func compress(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("Compressing: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Compressing: %v", err)
+	}
+	return buf.Bytes()
+}
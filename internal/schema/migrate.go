@@ -1,45 +1,520 @@
 package schema
 
 import (
-	"github.com/GuiaBolso/darwin"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// Migrations contains the queries needed to construct the database schema.
-// Entries should never be removed from this slice once they have been ran in production.
+// Migrations are .sql files embedded into the binary. Each file is named
+// NNNN_description.sql and holds an up and a down section:
 //
-// Including the queries directly in this file has the same pros/cons mentioned in seed.go
-var migrations = []darwin.Migration{
-	{
-		Version:     1,
-		Description: "Add products",
-		Script:      `CREATE TABLE products (product_id UUID, name TEXT, cost INT, quantity INT, created_at TIMESTAMP, updated_at TIMESTAMP, PRIMARY KEY (product_id));`,
-	},
-	{
-		Version:     2,
-		Description: "Create sales tables",
-		Script: `CREATE TABLE sales (sale_id UUID, product_id UUID, paid INT, quantity INT, 
-created_at TIMESTAMP,   PRIMARY KEY (sale_id), FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE);`,
-	},
-	{
-		Version:     3,
-		Description: "Create users table",
-		Script: `CREATE TABLE IF NOT EXISTS users (user_id UUID, name TEXT, email TEXT UNIQUE, password TEXT, 
-roles TEXT[], created_at TIMESTAMP, updated_at TIMESTAMP, PRIMARY KEY (user_id));`,
-	},
-	{
-		Version:     4,
-		Description: "Add user column to products table",
-		Script:      `ALTER TABLE products ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000';`,
-	},
+//	-- +migrate up
+//	CREATE TABLE ...;
+//
+//	-- +migrate down
+//	DROP TABLE ...;
+//
+// Files must never be edited once they have been applied in production; the
+// checksum of every applied migration is verified before migrating.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// lockID is the advisory lock held while migrating so concurrent deploys do
+// not apply the same migration twice.
+const lockID = 7_238_553_190
+
+var (
+	// ErrDrift is returned when an applied migration no longer matches its
+	// file.
+	ErrDrift = errors.New("applied migrations do not match their files")
+
+	// ErrUnknownVersion is returned when a target version has no migration.
+	ErrUnknownVersion = errors.New("unknown migration version")
+
+	fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.sql$`)
+)
+
+// Migration is a single reversible change to the schema.
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+	Checksum    string
+}
+
+// Status describes a migration and whether it has been applied.
+type Status struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+	Drifted     bool       `json:"drifted,omitempty"`
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "reading migrations")
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		data, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", entry.Name())
+		}
+
+		m, err := parseMigration(entry.Name(), string(data))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, errors.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// parseMigration splits a migration file into its up and down sections.
+func parseMigration(name, data string) (Migration, error) {
+	match := fileName.FindStringSubmatch(name)
+	if match == nil {
+		return Migration{}, errors.Errorf("migration %s: name must look like 0001_description.sql", name)
+	}
+	version, _ := strconv.Atoi(match[1])
+
+	sum := sha256.Sum256([]byte(data))
+	m := Migration{
+		Version:     version,
+		Description: strings.ReplaceAll(match[2], "_", " "),
+		Checksum:    hex.EncodeToString(sum[:]),
+	}
+
+	var up, down strings.Builder
+	var section *strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "-- +migrate up":
+			section = &up
+			continue
+		case "-- +migrate down":
+			section = &down
+			continue
+		}
+		if section != nil {
+			section.WriteString(line)
+			section.WriteString("\n")
+		}
+	}
+
+	m.Up = strings.TrimSpace(up.String())
+	m.Down = strings.TrimSpace(down.String())
+	if m.Up == "" {
+		return Migration{}, errors.Errorf("migration %s: missing up section", name)
+	}
+	if m.Down == "" {
+		return Migration{}, errors.Errorf("migration %s: missing down section", name)
+	}
+
+	return m, nil
+}
+
+// Migrator applies and reverts migrations. When DryRun is set the SQL that
+// would run is written to Out and the database is left untouched.
+type Migrator struct {
+	DryRun bool
+	Out    io.Writer
+
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// NewMigrator constructs a Migrator for the embedded migrations.
+func NewMigrator(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	m := Migrator{
+		Out:        ioutil.Discard,
+		db:         db,
+		migrations: migrations,
+	}
+	return &m, nil
 }
 
 // Migrate attempts to bring the schema for db up to date with the migrations
 // defined in this package.
 func Migrate(db *sqlx.DB) error {
-	driver := darwin.NewGenericDriver(db.DB, darwin.PostgresDialect{})
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	return m.Up(context.Background(), 0)
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the highest applied version.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	for _, s := range statuses {
+		if s.Applied {
+			version = s.Version
+		}
+	}
+	return version, nil
+}
+
+// Check fails when a migration is pending or an applied one has drifted from
+// its file. It never changes the database.
+func (m *Migrator) Check(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		switch {
+		case !s.Applied:
+			return errors.Errorf("migration %d %q is pending, expected version %d", s.Version, s.Description, m.Latest())
+		case s.Drifted:
+			return errors.Errorf("migration %d %q has drifted", s.Version, s.Description)
+		}
+	}
+	return nil
+}
+
+// Status reports every migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring connection")
+	}
+	defer conn.Close()
+
+	return m.status(ctx, conn)
+}
+
+// Up applies the next n pending migrations, or all of them when n <= 0.
+func (m *Migrator) Up(ctx context.Context, n int) error {
+	return m.run(ctx, func(statuses []Status) ([]Migration, bool) {
+		var plan []Migration
+		for i, s := range statuses {
+			if s.Applied {
+				continue
+			}
+			if n > 0 && len(plan) == n {
+				break
+			}
+			plan = append(plan, m.migrations[i])
+		}
+		return plan, true
+	})
+}
+
+// Down reverts the last n applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.run(ctx, func(statuses []Status) ([]Migration, bool) {
+		var plan []Migration
+		for i := len(statuses) - 1; i >= 0 && len(plan) < n; i-- {
+			if statuses[i].Applied {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		return plan, false
+	})
+}
+
+// To migrates up or down until version is the last applied migration. A
+// version of 0 reverts every migration.
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 {
+		var found bool
+		for _, mig := range m.migrations {
+			found = found || mig.Version == version
+		}
+		if !found {
+			return errors.Wrapf(ErrUnknownVersion, "version %d", version)
+		}
+	}
+
+	// Revert anything newer than the target first, then apply anything
+	// older that is still pending.
+	if err := m.run(ctx, func(statuses []Status) ([]Migration, bool) {
+		var plan []Migration
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i].Applied && statuses[i].Version > version {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		return plan, false
+	}); err != nil {
+		return err
+	}
+
+	return m.run(ctx, func(statuses []Status) ([]Migration, bool) {
+		var plan []Migration
+		for i, s := range statuses {
+			if !s.Applied && s.Version <= version {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		return plan, true
+	})
+}
+
+// run holds the advisory lock, refuses to continue when applied migrations
+// have drifted and then applies or reverts the planned migrations one
+// transaction at a time.
+func (m *Migrator) run(ctx context.Context, plan func([]Status) ([]Migration, bool)) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return errors.Wrap(err, "acquiring connection")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return errors.Wrap(err, "acquiring migration lock")
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	if !m.DryRun {
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	statuses, err := m.status(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	var drifted []string
+	for _, s := range statuses {
+		if s.Drifted {
+			drifted = append(drifted, strconv.Itoa(s.Version))
+		}
+	}
+	if len(drifted) > 0 {
+		return errors.Wrapf(ErrDrift, "versions %s", strings.Join(drifted, ", "))
+	}
+
+	migrations, up := plan(statuses)
+	for _, mig := range migrations {
+		if err := m.apply(ctx, conn, mig, up); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply runs a single migration in either direction and records the result.
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, mig Migration, up bool) error {
+	script, direction := mig.Up, "up"
+	if !up {
+		script, direction = mig.Down, "down"
+	}
+
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %04d %s (%s)\n%s\n\n", mig.Version, mig.Description, direction, script)
+		return nil
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return errors.Wrapf(err, "migrating %s %04d %s", direction, mig.Version, mig.Description)
+	}
+
+	if up {
+		const q = `INSERT INTO schema_migrations (version, description, checksum, applied_at) VALUES ($1, $2, $3, $4)`
+		_, err = tx.ExecContext(ctx, q, mig.Version, mig.Description, mig.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "recording %04d", mig.Version)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "committing %04d", mig.Version)
+	}
+
+	fmt.Fprintf(m.Out, "%s %04d %s\n", direction, mig.Version, mig.Description)
+	return nil
+}
+
+// ensureTable creates the tracking table. Databases that were migrated by
+// darwin before the migrations moved to files have their history adopted so
+// nothing is applied twice. Adoption happens once: darwin_migrations is
+// renamed in the same transaction, so versions reverted later are not
+// copied back as applied.
+func (m *Migrator) ensureTable(ctx context.Context, conn *sqlx.Conn) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version     INT       NOT NULL,
+	description TEXT      NOT NULL,
+	checksum    TEXT      NOT NULL,
+	applied_at  TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (version)
+)`
+	if _, err := tx.ExecContext(ctx, q); err != nil {
+		return errors.Wrap(err, "creating schema_migrations")
+	}
+
+	var darwin sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('darwin_migrations')::TEXT`).Scan(&darwin); err != nil {
+		return errors.Wrap(err, "looking for darwin_migrations")
+	}
+	if !darwin.Valid {
+		return tx.Commit()
+	}
+
+	var versions []float64
+	if err := tx.SelectContext(ctx, &versions, `SELECT version FROM darwin_migrations`); err != nil {
+		return errors.Wrap(err, "reading darwin_migrations")
+	}
+
+	for _, v := range versions {
+		for _, mig := range m.migrations {
+			if float64(mig.Version) != v {
+				continue
+			}
+			const ins = `INSERT INTO schema_migrations (version, description, checksum, applied_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT DO NOTHING`
+			if _, err := tx.ExecContext(ctx, ins, mig.Version, mig.Description, mig.Checksum, time.Now().UTC()); err != nil {
+				return errors.Wrapf(err, "adopting darwin version %v", v)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE darwin_migrations RENAME TO darwin_migrations_adopted`); err != nil {
+		return errors.Wrap(err, "retiring darwin_migrations")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing darwin history")
+	}
+	return nil
+}
+
+// status joins the embedded migrations with the tracking table.
+func (m *Migrator) status(ctx context.Context, conn *sqlx.Conn) ([]Status, error) {
+	var table sql.NullString
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations')::TEXT`).Scan(&table); err != nil {
+		return nil, errors.Wrap(err, "looking for schema_migrations")
+	}
+
+	type row struct {
+		Version   int       `db:"version"`
+		Checksum  string    `db:"checksum"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+
+	applied := make(map[int]row)
+	if table.Valid {
+		var rows []row
+		if err := conn.SelectContext(ctx, &rows, `SELECT version, checksum, applied_at FROM schema_migrations`); err != nil {
+			return nil, errors.Wrap(err, "reading schema_migrations")
+		}
+		for _, r := range rows {
+			applied[r.Version] = r
+		}
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		s := Status{Version: mig.Version, Description: mig.Description}
+		if a, ok := applied[mig.Version]; ok {
+			at := a.AppliedAt
+			s.Applied = true
+			s.AppliedAt = &at
+			s.Drifted = a.Checksum != mig.Checksum
+		}
+		statuses[i] = s
+	}
+
+	return statuses, nil
+}
+
+// Create writes an empty migration file to dir, numbered after the newest
+// file already there, and returns its path.
+func Create(dir, name string) (string, error) {
+	slug := strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "", errors.New("migration name must contain letters or digits")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "reading migrations directory")
+	}
+
+	var last int
+	for _, entry := range entries {
+		if match := fileName.FindStringSubmatch(entry.Name()); match != nil {
+			if v, _ := strconv.Atoi(match[1]); v > last {
+				last = v
+			}
+		}
+	}
 
-	d := darwin.New(driver, migrations, nil)
+	path := filepath.Join(dir, fmt.Sprintf("%04d_%s.sql", last+1, slug))
+	const template = "-- +migrate up\n\n-- +migrate down\n"
+	if err := ioutil.WriteFile(path, []byte(template), 0644); err != nil {
+		return "", errors.Wrap(err, "writing migration")
+	}
 
-	return d.Migrate()
+	return path, nil
 }
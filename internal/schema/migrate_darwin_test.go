@@ -0,0 +1,56 @@
+package schema_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/schema"
+	"testing"
+)
+
+// TestDarwinHistory checks that a database migrated by darwin has its
+// history adopted once: a migration reverted afterwards is applied again by
+// the next up rather than copied back from darwin.
+func TestDarwinHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := databasetest.Setup(t)
+
+	m, err := schema.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("Constructing migrator: %v", err)
+	}
+
+	// Rewind the tracking to what darwin left behind.
+	if _, err := db.Exec(`DROP TABLE schema_migrations;
+CREATE TABLE darwin_migrations (
+	id             SERIAL,
+	version        REAL,
+	description    TEXT,
+	checksum       TEXT,
+	applied_at     INT,
+	execution_time REAL
+);`); err != nil {
+		t.Fatalf("Faking darwin history: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO darwin_migrations (version) SELECT generate_series(1, $1)`, m.Latest()); err != nil {
+		t.Fatalf("Faking darwin history: %v", err)
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatalf("Migrating down: %v", err)
+	}
+	if _, err := db.Exec(`SELECT request_id FROM webhook_deliveries LIMIT 0`); err == nil {
+		t.Fatal("Expected the column of the last migration to be dropped")
+	}
+
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatalf("Migrating up: %v", err)
+	}
+	if _, err := db.Exec(`SELECT request_id FROM webhook_deliveries LIMIT 0`); err != nil {
+		t.Fatalf("Expected the last migration to be applied again: %v", err)
+	}
+	if v, err := m.Version(ctx); err != nil || v != m.Latest() {
+		t.Fatalf("Expected version %d, got %d: %v", m.Latest(), v, err)
+	}
+}
@@ -0,0 +1,65 @@
+package schema
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestMigrations makes sure every embedded file parses and that versions are
+// contiguous so a missing file is noticed before it reaches a database.
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Parsing migrations: %v", err)
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Fatalf("Expected version %d, got %d (%s)", i+1, m.Version, m.Description)
+		}
+	}
+}
+
+func TestParseMigration(t *testing.T) {
+	const data = `-- +migrate up
+CREATE TABLE t (id INT);
+
+-- +migrate down
+DROP TABLE t;
+`
+	m, err := parseMigration("0007_add_t.sql", data)
+	if err != nil {
+		t.Fatalf("Parsing: %v", err)
+	}
+
+	if m.Version != 7 || m.Description != "add t" {
+		t.Fatalf("Unexpected version or description: %d %q", m.Version, m.Description)
+	}
+	if m.Up != "CREATE TABLE t (id INT);" || m.Down != "DROP TABLE t;" {
+		t.Fatalf("Unexpected sections: up %q, down %q", m.Up, m.Down)
+	}
+
+	if _, err := parseMigration("0007_add_t.sql", "-- +migrate up\nSELECT 1;\n"); err == nil {
+		t.Fatal("Expected an error for a migration without a down section")
+	}
+	if _, err := parseMigration("add_t.sql", data); err == nil {
+		t.Fatal("Expected an error for a file without a version")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "0009_existing.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := Create(dir, "Add Orders Table")
+	if err != nil {
+		t.Fatalf("Creating migration: %v", err)
+	}
+
+	if want := filepath.Join(dir, "0010_add_orders_table.sql"); path != want {
+		t.Fatalf("Expected %s, got %s", want, path)
+	}
+}
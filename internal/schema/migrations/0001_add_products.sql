@@ -0,0 +1,14 @@
+-- +migrate up
+CREATE TABLE products (
+	product_id UUID,
+	name       TEXT,
+	cost       INT,
+	quantity   INT,
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP,
+
+	PRIMARY KEY (product_id)
+);
+
+-- +migrate down
+DROP TABLE products;
@@ -0,0 +1,14 @@
+-- +migrate up
+CREATE TABLE sales (
+	sale_id    UUID,
+	product_id UUID,
+	paid       INT,
+	quantity   INT,
+	created_at TIMESTAMP,
+
+	PRIMARY KEY (sale_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+-- +migrate down
+DROP TABLE sales;
@@ -0,0 +1,15 @@
+-- +migrate up
+CREATE TABLE IF NOT EXISTS users (
+	user_id    UUID,
+	name       TEXT,
+	email      TEXT UNIQUE,
+	password   TEXT,
+	roles      TEXT[],
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP,
+
+	PRIMARY KEY (user_id)
+);
+
+-- +migrate down
+DROP TABLE users;
@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE products ADD COLUMN user_id UUID DEFAULT '00000000-0000-0000-0000-000000000000';
+
+-- +migrate down
+ALTER TABLE products DROP COLUMN user_id;
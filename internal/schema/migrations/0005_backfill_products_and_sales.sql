@@ -0,0 +1,28 @@
+-- +migrate up
+-- Give rows written before the constraints existed safe values so the
+-- constraints added next can be enforced. Rows this cannot fix are reported by
+-- "admin schema verify".
+UPDATE products SET name = 'unnamed' WHERE name IS NULL;
+UPDATE products SET cost = 0 WHERE cost IS NULL;
+UPDATE products SET quantity = 0 WHERE quantity IS NULL;
+UPDATE products SET created_at = now() WHERE created_at IS NULL;
+UPDATE products SET updated_at = created_at WHERE updated_at IS NULL;
+
+-- Products used to default to the nil UUID as their owner. No owner is NULL
+-- from now on, and owners that no longer exist are cleared as well.
+ALTER TABLE products ALTER COLUMN user_id DROP DEFAULT;
+UPDATE products SET user_id = NULL
+WHERE user_id = '00000000-0000-0000-0000-000000000000'
+   OR user_id NOT IN (SELECT user_id FROM users);
+
+UPDATE sales SET paid = 0 WHERE paid IS NULL;
+UPDATE sales SET created_at = now() WHERE created_at IS NULL;
+
+UPDATE users SET roles = '{}' WHERE roles IS NULL;
+UPDATE users SET created_at = now() WHERE created_at IS NULL;
+UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
+
+-- +migrate down
+-- Backfilled values cannot be told apart from real ones so only the default
+-- owner is restored.
+ALTER TABLE products ALTER COLUMN user_id SET DEFAULT '00000000-0000-0000-0000-000000000000';
@@ -0,0 +1,55 @@
+-- +migrate up
+ALTER TABLE products
+	ALTER COLUMN name SET NOT NULL,
+	ALTER COLUMN cost SET NOT NULL,
+	ALTER COLUMN quantity SET NOT NULL,
+	ALTER COLUMN created_at SET NOT NULL,
+	ALTER COLUMN updated_at SET NOT NULL,
+	ADD CONSTRAINT products_name_check CHECK (name <> ''),
+	ADD CONSTRAINT products_cost_check CHECK (cost >= 0),
+	ADD CONSTRAINT products_quantity_check CHECK (quantity >= 0),
+	ADD CONSTRAINT products_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE SET NULL;
+
+ALTER TABLE sales
+	ALTER COLUMN product_id SET NOT NULL,
+	ALTER COLUMN paid SET NOT NULL,
+	ALTER COLUMN quantity SET NOT NULL,
+	ALTER COLUMN created_at SET NOT NULL,
+	ADD CONSTRAINT sales_paid_check CHECK (paid >= 0),
+	ADD CONSTRAINT sales_quantity_check CHECK (quantity > 0);
+
+ALTER TABLE users
+	ALTER COLUMN name SET NOT NULL,
+	ALTER COLUMN email SET NOT NULL,
+	ALTER COLUMN password SET NOT NULL,
+	ALTER COLUMN roles SET NOT NULL,
+	ALTER COLUMN created_at SET NOT NULL,
+	ALTER COLUMN updated_at SET NOT NULL;
+
+-- +migrate down
+ALTER TABLE users
+	ALTER COLUMN name DROP NOT NULL,
+	ALTER COLUMN email DROP NOT NULL,
+	ALTER COLUMN password DROP NOT NULL,
+	ALTER COLUMN roles DROP NOT NULL,
+	ALTER COLUMN created_at DROP NOT NULL,
+	ALTER COLUMN updated_at DROP NOT NULL;
+
+ALTER TABLE sales
+	DROP CONSTRAINT sales_quantity_check,
+	DROP CONSTRAINT sales_paid_check,
+	ALTER COLUMN product_id DROP NOT NULL,
+	ALTER COLUMN paid DROP NOT NULL,
+	ALTER COLUMN quantity DROP NOT NULL,
+	ALTER COLUMN created_at DROP NOT NULL;
+
+ALTER TABLE products
+	DROP CONSTRAINT products_user_id_fkey,
+	DROP CONSTRAINT products_quantity_check,
+	DROP CONSTRAINT products_cost_check,
+	DROP CONSTRAINT products_name_check,
+	ALTER COLUMN name DROP NOT NULL,
+	ALTER COLUMN cost DROP NOT NULL,
+	ALTER COLUMN quantity DROP NOT NULL,
+	ALTER COLUMN created_at DROP NOT NULL,
+	ALTER COLUMN updated_at DROP NOT NULL;
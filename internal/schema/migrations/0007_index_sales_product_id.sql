@@ -0,0 +1,8 @@
+-- +migrate up
+-- Every product aggregate joins sales on product_id.
+CREATE INDEX IF NOT EXISTS sales_product_id_idx ON sales (product_id);
+CREATE INDEX IF NOT EXISTS products_user_id_idx ON products (user_id);
+
+-- +migrate down
+DROP INDEX IF EXISTS products_user_id_idx;
+DROP INDEX IF EXISTS sales_product_id_idx;
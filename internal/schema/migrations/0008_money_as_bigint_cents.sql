@@ -0,0 +1,8 @@
+-- +migrate up
+-- Amounts are whole cents. INT overflows at about 21 million in revenue.
+ALTER TABLE products ALTER COLUMN cost TYPE BIGINT;
+ALTER TABLE sales ALTER COLUMN paid TYPE BIGINT;
+
+-- +migrate down
+ALTER TABLE sales ALTER COLUMN paid TYPE INT;
+ALTER TABLE products ALTER COLUMN cost TYPE INT;
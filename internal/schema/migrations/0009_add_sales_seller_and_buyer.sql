@@ -0,0 +1,14 @@
+-- +migrate up
+ALTER TABLE sales
+	ADD COLUMN seller_id UUID,
+	ADD COLUMN buyer_id UUID,
+	ADD CONSTRAINT sales_seller_id_fkey FOREIGN KEY (seller_id) REFERENCES users(user_id) ON DELETE SET NULL,
+	ADD CONSTRAINT sales_buyer_id_fkey FOREIGN KEY (buyer_id) REFERENCES users(user_id) ON DELETE SET NULL;
+
+CREATE INDEX sales_seller_id_idx ON sales (seller_id);
+CREATE INDEX sales_buyer_id_idx ON sales (buyer_id);
+
+-- +migrate down
+ALTER TABLE sales
+	DROP COLUMN buyer_id,
+	DROP COLUMN seller_id;
@@ -0,0 +1,5 @@
+-- +migrate up
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
+
+-- +migrate down
+ALTER TABLE users DROP COLUMN disabled_at;
@@ -0,0 +1,11 @@
+-- +migrate up
+CREATE TABLE events (
+	event_id   BIGSERIAL PRIMARY KEY,
+	type       TEXT      NOT NULL,
+	subject    TEXT      NOT NULL,
+	data       JSONB     NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+-- +migrate down
+DROP TABLE events;
@@ -0,0 +1,44 @@
+-- +migrate up
+CREATE TABLE webhook_endpoints (
+	endpoint_id UUID      PRIMARY KEY,
+	url         TEXT      NOT NULL,
+	secret      TEXT      NOT NULL,
+	event_types TEXT[]    NOT NULL,
+	created_at  TIMESTAMP NOT NULL,
+	updated_at  TIMESTAMP NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+	delivery_id      BIGSERIAL PRIMARY KEY,
+	endpoint_id      UUID      NOT NULL REFERENCES webhook_endpoints(endpoint_id) ON DELETE CASCADE,
+	event_id         BIGINT    NOT NULL,
+	event_type       TEXT      NOT NULL,
+	payload          JSONB     NOT NULL,
+	status           TEXT      NOT NULL,
+	attempts         INT       NOT NULL DEFAULT 0,
+	next_attempt_at  TIMESTAMP NOT NULL,
+	last_status_code INT,
+	last_error       TEXT,
+	created_at       TIMESTAMP NOT NULL,
+	updated_at       TIMESTAMP NOT NULL,
+	delivered_at     TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_endpoint_id_idx ON webhook_deliveries (endpoint_id);
+
+CREATE TABLE webhook_attempts (
+	attempt_id   BIGSERIAL PRIMARY KEY,
+	delivery_id  BIGINT    NOT NULL REFERENCES webhook_deliveries(delivery_id) ON DELETE CASCADE,
+	status_code  INT,
+	error        TEXT,
+	duration_ms  BIGINT    NOT NULL,
+	attempted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX webhook_attempts_delivery_id_idx ON webhook_attempts (delivery_id);
+
+-- +migrate down
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
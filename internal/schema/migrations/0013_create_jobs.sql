@@ -0,0 +1,36 @@
+-- +migrate up
+CREATE TABLE jobs (
+	job_id       BIGSERIAL PRIMARY KEY,
+	kind         TEXT      NOT NULL,
+	payload      JSONB     NOT NULL,
+	status       TEXT      NOT NULL,
+	attempts     INT       NOT NULL DEFAULT 0,
+	run_at       TIMESTAMP NOT NULL,
+	unique_key   TEXT,
+	locked_by    TEXT,
+	locked_until TIMESTAMP,
+	last_error   TEXT,
+	created_at   TIMESTAMP NOT NULL,
+	updated_at   TIMESTAMP NOT NULL,
+	finished_at  TIMESTAMP
+);
+
+CREATE INDEX jobs_due_idx ON jobs (run_at) WHERE status IN ('queued', 'running');
+CREATE INDEX jobs_kind_status_idx ON jobs (kind, status);
+
+-- At most one job with a given key waits or runs at a time.
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (unique_key) WHERE status IN ('queued', 'running');
+
+CREATE TABLE job_schedules (
+	name        TEXT      PRIMARY KEY,
+	next_run_at TIMESTAMP NOT NULL,
+	last_run_at TIMESTAMP
+);
+
+-- Low stock alerts look for the earlier alerts of a product.
+CREATE INDEX events_subject_type_idx ON events (subject, type);
+
+-- +migrate down
+DROP INDEX events_subject_type_idx;
+DROP TABLE job_schedules;
+DROP TABLE jobs;
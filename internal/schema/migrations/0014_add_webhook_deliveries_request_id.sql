@@ -0,0 +1,7 @@
+-- +migrate up
+-- The ID of the request whose change queued a delivery, sent along with it so
+-- partners can quote it. Deliveries queued outside of a request have none.
+ALTER TABLE webhook_deliveries ADD COLUMN request_id TEXT NOT NULL DEFAULT '';
+
+-- +migrate down
+ALTER TABLE webhook_deliveries DROP COLUMN request_id;
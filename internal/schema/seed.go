@@ -1,43 +1,261 @@
 package schema
 
-import "github.com/jmoiron/sqlx"
-
-// seeds is a string constraint containing all of the queries needed to get the database
-// seeded to a useful state for development.
-// -------------------------------------------------------------------------------------
-// Using a constant in a .go file is an easy way to ensure the queries are part of the
-// compiled executable and avoids pathing issues with the working directory. It has the
-// downside that it lacks syntax highlighting and may be harder to read for some cases
-// compared to using .sql files. You may also consider a combined approach using a tool
-// like packr or go-bindata.
-// -------------------------------------------------------------------------------------
-// Note that database servers besides PostgreSQL may not support running multiple queries
-// as part of the same execution so this single large constant may need to be broken up.
-
-const seeds = `
-INSERT INTO products (product_id, name, cost, quantity, created_at, updated_at) VALUES ('a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 'Comic books', 50, 42, NOW(), NOW()),('d2cabc99-9c0b-4ef8-bb6a-2bb9bd380b2c', 'McDonalds toys', 75, 120, NOW(), NOW()) ON CONFLICT DO NOTHING; 
-
-INSERT INTO sales (sale_id, product_id, paid, quantity, created_at) VALUES ('6f70b8b7-90bf-4b43-a7c7-6c3051f5c7f1',
-'d2cabc99-9c0b-4ef8-bb6a-2bb9bd380b2c', 2, 100, NOW()), ('df566f1a-d511-41eb-b612-0f8a79f7cd3a', 
-'d2cabc99-9c0b-4ef8-bb6a-2bb9bd380b2c', 5, 250, NOW()), ('2feb6493-ea34-49d2-b696-11928343f8d3', 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', 3, 225, NOW()); 
-
--- Create Admin and regular User with password "gophers" 
-INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at) VALUES('e612a422-2239-45e3-a8e0-c0c56c71454a', 'Admin Gopher', 'admin@example.com', '', '{ADMIN,USER}', NOW(), NOW()), ('6a84703c-caaf-4c94-a0a7-b131e395abdf', 'User Gopher', 'user@example.com', '', '{USER}', NOW(), NOW()) ON CONFLICT DO NOTHING;`
-
-// Seed runs the set of seed-data queries against db. The queries are ran in a
-// transaction and rolled back if any fail.
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"io/fs"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Fixture sets are YAML files embedded into the binary. Every set is named
+// after its file: fixtures/demo.yaml is the "demo" set. Each record carries a
+// fixed ID so a set can be loaded any number of times without duplicating
+// anything.
+//
+//go:embed fixtures/*.yaml
+var fixtureFiles embed.FS
+
+// generatedNamespace is the namespace of the IDs of generated records. IDs are
+// derived from the record number so generating the same records twice is a
+// no-op.
+var generatedNamespace = uuid.MustParse("2c6b1f0e-6f5d-4f43-9d0b-8f6a3c1e5b7d")
+
+// Fixtures is a declarative data set. Users, owners, sellers and buyers are
+// referenced by email; products by ID.
+type Fixtures struct {
+	Generate int              `yaml:"generate"`
+	Users    []FixtureUser    `yaml:"users"`
+	Products []FixtureProduct `yaml:"products"`
+	Sales    []FixtureSale    `yaml:"sales"`
+	Orders   []FixtureOrder   `yaml:"orders"`
+}
+
+// FixtureUser is a User with a plaintext password that is hashed when the set
+// is applied.
+type FixtureUser struct {
+	ID       string   `yaml:"id"`
+	Name     string   `yaml:"name"`
+	Email    string   `yaml:"email"`
+	Password string   `yaml:"password"`
+	Roles    []string `yaml:"roles"`
+}
+
+// FixtureProduct is a Product and the email of its owner.
+type FixtureProduct struct {
+	ID       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Cost     int    `yaml:"cost"`
+	Quantity int    `yaml:"quantity"`
+	Owner    string `yaml:"owner"`
+}
+
+// FixtureSale is a single Sale of a Product.
+type FixtureSale struct {
+	ID       string `yaml:"id"`
+	Product  string `yaml:"product"`
+	Quantity int    `yaml:"quantity"`
+	Paid     int    `yaml:"paid"`
+	Seller   string `yaml:"seller"`
+	Buyer    string `yaml:"buyer"`
+}
+
+// FixtureOrder is a buyer taking several products at once. There is no order
+// table; every line becomes a Sale with an ID derived from the order.
+type FixtureOrder struct {
+	ID     string             `yaml:"id"`
+	Buyer  string             `yaml:"buyer"`
+	Seller string             `yaml:"seller"`
+	Lines  []FixtureOrderLine `yaml:"lines"`
+}
+
+// FixtureOrderLine is one product of an order.
+type FixtureOrderLine struct {
+	Product  string `yaml:"product"`
+	Quantity int    `yaml:"quantity"`
+	Paid     int    `yaml:"paid"`
+}
+
+// FixtureSets returns the names of the embedded fixture sets.
+func FixtureSets() []string {
+	entries, _ := fs.ReadDir(fixtureFiles, "fixtures")
+
+	var sets []string
+	for _, entry := range entries {
+		sets = append(sets, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(sets)
+	return sets
+}
+
+// LoadFixtures reads the named fixture set.
+func LoadFixtures(set string) (*Fixtures, error) {
+	data, err := fixtureFiles.ReadFile("fixtures/" + set + ".yaml")
+	if err != nil {
+		return nil, errors.Errorf("unknown fixture set %q, expected one of %s", set, strings.Join(FixtureSets(), ", "))
+	}
+
+	var f Fixtures
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, errors.Wrapf(err, "parsing fixture set %q", set)
+	}
+
+	return &f, nil
+}
+
+// Seed loads the "dev" fixture set into db.
 func Seed(db *sqlx.DB) error {
-	tx, err := db.Begin()
+	f, err := LoadFixtures("dev")
 	if err != nil {
 		return err
 	}
+	return f.Apply(context.Background(), db, time.Now())
+}
+
+// AddGenerated appends n random but realistic products, each with a few sales,
+// to the set. Products are owned and sold by the users of the set in turn. The
+// same record number always gets the same ID so generating again is a no-op.
+func (f *Fixtures) AddGenerated(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if len(f.Users) == 0 {
+		return errors.New("generating records needs at least one user in the set")
+	}
 
-	if _, err = tx.Exec(seeds); err != nil {
-		if err = tx.Rollback(); err != nil {
-			return err
+	adjectives := []string{"Vintage", "Used", "Antique", "Handmade", "Signed", "Rare", "Boxed", "Faded", "Mint", "Retro"}
+	things := []string{"Lamp", "Comic Book", "Record", "Teapot", "Bicycle", "Board Game", "Chair", "Camera", "Clock", "Guitar"}
+
+	for i := 0; i < n; i++ {
+		rng := rand.New(rand.NewSource(int64(i)))
+		key := fmt.Sprintf("product-%d", i)
+
+		p := FixtureProduct{
+			ID:       uuid.NewSHA1(generatedNamespace, []byte(key)).String(),
+			Name:     adjectives[rng.Intn(len(adjectives))] + " " + things[rng.Intn(len(things))],
+			Cost:     100 * (1 + rng.Intn(200)),
+			Quantity: 1 + rng.Intn(50),
+			Owner:    f.Users[i%len(f.Users)].Email,
+		}
+		f.Products = append(f.Products, p)
+
+		left, count := p.Quantity, rng.Intn(4)
+		for j := 0; j < count && left > 0; j++ {
+			qty := 1 + rng.Intn(left)
+			left -= qty
+
+			// Haggling knocks up to a fifth off the asking price.
+			paid := p.Cost * qty * (80 + rng.Intn(21)) / 100
+			f.Sales = append(f.Sales, FixtureSale{
+				ID:       uuid.NewSHA1(generatedNamespace, []byte(fmt.Sprintf("%s-sale-%d", key, j))).String(),
+				Product:  p.ID,
+				Quantity: qty,
+				Paid:     paid,
+				Seller:   f.Users[(i+j+1)%len(f.Users)].Email,
+			})
 		}
-		return err
 	}
 
-	return tx.Commit()
+	return nil
+}
+
+// Apply writes the set to db in a single transaction. Records that already
+// exist, by ID or by user email, are left untouched.
+func (f *Fixtures) Apply(ctx context.Context, db *sqlx.DB, now time.Time) error {
+	return database.WithTx(ctx, db, func(ctx context.Context, tx database.Queryer) error {
+		now := now.UTC()
+
+		for _, u := range f.Users {
+			hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return errors.Wrapf(err, "hashing password of %s", u.Email)
+			}
+
+			const q = `INSERT INTO users (user_id, name, email, password, roles, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $6) ON CONFLICT DO NOTHING`
+			if _, err := tx.ExecContext(ctx, q, u.ID, u.Name, u.Email, hash, pq.StringArray(u.Roles), now); err != nil {
+				return errors.Wrapf(err, "inserting user %s", u.Email)
+			}
+		}
+
+		// Users may already exist under another ID so references are resolved
+		// against the database rather than the set.
+		ids := make(map[string]string)
+		resolve := func(email string) (interface{}, error) {
+			if email == "" {
+				return nil, nil
+			}
+			if id, ok := ids[email]; ok {
+				return id, nil
+			}
+
+			var id string
+			if err := tx.GetContext(ctx, &id, `SELECT user_id FROM users WHERE email = $1`, email); err != nil {
+				return nil, errors.Wrapf(err, "looking up user %s", email)
+			}
+			ids[email] = id
+			return id, nil
+		}
+
+		for _, p := range f.Products {
+			owner, err := resolve(p.Owner)
+			if err != nil {
+				return err
+			}
+
+			const q = `INSERT INTO products (product_id, name, cost, quantity, user_id, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $6) ON CONFLICT DO NOTHING`
+			if _, err := tx.ExecContext(ctx, q, p.ID, p.Name, p.Cost, p.Quantity, owner, now); err != nil {
+				return errors.Wrapf(err, "inserting product %s", p.ID)
+			}
+		}
+
+		sales := f.Sales
+		for _, o := range f.Orders {
+			orderID, err := uuid.Parse(o.ID)
+			if err != nil {
+				return errors.Wrapf(err, "parsing order id %q", o.ID)
+			}
+			for i, l := range o.Lines {
+				sales = append(sales, FixtureSale{
+					ID:       uuid.NewSHA1(orderID, []byte(fmt.Sprint(i))).String(),
+					Product:  l.Product,
+					Quantity: l.Quantity,
+					Paid:     l.Paid,
+					Seller:   o.Seller,
+					Buyer:    o.Buyer,
+				})
+			}
+		}
+
+		for _, s := range sales {
+			seller, err := resolve(s.Seller)
+			if err != nil {
+				return err
+			}
+			buyer, err := resolve(s.Buyer)
+			if err != nil {
+				return err
+			}
+
+			const q = `INSERT INTO sales (sale_id, product_id, paid, quantity, seller_id, buyer_id, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`
+			if _, err := tx.ExecContext(ctx, q, s.ID, s.Product, s.Paid, s.Quantity, seller, buyer, now); err != nil {
+				return errors.Wrapf(err, "inserting sale %s", s.ID)
+			}
+		}
+
+		return nil
+	})
 }
@@ -0,0 +1,92 @@
+package schema
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+// TestFixtureSets makes sure every embedded set parses and only references
+// users and products that exist in the same set.
+func TestFixtureSets(t *testing.T) {
+	sets := FixtureSets()
+	if diff := cmp.Diff([]string{"demo", "dev", "loadtest"}, sets); diff != "" {
+		t.Fatalf("Unexpected fixture sets. Diff:\n%s", diff)
+	}
+
+	for _, set := range sets {
+		f, err := LoadFixtures(set)
+		if err != nil {
+			t.Fatalf("Loading %s: %v", set, err)
+		}
+		if err := f.AddGenerated(f.Generate); err != nil {
+			t.Fatalf("Generating %s: %v", set, err)
+		}
+
+		users := make(map[string]bool)
+		for _, u := range f.Users {
+			if u.Password == "" {
+				t.Errorf("%s: user %s has no password", set, u.Email)
+			}
+			users[u.Email] = true
+		}
+
+		products := make(map[string]bool)
+		for _, p := range f.Products {
+			if !users[p.Owner] {
+				t.Errorf("%s: product %s is owned by unknown user %q", set, p.ID, p.Owner)
+			}
+			products[p.ID] = true
+		}
+
+		for _, s := range f.Sales {
+			if !products[s.Product] {
+				t.Errorf("%s: sale %s is of unknown product %q", set, s.ID, s.Product)
+			}
+		}
+
+		for _, o := range f.Orders {
+			if !users[o.Buyer] || !users[o.Seller] {
+				t.Errorf("%s: order %s references unknown users", set, o.ID)
+			}
+			for _, l := range o.Lines {
+				if !products[l.Product] {
+					t.Errorf("%s: order %s is of unknown product %q", set, o.ID, l.Product)
+				}
+			}
+		}
+	}
+}
+
+// TestAddGenerated checks that generated records are stable so seeding twice
+// does not duplicate them.
+func TestAddGenerated(t *testing.T) {
+	generate := func(n int) *Fixtures {
+		f, err := LoadFixtures("loadtest")
+		if err != nil {
+			t.Fatalf("Loading: %v", err)
+		}
+		if err := f.AddGenerated(n); err != nil {
+			t.Fatalf("Generating: %v", err)
+		}
+		return f
+	}
+
+	small, large := generate(10), generate(20)
+	if len(small.Products) != 10 || len(large.Products) != 20 {
+		t.Fatalf("Expected 10 and 20 products, got %d and %d", len(small.Products), len(large.Products))
+	}
+	if diff := cmp.Diff(small.Products, large.Products[:10]); diff != "" {
+		t.Fatalf("Generated products are not stable. Diff:\n%s", diff)
+	}
+
+	for _, p := range large.Products {
+		if p.Cost <= 0 || p.Quantity <= 0 {
+			t.Fatalf("Generated product breaks the schema constraints: %+v", p)
+		}
+	}
+	for _, s := range large.Sales {
+		if s.Quantity <= 0 || s.Paid < 0 {
+			t.Fatalf("Generated sale breaks the schema constraints: %+v", s)
+		}
+	}
+}
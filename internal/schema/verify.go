@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"context"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Violation describes rows that break a constraint added by the hardening
+// migrations. Rows the migrations backfill on their own are reported too but
+// are flagged so they can be told apart from rows that block migrating.
+type Violation struct {
+	Table      string   `json:"table"`
+	Constraint string   `json:"constraint"`
+	Rows       int      `json:"rows"`
+	Sample     []string `json:"sample"`
+	Backfilled bool     `json:"backfilled"`
+}
+
+// check is a predicate matching rows that violate a constraint.
+type check struct {
+	table      string
+	key        string
+	constraint string
+	where      string
+	backfilled bool
+}
+
+// checks lists every constraint of migrations 5 and 6 with the rows that
+// break it.
+var checks = []check{
+	{"products", "product_id", "name is missing", "name IS NULL", true},
+	{"products", "product_id", "products_name_check", "name = ''", false},
+	{"products", "product_id", "cost is missing", "cost IS NULL", true},
+	{"products", "product_id", "products_cost_check", "cost < 0", false},
+	{"products", "product_id", "quantity is missing", "quantity IS NULL", true},
+	{"products", "product_id", "products_quantity_check", "quantity < 0", false},
+	{"products", "product_id", "timestamps are missing", "created_at IS NULL OR updated_at IS NULL", true},
+	{"products", "product_id", "products_user_id_fkey", "user_id IS NOT NULL AND user_id NOT IN (SELECT user_id FROM users)", true},
+	{"sales", "sale_id", "product_id is missing", "product_id IS NULL", false},
+	{"sales", "sale_id", "paid is missing", "paid IS NULL", true},
+	{"sales", "sale_id", "sales_paid_check", "paid < 0", false},
+	{"sales", "sale_id", "sales_quantity_check", "quantity IS NULL OR quantity <= 0", false},
+	{"sales", "sale_id", "created_at is missing", "created_at IS NULL", true},
+	{"users", "user_id", "name, email or password is missing", "name IS NULL OR email IS NULL OR password IS NULL", false},
+	{"users", "user_id", "roles or timestamps are missing", "roles IS NULL OR created_at IS NULL OR updated_at IS NULL", true},
+}
+
+// Verify reports the rows that violate the constraints of the hardening
+// migrations. Run it before migrating an existing database: any violation
+// that is not backfilled makes the migration fail and must be fixed by hand.
+func Verify(ctx context.Context, db *sqlx.DB) ([]Violation, error) {
+	var violations []Violation
+	for _, c := range checks {
+		q := `SELECT ` + c.key + `::TEXT FROM ` + c.table + ` WHERE ` + c.where + ` ORDER BY 1`
+
+		var keys []string
+		if err := db.SelectContext(ctx, &keys, q); err != nil {
+			return nil, errors.Wrapf(err, "checking %s on %s", c.constraint, c.table)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		v := Violation{
+			Table:      c.table,
+			Constraint: c.constraint,
+			Rows:       len(keys),
+			Sample:     keys,
+			Backfilled: c.backfilled,
+		}
+		if len(v.Sample) > 10 {
+			v.Sample = v.Sample[:10]
+		}
+		violations = append(violations, v)
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,71 @@
+package user
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Memory implements UserStore in memory. It is safe for concurrent use and is
+// meant for tests that should not need a database.
+type Memory struct {
+	mu      sync.RWMutex
+	byEmail map[string]User
+}
+
+// NewMemory constructs an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		byEmail: make(map[string]User),
+	}
+}
+
+// Create inserts a new User.
+func (m *Memory) Create(ctx context.Context, nu NewUser, now time.Time) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.Wrap(err, "user creation; failed to hash the password")
+	}
+
+	u := User{
+		ID:        uuid.New().String(),
+		Name:      nu.Name,
+		Email:     nu.Email,
+		Roles:     nu.Roles,
+		Password:  hash,
+		CreatedAt: now.UTC(),
+		UpdatedAt: now.UTC(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.byEmail[u.Email]; ok {
+		return nil, ErrDuplicateEmail
+	}
+	m.byEmail[u.Email] = u
+
+	return &u, nil
+}
+
+// Authenticate verifies the credentials of a User and returns their Claims.
+func (m *Memory) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
+	m.mu.RLock()
+	u, ok := m.byEmail[email]
+	m.mu.RUnlock()
+
+	if !ok {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	if err := bcrypt.CompareHashAndPassword(u.Password, []byte(password)); err != nil {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	return auth.NewClaims(u.ID, u.Roles, now, time.Hour), nil
+}
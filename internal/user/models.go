@@ -13,6 +13,9 @@ type User struct {
 	Roles     pq.StringArray `db:"roles" json:"roles"`
 	CreatedAt time.Time      `db:"created_at" json:"created_at"`
 	UpdatedAt time.Time      `db:"updated_at" json:"updated_at"`
+
+	// DisabledAt is set while the User may not authenticate.
+	DisabledAt *time.Time `db:"disabled_at" json:"disabled_at,omitempty"`
 }
 
 type NewUser struct {
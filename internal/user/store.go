@@ -0,0 +1,41 @@
+package user
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"time"
+)
+
+// UserStore is the set of operations the API needs to manage Users.
+type UserStore interface {
+	Create(ctx context.Context, nu NewUser, now time.Time) (*User, error)
+	Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error)
+}
+
+// Postgres implements UserStore with the functions of this package. Users are
+// always read from the primary so a fresh password change is honored at once.
+// Every method joins the transaction carried by its context, if any.
+type Postgres struct {
+	db *database.Cluster
+}
+
+// NewPostgres constructs a Postgres store for the provided cluster.
+func NewPostgres(db *database.Cluster) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Create inserts a new User.
+func (s *Postgres) Create(ctx context.Context, nu NewUser, now time.Time) (*User, error) {
+	return Create(ctx, s.writer(ctx), nu, now)
+}
+
+// Authenticate verifies the credentials of a User and returns their Claims.
+func (s *Postgres) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
+	return Authenticate(ctx, s.writer(ctx), now, email, password)
+}
+
+// writer returns the handle for a call, joining a running transaction.
+func (s *Postgres) writer(ctx context.Context) database.Queryer {
+	return database.QueryerFrom(ctx, s.db.Primary())
+}
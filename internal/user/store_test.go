@@ -0,0 +1,74 @@
+package user_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"testing"
+	"time"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestMemoryStore runs the store conformance suite against the in-memory
+// implementation.
+func TestMemoryStore(t *testing.T) {
+	testStore(t, user.NewMemory())
+}
+
+// TestPostgresStore runs the store conformance suite against the Postgres
+// implementation.
+func TestPostgresStore(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+
+	testStore(t, user.NewPostgres(database.NewCluster(db)))
+}
+
+// testStore describes the behavior every UserStore must share. The store is
+// expected to start empty.
+func testStore(t *testing.T, users user.UserStore) {
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "Admin Gopher",
+		Email:           "admin@example.com",
+		Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+		Password:        "gophers",
+		ConfirmPassword: "gophers",
+	}
+
+	u, err := users.Create(ctx, nu, now)
+	if err != nil {
+		t.Fatalf("Creating user: %v", err)
+	}
+
+	if _, err := users.Create(ctx, nu, now); err != user.ErrDuplicateEmail {
+		t.Fatalf("Expected %v for a second user with the same email, got %v", user.ErrDuplicateEmail, err)
+	}
+
+	claims, err := users.Authenticate(ctx, now, nu.Email, nu.Password)
+	if err != nil {
+		t.Fatalf("Authenticating: %v", err)
+	}
+	if claims.Subject != u.ID {
+		t.Fatalf("Expected subject %q, got %q", u.ID, claims.Subject)
+	}
+	if !claims.HasRole(auth.RoleAdmin) {
+		t.Fatalf("Expected claims to carry the ADMIN role, got %v", claims.Roles)
+	}
+
+	if _, err := users.Authenticate(ctx, now, nu.Email, "wrong"); err != user.ErrAuthenticationFailure {
+		t.Fatalf("Expected %v for a wrong password, got %v", user.ErrAuthenticationFailure, err)
+	}
+	if _, err := users.Authenticate(ctx, now, "nobody@example.com", nu.Password); err != user.ErrAuthenticationFailure {
+		t.Fatalf("Expected %v for an unknown email, got %v", user.ErrAuthenticationFailure, err)
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"time"
 
@@ -16,10 +17,20 @@ var (
 	// ErrAuthenticationFailure occurs when a User attempts to authenticate
 	// but anything goes wrong.
 	ErrAuthenticationFailure = errors.New("Authentication failed")
+
+	// ErrDuplicateEmail occurs when a User is created with an email that
+	// already belongs to another User.
+	ErrDuplicateEmail = errors.New("Email already in use")
+
+	// ErrNotFound is used when a specific User is requested but does not exist.
+	ErrNotFound = errors.New("User not found")
+
+	// ErrInvalidUUID is used when an ID is not a valid UUID.
+	ErrInvalidUUID = errors.New("ID is not in its proper UUID format")
 )
 
 // Create inserts a new user into the database
-func Create(ctx context.Context, db *sqlx.DB, ns NewUser, now time.Time) (*User, error) {
+func Create(ctx context.Context, db database.Queryer, ns NewUser, now time.Time) (*User, error) {
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(ns.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -39,19 +50,27 @@ func Create(ctx context.Context, db *sqlx.DB, ns NewUser, now time.Time) (*User,
 	const q = `INSERT INTO users (user_id, name, email, roles, password, created_at, 
 updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING;`
 
-	if _, err := db.ExecContext(ctx, q, user.ID, user.Name, user.Email, user.Roles, user.Password, user.CreatedAt,
-		user.UpdatedAt); err != nil {
+	res, err := db.ExecContext(ctx, q, user.ID, user.Name, user.Email, user.Roles, user.Password, user.CreatedAt,
+		user.UpdatedAt)
+	if err != nil {
 		return nil, err
 	}
 
+	// ON CONFLICT DO NOTHING hides the unique violation so check that a row
+	// was actually written.
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrDuplicateEmail
+	}
+
 	return &user, nil
 }
 
 // Authenticate finds a User by their email and verifies their password. On
 // success, it returns a Claims value representing this User. The Claims can be
 // used to generate a token for future authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
-	const q = `SELECT user_id, name, email, password, roles, created_at, updated_at FROM users WHERE email = $1;`
+func Authenticate(ctx context.Context, db database.Queryer, now time.Time, email, password string) (auth.Claims, error) {
+	const q = `SELECT user_id, name, email, password, roles, created_at, updated_at, disabled_at FROM users
+WHERE email = $1;`
 	var u User
 	if err := db.GetContext(ctx, &u, q, email); err != nil {
 		// Normally we would return ErrNotFound in this scenario, but we do not want
@@ -63,6 +82,11 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, errors.Wrap(err, "selecting single user")
 	}
 
+	// Disabled users are turned away like unknown ones.
+	if u.DisabledAt != nil {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
 	// Compare the provided password with the saved one. Use the bcrypt
 	// comparison function, so it is cryptographically secure.
 	if err := bcrypt.CompareHashAndPassword(u.Password, []byte(password)); err != nil {
@@ -74,3 +98,104 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
 	return claims, nil
 }
+
+// List returns all the Users ordered by email.
+func List(ctx context.Context, db database.Queryer) ([]User, error) {
+	users := []User{}
+	const q = `SELECT user_id, name, email, password, roles, created_at, updated_at, disabled_at FROM users
+ORDER BY email;`
+	if err := db.SelectContext(ctx, &users, q); err != nil {
+		return nil, errors.Wrap(err, "selecting users")
+	}
+
+	return users, nil
+}
+
+// Retrieve finds a single User by ID.
+func Retrieve(ctx context.Context, db database.Queryer, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidUUID
+	}
+
+	var u User
+	const q = `SELECT user_id, name, email, password, roles, created_at, updated_at, disabled_at FROM users
+WHERE user_id = $1;`
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
+// RetrieveByEmail finds a single User by email.
+func RetrieveByEmail(ctx context.Context, db database.Queryer, email string) (*User, error) {
+	var u User
+	const q = `SELECT user_id, name, email, password, roles, created_at, updated_at, disabled_at FROM users
+WHERE email = $1;`
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", email)
+	}
+
+	return &u, nil
+}
+
+// SetRoles replaces the roles of a User.
+func SetRoles(ctx context.Context, db database.Queryer, id string, roles []string, now time.Time) error {
+	const q = `UPDATE users SET roles = $2, updated_at = $3 WHERE user_id = $1;`
+	return update(ctx, db, id, q, pq.StringArray(roles), now.UTC())
+}
+
+// SetDisabled disables or enables a User. Disabled Users can not
+// authenticate but tokens they were given stay valid until they expire.
+func SetDisabled(ctx context.Context, db database.Queryer, id string, disabled bool, now time.Time) error {
+	var disabledAt *time.Time
+	if disabled {
+		t := now.UTC()
+		disabledAt = &t
+	}
+
+	const q = `UPDATE users SET disabled_at = $2, updated_at = $3 WHERE user_id = $1;`
+	return update(ctx, db, id, q, disabledAt, now.UTC())
+}
+
+// ChangePassword replaces the password of a User.
+func ChangePassword(ctx context.Context, db database.Queryer, id, password string, now time.Time) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "hashing password")
+	}
+
+	const q = `UPDATE users SET password = $2, updated_at = $3 WHERE user_id = $1;`
+	return update(ctx, db, id, q, hash, now.UTC())
+}
+
+// Delete removes a User. Their Products and Sales are kept without them.
+func Delete(ctx context.Context, db database.Queryer, id string) error {
+	const q = `DELETE FROM users WHERE user_id = $1;`
+	return update(ctx, db, id, q)
+}
+
+// update runs a statement changing the User id and reports ErrNotFound when
+// there is no such User.
+func update(ctx context.Context, db database.Queryer, id, q string, args ...interface{}) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidUUID
+	}
+
+	res, err := db.ExecContext(ctx, q, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return errors.Wrapf(err, "updating user %q", id)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package user_test
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/auth"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/user"
+	"github.com/google/go-cmp/cmp"
+	"testing"
+	"time"
+)
+
+// TestAdministration exercises the functions behind the admin user commands.
+func TestAdministration(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+	ctx := context.Background()
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+
+	nu := user.NewUser{
+		Name:            "User Gopher",
+		Email:           "user@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "gophers",
+		ConfirmPassword: "gophers",
+	}
+	u, err := user.Create(ctx, db, nu, now)
+	if err != nil {
+		t.Fatalf("Creating user: %v", err)
+	}
+
+	if err := user.SetRoles(ctx, db, u.ID, []string{auth.RoleAdmin, auth.RoleUser}, now); err != nil {
+		t.Fatalf("Setting roles: %v", err)
+	}
+	got, err := user.RetrieveByEmail(ctx, db, nu.Email)
+	if err != nil {
+		t.Fatalf("Retrieving by email: %v", err)
+	}
+	if diff := cmp.Diff([]string{auth.RoleAdmin, auth.RoleUser}, []string(got.Roles)); diff != "" {
+		t.Fatalf("Roles were not replaced. Diff:\n%s", diff)
+	}
+
+	// Disabled users can not authenticate until they are enabled again.
+	if err := user.SetDisabled(ctx, db, u.ID, true, now); err != nil {
+		t.Fatalf("Disabling: %v", err)
+	}
+	if _, err := user.Authenticate(ctx, db, now, nu.Email, nu.Password); err != user.ErrAuthenticationFailure {
+		t.Fatalf("Expected %v for a disabled user, got %v", user.ErrAuthenticationFailure, err)
+	}
+	if err := user.SetDisabled(ctx, db, u.ID, false, now); err != nil {
+		t.Fatalf("Enabling: %v", err)
+	}
+
+	if err := user.ChangePassword(ctx, db, u.ID, "hunter2", now); err != nil {
+		t.Fatalf("Changing password: %v", err)
+	}
+	if _, err := user.Authenticate(ctx, db, now, nu.Email, "hunter2"); err != nil {
+		t.Fatalf("Authenticating with the new password: %v", err)
+	}
+
+	list, err := user.List(ctx, db)
+	if err != nil {
+		t.Fatalf("Listing: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != u.ID {
+		t.Fatalf("Expected only %s to be listed, got %+v", u.ID, list)
+	}
+
+	if err := user.Delete(ctx, db, u.ID); err != nil {
+		t.Fatalf("Deleting: %v", err)
+	}
+	if _, err := user.Retrieve(ctx, db, u.ID); err != user.ErrNotFound {
+		t.Fatalf("Expected %v after deleting, got %v", user.ErrNotFound, err)
+	}
+	if err := user.Delete(ctx, db, u.ID); err != user.ErrNotFound {
+		t.Fatalf("Expected %v deleting twice, got %v", user.ErrNotFound, err)
+	}
+}
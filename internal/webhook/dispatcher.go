@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dispatcher sends the due deliveries of a Store. Several may run against the
+// same database; each delivery is claimed by one of them at a time.
+type Dispatcher struct {
+	Store Store
+	Log   *log.Logger
+
+	// Client sends the deliveries. Its timeout bounds every attempt.
+	Client *http.Client
+
+	// A delivery failing MaxAttempts times in a row is marked failed. The
+	// attempt after the nth failure is made Backoff(n) later.
+	MaxAttempts int
+	Backoff     func(failures int) time.Duration
+
+	// Batch deliveries are claimed, and sent concurrently, at a time. A
+	// claim lasts Lease: a delivery whose attempt was not recorded by then,
+	// say because the process died, is sent again.
+	Batch int
+	Lease time.Duration
+}
+
+// NewDispatcher constructs a Dispatcher for store with defaults suited to
+// partners that are briefly down: eight attempts over about two hours.
+func NewDispatcher(store Store, log *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Log:         log,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 8,
+		Backoff:     jobs.ExponentialBackoff(time.Minute, time.Hour),
+		Batch:       20,
+		Lease:       time.Minute,
+	}
+}
+
+// DispatchDue claims the deliveries due at now, sends them and records the
+// outcome. It returns how many it claimed.
+func (d *Dispatcher) DispatchDue(ctx context.Context, now time.Time) (int, error) {
+	list, err := d.Store.Claim(ctx, now, d.Lease, d.Batch)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range list {
+		wg.Add(1)
+		go func(c Claimed) {
+			defer wg.Done()
+			if err := d.dispatch(ctx, c, now); err != nil {
+				d.Log.Printf("webhook: delivery %d: %v", c.ID, err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return len(list), nil
+}
+
+// dispatch makes one attempt at c and records it.
+func (d *Dispatcher) dispatch(ctx context.Context, c Claimed, now time.Time) error {
+	a := Attempt{
+		DeliveryID:  c.ID,
+		AttemptedAt: now,
+	}
+
+	start := time.Now()
+	code, err := d.send(ctx, c, now)
+	a.DurationMS = time.Since(start).Milliseconds()
+
+	if code != 0 {
+		a.StatusCode = &code
+	}
+	if err == nil && (code < 200 || code > 299) {
+		err = errors.Errorf("unexpected status %d", code)
+	}
+
+	status, next := StatusSucceeded, now
+	if err != nil {
+		msg := err.Error()
+		a.Error = &msg
+
+		status = StatusPending
+		next = now.Add(d.Backoff(c.Attempts + 1))
+		if c.Attempts+1 >= d.MaxAttempts {
+			status = StatusFailed
+		}
+	}
+
+	// The attempt is recorded even when ctx was canceled meanwhile.
+	return d.Store.Record(context.Background(), a, status, next)
+}
+
+// send posts the payload of c, signed, and returns the status code of the
+// response. The ID of the request that queued c goes along, so partners can
+// quote it.
+func (d *Dispatcher) send(ctx context.Context, c Claimed, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(c.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(c.Secret, now, c.Payload))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(c.ID, 10))
+	req.Header.Set(EventHeader, c.EventType)
+	if c.RequestID != "" {
+		req.Header.Set(web.RequestIDHeader, c.RequestID)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Draining a bit of the body lets the connection be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, nil
+}
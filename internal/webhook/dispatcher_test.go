@@ -0,0 +1,261 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database/databasetest"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain stops the local database server, if the tests started one.
+func TestMain(m *testing.M) {
+	databasetest.Main(m)
+}
+
+// TestMemoryDispatcher runs the delivery suite against the in-memory store.
+func TestMemoryDispatcher(t *testing.T) {
+	m := webhook.NewMemory()
+	testDispatcher(t, m, m.Enqueue)
+}
+
+// TestPostgresDispatcher runs the delivery suite against the Postgres store.
+func TestPostgresDispatcher(t *testing.T) {
+	t.Parallel()
+
+	db := databasetest.Setup(t)
+	testDispatcher(t, webhook.NewPostgres(database.NewCluster(db)), func(ctx context.Context, e events.Event, now time.Time) error {
+		return webhook.Enqueue(ctx, db, e, now)
+	})
+}
+
+// receiver is a partner endpoint answering with the queued status codes, then
+// 200 OK. It records the deliveries whose signature it verified.
+type receiver struct {
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	statuses []int
+	got      []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("Reading delivery: %v", err)
+	}
+	if err := webhook.Verify(rc.secret, r.Header.Get(webhook.SignatureHeader), body, time.Now(), time.Hour); err != nil {
+		rc.t.Errorf("Verifying delivery %s: %v", r.Header.Get(webhook.DeliveryHeader), err)
+	}
+
+	var e events.Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		rc.t.Errorf("Decoding delivery %q: %v", body, err)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.got = append(rc.got, r.Header)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) deliveries() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.got)
+}
+
+// testDispatcher describes how deliveries are queued, sent, retried and
+// logged. The store is expected to start empty.
+func testDispatcher(t *testing.T, store webhook.Store, enqueue func(context.Context, events.Event, time.Time) error) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	rc := receiver{t: t, secret: "0123456789abcdef", statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(&rc)
+	defer srv.Close()
+
+	sales, err := store.CreateEndpoint(ctx, webhook.NewEndpoint{
+		URL:        srv.URL,
+		EventTypes: []string{"sale.recorded"},
+		Secret:     rc.secret,
+	}, now)
+	if err != nil {
+		t.Fatalf("Creating endpoint: %v", err)
+	}
+	if _, err := store.CreateEndpoint(ctx, webhook.NewEndpoint{URL: srv.URL, EventTypes: []string{"product.deleted"}}, now); err != nil {
+		t.Fatalf("Creating endpoint: %v", err)
+	}
+
+	e := events.Event{ID: 7, Type: "sale.recorded", Subject: "a2b0639f-2cc6-44b8-b97b-15d69dbb511e",
+		Data: json.RawMessage(`{"quantity":2}`), CreatedAt: now}
+	// The event is queued while serving a request.
+	rctx := context.WithValue(ctx, web.KeyValues, &web.Values{RequestID: "9f3c2a7e"})
+	if err := enqueue(rctx, e, now); err != nil {
+		t.Fatalf("Enqueuing: %v", err)
+	}
+
+	d := webhook.NewDispatcher(store, log.New(ioutil.Discard, "", 0))
+	d.MaxAttempts = 2
+	d.Backoff = jobs.ExponentialBackoff(time.Minute, time.Hour)
+
+	dispatch := func(at time.Time, want int) {
+		t.Helper()
+		n, err := d.DispatchDue(ctx, at)
+		if err != nil {
+			t.Fatalf("Dispatching: %v", err)
+		}
+		if n != want {
+			t.Fatalf("Expected %d deliveries dispatched, got %d", want, n)
+		}
+	}
+
+	var id int64
+	t.Run("Retry", func(t *testing.T) {
+		dispatch(now, 1)
+
+		list, err := store.ListDeliveries(ctx, webhook.DeliveryFilter{EndpointID: sales.ID})
+		if err != nil {
+			t.Fatalf("Listing deliveries: %v", err)
+		}
+		if len(list) != 1 || list[0].Status != webhook.StatusPending || list[0].Attempts != 1 {
+			t.Fatalf("Expected one pending delivery after a failure, got %+v", list)
+		}
+		id = list[0].ID
+		if list[0].RequestID != "9f3c2a7e" {
+			t.Fatalf("Expected the delivery to keep the ID of its request, got %q", list[0].RequestID)
+		}
+		if !list[0].NextAttemptAt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("Expected the next attempt a minute later, got %v", list[0].NextAttemptAt)
+		}
+
+		// Nothing is due before the backoff passed.
+		dispatch(now.Add(30*time.Second), 0)
+		dispatch(now.Add(time.Minute), 1)
+
+		got, err := store.RetrieveDelivery(ctx, id)
+		if err != nil {
+			t.Fatalf("Retrieving delivery: %v", err)
+		}
+		if got.Status != webhook.StatusSucceeded || got.DeliveredAt == nil || len(got.AttemptLog) != 2 {
+			t.Fatalf("Expected a succeeded delivery with two attempts, got %+v", got)
+		}
+		if code := got.AttemptLog[0].StatusCode; code == nil || *code != http.StatusInternalServerError {
+			t.Fatalf("Expected the first attempt to log a 500, got %+v", got.AttemptLog[0])
+		}
+		if rc.deliveries() != 2 {
+			t.Fatalf("Expected the receiver to get 2 deliveries, got %d", rc.deliveries())
+		}
+
+		h := rc.got[1]
+		if h.Get(webhook.EventHeader) != "sale.recorded" || h.Get(webhook.DeliveryHeader) != strconv.FormatInt(id, 10) ||
+			h.Get(web.RequestIDHeader) != "9f3c2a7e" {
+			t.Fatalf("Unexpected delivery headers %v", h)
+		}
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		rc.mu.Lock()
+		rc.statuses = []int{http.StatusBadGateway, http.StatusBadGateway}
+		rc.mu.Unlock()
+
+		if err := store.Redeliver(ctx, id, now.Add(2*time.Minute)); err != nil {
+			t.Fatalf("Redelivering: %v", err)
+		}
+		dispatch(now.Add(2*time.Minute), 1)
+		dispatch(now.Add(3*time.Minute), 1)
+
+		list, err := store.ListDeliveries(ctx, webhook.DeliveryFilter{Status: webhook.StatusFailed})
+		if err != nil {
+			t.Fatalf("Listing deliveries: %v", err)
+		}
+		if len(list) != 1 || list[0].ID != id || list[0].LastStatusCode == nil || *list[0].LastStatusCode != http.StatusBadGateway {
+			t.Fatalf("Expected the delivery to fail after MaxAttempts, got %+v", list)
+		}
+
+		// Failed deliveries are only sent again when redelivered.
+		dispatch(now.Add(24*time.Hour), 0)
+	})
+
+	t.Run("Redeliver", func(t *testing.T) {
+		if err := store.Redeliver(ctx, id, now.Add(4*time.Minute)); err != nil {
+			t.Fatalf("Redelivering: %v", err)
+		}
+		dispatch(now.Add(4*time.Minute), 1)
+
+		got, err := store.RetrieveDelivery(ctx, id)
+		if err != nil {
+			t.Fatalf("Retrieving delivery: %v", err)
+		}
+		if got.Status != webhook.StatusSucceeded || len(got.AttemptLog) != 5 {
+			t.Fatalf("Expected a succeeded delivery with five attempts, got %+v", got)
+		}
+
+		if err := store.Redeliver(ctx, 999, now); err != webhook.ErrNotFound {
+			t.Fatalf("Expected %v redelivering a missing delivery, got %v", webhook.ErrNotFound, err)
+		}
+	})
+
+	t.Run("DeleteEndpoint", func(t *testing.T) {
+		if err := store.DeleteEndpoint(ctx, sales.ID); err != nil {
+			t.Fatalf("Deleting endpoint: %v", err)
+		}
+		if _, err := store.RetrieveDelivery(ctx, id); err != webhook.ErrNotFound {
+			t.Fatalf("Expected deliveries to go with their endpoint, got %v", err)
+		}
+
+		list, err := store.ListEndpoints(ctx)
+		if err != nil {
+			t.Fatalf("Listing endpoints: %v", err)
+		}
+		if len(list) != 1 || list[0].Secret != "" {
+			t.Fatalf("Expected one endpoint without its secret, got %+v", list)
+		}
+	})
+}
+
+// TestVerify checks that receivers reject what was not signed with their
+// secret, or was signed long ago.
+func TestVerify(t *testing.T) {
+	now := time.Date(2021, time.December, 5, 0, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":1}`)
+	header := webhook.Sign("secret", now, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		body   []byte
+		at     time.Time
+		ok     bool
+	}{
+		{"Valid", "secret", body, now.Add(time.Minute), true},
+		{"WrongSecret", "other", body, now, false},
+		{"Tampered", "secret", []byte(`{"id":2}`), now, false},
+		{"Replayed", "secret", body, now.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(tt.secret, header, tt.body, tt.at, 5*time.Minute)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Expected valid %v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package webhook
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"time"
+)
+
+// JobDispatch is the kind of the job sending the due deliveries.
+const JobDispatch = "webhook.dispatch"
+
+// RegisterJobs registers the jobs of the package: every interval d sends the
+// due deliveries, claiming batch after batch while they come back full.
+func RegisterJobs(r *jobs.Registry, d *Dispatcher, interval time.Duration) error {
+	return r.Register(jobs.Definition{
+		Kind:     JobDispatch,
+		Schedule: "@every " + interval.String(),
+		Timeout:  d.Lease,
+		Handler: func(ctx context.Context, j jobs.Job) error {
+			for ctx.Err() == nil {
+				n, err := d.DispatchDue(ctx, time.Now())
+				if err != nil {
+					return err
+				}
+				if n < d.Batch {
+					return nil
+				}
+			}
+			return nil
+		},
+	})
+}
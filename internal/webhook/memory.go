@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements Store in memory. It is safe for concurrent use and is
+// meant for tests that should not need a database. Nothing enqueues
+// deliveries on its own; tests call Enqueue.
+type Memory struct {
+	mu         sync.Mutex
+	endpoints  map[string]Endpoint
+	deliveries []Delivery // IDs are positions starting at 1
+	attempts   []Attempt
+}
+
+// NewMemory constructs an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		endpoints: make(map[string]Endpoint),
+	}
+}
+
+// Enqueue queues a delivery of e for every Endpoint subscribed to its type.
+// The deliveries keep the ID of the request served with ctx, if any.
+func (m *Memory) Enqueue(ctx context.Context, e events.Event, now time.Time) error {
+	payload, err := payloadOf(e)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ep := range m.sortedEndpoints() {
+		for _, typ := range ep.EventTypes {
+			if typ != e.Type {
+				continue
+			}
+			m.deliveries = append(m.deliveries, Delivery{
+				ID:            int64(len(m.deliveries) + 1),
+				EndpointID:    ep.ID,
+				EventID:       e.ID,
+				EventType:     e.Type,
+				Payload:       payload,
+				Status:        StatusPending,
+				NextAttemptAt: now,
+				RequestID:     web.RequestID(ctx),
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+			break
+		}
+	}
+	return nil
+}
+
+// CreateEndpoint registers a new Endpoint.
+func (m *Memory) CreateEndpoint(ctx context.Context, ne NewEndpoint, now time.Time) (*Endpoint, error) {
+	e, err := newEndpoint(ne, now)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.endpoints[e.ID] = *e
+	m.mu.Unlock()
+
+	return e, nil
+}
+
+// ListEndpoints returns every Endpoint without its secret.
+func (m *Memory) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.sortedEndpoints()
+	for i := range list {
+		list[i].Secret = ""
+	}
+	return list, nil
+}
+
+// DeleteEndpoint removes an Endpoint and its deliveries.
+func (m *Memory) DeleteEndpoint(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidUUID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.endpoints[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.endpoints, id)
+
+	// Deliveries keep their place so IDs stay positions.
+	for i := range m.deliveries {
+		if m.deliveries[i].EndpointID == id {
+			m.deliveries[i].EndpointID = ""
+		}
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery log, newest first.
+func (m *Memory) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error) {
+	if f.EndpointID != "" {
+		if _, err := uuid.Parse(f.EndpointID); err != nil {
+			return nil, ErrInvalidUUID
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := []Delivery{}
+	for i := len(m.deliveries) - 1; i >= 0 && len(list) < database.PageSize(f.Limit); i-- {
+		d := m.deliveries[i]
+		switch {
+		case d.EndpointID == "":
+		case f.EndpointID != "" && d.EndpointID != f.EndpointID:
+		case f.Status != "" && d.Status != f.Status:
+		default:
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+// RetrieveDelivery returns a single Delivery with its attempt log.
+func (m *Memory) RetrieveDelivery(ctx context.Context, id int64) (*Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.delivery(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	cp := *d
+	for _, a := range m.attempts {
+		if a.DeliveryID == id {
+			cp.AttemptLog = append(cp.AttemptLog, a)
+		}
+	}
+	return &cp, nil
+}
+
+// Redeliver queues a Delivery again with a fresh set of attempts.
+func (m *Memory) Redeliver(ctx context.Context, id int64, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.delivery(id)
+	if !ok {
+		return ErrNotFound
+	}
+	d.Status = StatusPending
+	d.Attempts = 0
+	d.NextAttemptAt = now
+	d.UpdatedAt = now
+	return nil
+}
+
+// Claim takes up to limit due deliveries until lease passed.
+func (m *Memory) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Claimed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*Delivery
+	for i := range m.deliveries {
+		d := &m.deliveries[i]
+		if d.EndpointID != "" && d.Status == StatusPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	list := make([]Claimed, 0, len(due))
+	for _, d := range due {
+		d.NextAttemptAt = now.Add(lease)
+		d.UpdatedAt = now
+		ep := m.endpoints[d.EndpointID]
+		list = append(list, Claimed{Delivery: *d, URL: ep.URL, Secret: ep.Secret})
+	}
+	return list, nil
+}
+
+// Record logs an attempt and moves its Delivery to status.
+func (m *Memory) Record(ctx context.Context, a Attempt, status string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.delivery(a.DeliveryID)
+	if !ok {
+		return ErrNotFound
+	}
+
+	a.ID = int64(len(m.attempts) + 1)
+	m.attempts = append(m.attempts, a)
+
+	d.Status = status
+	d.Attempts++
+	d.NextAttemptAt = next
+	d.LastStatusCode = a.StatusCode
+	d.LastError = a.Error
+	d.UpdatedAt = a.AttemptedAt
+	if status == StatusSucceeded {
+		at := a.AttemptedAt
+		d.DeliveredAt = &at
+	}
+	return nil
+}
+
+// delivery returns the Delivery id unless its Endpoint was deleted. The
+// caller must hold the lock.
+func (m *Memory) delivery(id int64) (*Delivery, bool) {
+	if id < 1 || id > int64(len(m.deliveries)) {
+		return nil, false
+	}
+	d := &m.deliveries[id-1]
+	if d.EndpointID == "" {
+		return nil, false
+	}
+	return d, true
+}
+
+// sortedEndpoints returns a copy of the Endpoints in the order they were
+// created. The caller must hold the lock.
+func (m *Memory) sortedEndpoints() []Endpoint {
+	list := make([]Endpoint, 0, len(m.endpoints))
+	for _, e := range m.endpoints {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/lib/pq"
+	"time"
+)
+
+// The statuses of a Delivery. Pending deliveries are attempted once their
+// next attempt is due; failed ones ran out of attempts and are only tried
+// again when redelivered.
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Endpoint is a URL notified of the events of the listed types.
+type Endpoint struct {
+	ID         string         `db:"endpoint_id" json:"id"`
+	URL        string         `db:"url" json:"url"`
+	EventTypes pq.StringArray `db:"event_types" json:"event_types"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at" json:"updated_at"`
+
+	// Secret signs the deliveries. It is only returned when the Endpoint is
+	// created.
+	Secret string `db:"secret" json:"secret,omitempty"`
+}
+
+// NewEndpoint is what we require from admins registering an Endpoint. A
+// secret is generated when none is given.
+type NewEndpoint struct {
+	URL        string   `json:"url" validate:"required,url"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,required"`
+	Secret     string   `json:"secret" validate:"omitempty,min=16"`
+}
+
+// Delivery is an event to be sent, or sent, to an Endpoint.
+type Delivery struct {
+	ID             int64           `db:"delivery_id" json:"id"`
+	EndpointID     string          `db:"endpoint_id" json:"endpoint_id"`
+	EventID        int64           `db:"event_id" json:"event_id"`
+	EventType      string          `db:"event_type" json:"event_type"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	LastStatusCode *int            `db:"last_status_code" json:"last_status_code,omitempty"`
+	LastError      *string         `db:"last_error" json:"last_error,omitempty"`
+	RequestID      string          `db:"request_id" json:"request_id,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at" json:"updated_at"`
+	DeliveredAt    *time.Time      `db:"delivered_at" json:"delivered_at,omitempty"`
+
+	// AttemptLog is only filled in when a single Delivery is retrieved.
+	AttemptLog []Attempt `db:"-" json:"attempt_log,omitempty"`
+}
+
+// Attempt is one try at sending a Delivery. StatusCode is nil when no
+// response came back.
+type Attempt struct {
+	ID          int64     `db:"attempt_id" json:"id"`
+	DeliveryID  int64     `db:"delivery_id" json:"delivery_id"`
+	StatusCode  *int      `db:"status_code" json:"status_code,omitempty"`
+	Error       *string   `db:"error" json:"error,omitempty"`
+	DurationMS  int64     `db:"duration_ms" json:"duration_ms"`
+	AttemptedAt time.Time `db:"attempted_at" json:"attempted_at"`
+}
+
+// DeliveryFilter narrows the delivery log. Empty fields match everything.
+type DeliveryFilter struct {
+	EndpointID string
+	Status     string
+	Limit      int
+}
+
+// Claimed is a Delivery taken by a Dispatcher, with what it needs to send it.
+type Claimed struct {
+	Delivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers sent with every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// ErrInvalidSignature is returned by Verify for a body that was not signed
+// with the secret, or was signed too long ago.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature header of body sent at t: the time as Unix
+// seconds and the hex HMAC-SHA256 of "<time>.<body>" keyed with secret, as in
+// "t=1638662400,v1=5257a869...". Signing the time keeps a captured delivery
+// from being replayed later.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac(secret, ts, body)))
+}
+
+// Verify checks a signature header made by Sign, as receivers should. Bodies
+// signed more than tolerance before or after now are rejected.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			if sig, err := hex.DecodeString(kv[1]); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	want := mac(secret, ts, body)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, want) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// mac is the HMAC-SHA256 of the signed content.
+func mac(secret, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
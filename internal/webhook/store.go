@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"context"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"time"
+)
+
+// Store is the set of operations the API and the Dispatcher need to manage
+// Endpoints and their deliveries.
+type Store interface {
+	CreateEndpoint(ctx context.Context, ne NewEndpoint, now time.Time) (*Endpoint, error)
+	ListEndpoints(ctx context.Context) ([]Endpoint, error)
+	DeleteEndpoint(ctx context.Context, id string) error
+
+	ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error)
+	RetrieveDelivery(ctx context.Context, id int64) (*Delivery, error)
+	Redeliver(ctx context.Context, id int64, now time.Time) error
+
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Claimed, error)
+	Record(ctx context.Context, a Attempt, status string, next time.Time) error
+}
+
+// Postgres implements Store with the functions of this package. Everything is
+// read from the primary: the log must show the attempt that was just made.
+type Postgres struct {
+	db *database.Cluster
+}
+
+// NewPostgres constructs a Postgres store for the provided cluster.
+func NewPostgres(db *database.Cluster) *Postgres {
+	return &Postgres{db: db}
+}
+
+// CreateEndpoint registers a new Endpoint.
+func (s *Postgres) CreateEndpoint(ctx context.Context, ne NewEndpoint, now time.Time) (*Endpoint, error) {
+	return CreateEndpoint(ctx, s.db.Primary(), ne, now)
+}
+
+// ListEndpoints returns every Endpoint.
+func (s *Postgres) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	return ListEndpoints(ctx, s.db.Primary())
+}
+
+// DeleteEndpoint removes an Endpoint and its deliveries.
+func (s *Postgres) DeleteEndpoint(ctx context.Context, id string) error {
+	return DeleteEndpoint(ctx, s.db.Primary(), id)
+}
+
+// ListDeliveries returns the delivery log.
+func (s *Postgres) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error) {
+	return ListDeliveries(ctx, s.db.Primary(), f)
+}
+
+// RetrieveDelivery returns a single Delivery with its attempts.
+func (s *Postgres) RetrieveDelivery(ctx context.Context, id int64) (*Delivery, error) {
+	return RetrieveDelivery(ctx, s.db.Primary(), id)
+}
+
+// Redeliver queues a Delivery again.
+func (s *Postgres) Redeliver(ctx context.Context, id int64, now time.Time) error {
+	return Redeliver(ctx, s.db.Primary(), id, now)
+}
+
+// Claim takes the due deliveries.
+func (s *Postgres) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Claimed, error) {
+	return Claim(ctx, s.db.Primary(), now, lease, limit)
+}
+
+// Record logs an attempt and updates its Delivery in a single transaction.
+func (s *Postgres) Record(ctx context.Context, a Attempt, status string, next time.Time) error {
+	return s.db.WithTx(ctx, func(ctx context.Context, tx database.Queryer) error {
+		return Record(ctx, tx, a, status, next)
+	})
+}
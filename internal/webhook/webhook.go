@@ -0,0 +1,231 @@
+// Package webhook notifies the endpoints registered by admins of the events
+// they subscribed to. Deliveries are queued by Enqueue in the transaction of
+// the change the event describes, so the queue is a transactional outbox: a
+// delivery exists if and only if its change was committed. A Dispatcher
+// then sends them, signed, and retries failures with exponential backoff.
+// Every attempt is kept in the delivery log.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/web"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is used when a specific Endpoint or Delivery is requested
+	// but does not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidUUID is used when an ID is not a valid UUID.
+	ErrInvalidUUID = errors.New("ID is not in its proper UUID format")
+)
+
+// CreateEndpoint registers a new Endpoint. The Endpoint returned carries its
+// secret.
+func CreateEndpoint(ctx context.Context, db database.Queryer, ne NewEndpoint, now time.Time) (*Endpoint, error) {
+	e, err := newEndpoint(ne, now)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO webhook_endpoints (endpoint_id, url, secret, event_types, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6);`
+
+	if _, err := db.ExecContext(ctx, q, e.ID, e.URL, e.Secret, e.EventTypes, e.CreatedAt, e.UpdatedAt); err != nil {
+		return nil, errors.Wrap(err, "inserting endpoint")
+	}
+
+	return e, nil
+}
+
+// ListEndpoints returns every Endpoint without its secret.
+func ListEndpoints(ctx context.Context, db database.Queryer) ([]Endpoint, error) {
+	const q = `SELECT endpoint_id, url, event_types, created_at, updated_at FROM webhook_endpoints
+ORDER BY created_at, endpoint_id;`
+
+	list := []Endpoint{}
+	if err := db.SelectContext(ctx, &list, q); err != nil {
+		return nil, errors.Wrap(err, "selecting endpoints")
+	}
+	return list, nil
+}
+
+// DeleteEndpoint removes an Endpoint and its deliveries.
+func DeleteEndpoint(ctx context.Context, db database.Queryer, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidUUID
+	}
+
+	const q = `DELETE FROM webhook_endpoints WHERE endpoint_id = $1;`
+	res, err := db.ExecContext(ctx, q, id)
+	if err != nil {
+		return errors.Wrapf(err, "deleting endpoint %q", id)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Enqueue queues a delivery of e for every Endpoint subscribed to its type.
+// It must run in the transaction recording e so the deliveries are committed,
+// or rolled back, with it. The deliveries keep the ID of the request served
+// with ctx, if any.
+func Enqueue(ctx context.Context, db database.Queryer, e events.Event, now time.Time) error {
+	payload, err := payloadOf(e)
+	if err != nil {
+		return err
+	}
+
+	const q = `INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload, status, next_attempt_at,
+request_id, created_at, updated_at)
+SELECT endpoint_id, $1::BIGINT, $2::TEXT, $3::JSONB, $4::TEXT, $5::TIMESTAMP, $6::TEXT, $5, $5 FROM webhook_endpoints
+WHERE $2 = ANY(event_types);`
+
+	if _, err := db.ExecContext(ctx, q, e.ID, e.Type, []byte(payload), StatusPending, now,
+		web.RequestID(ctx)); err != nil {
+		return errors.Wrap(err, "queuing deliveries")
+	}
+	return nil
+}
+
+// deliveryColumns are the columns of a Delivery, prefixed for queries joining
+// the endpoints.
+const deliveryColumns = `d.delivery_id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+d.next_attempt_at, d.last_status_code, d.last_error, d.request_id, d.created_at, d.updated_at, d.delivered_at`
+
+// ListDeliveries returns the delivery log, newest first.
+func ListDeliveries(ctx context.Context, db database.Queryer, f DeliveryFilter) ([]Delivery, error) {
+	if f.EndpointID != "" {
+		if _, err := uuid.Parse(f.EndpointID); err != nil {
+			return nil, ErrInvalidUUID
+		}
+	}
+
+	q := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries AS d
+WHERE ($1 = '' OR d.endpoint_id::TEXT = $1) AND ($2 = '' OR d.status = $2)
+ORDER BY d.delivery_id DESC LIMIT $3;`
+
+	list := []Delivery{}
+	if err := db.SelectContext(ctx, &list, q, f.EndpointID, f.Status, database.PageSize(f.Limit)); err != nil {
+		return nil, errors.Wrap(err, "selecting deliveries")
+	}
+	return list, nil
+}
+
+// RetrieveDelivery returns a single Delivery with its attempt log.
+func RetrieveDelivery(ctx context.Context, db database.Queryer, id int64) (*Delivery, error) {
+	q := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries AS d WHERE d.delivery_id = $1;`
+
+	var d Delivery
+	if err := db.GetContext(ctx, &d, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting delivery %d", id)
+	}
+
+	const qa = `SELECT attempt_id, delivery_id, status_code, error, duration_ms, attempted_at FROM webhook_attempts
+WHERE delivery_id = $1 ORDER BY attempt_id;`
+	if err := db.SelectContext(ctx, &d.AttemptLog, qa, id); err != nil {
+		return nil, errors.Wrapf(err, "selecting attempts of delivery %d", id)
+	}
+
+	return &d, nil
+}
+
+// Redeliver queues a Delivery again, whatever its status, with a fresh set
+// of attempts. Earlier attempts stay in the log.
+func Redeliver(ctx context.Context, db database.Queryer, id int64, now time.Time) error {
+	const q = `UPDATE webhook_deliveries SET status = $2, attempts = 0, next_attempt_at = $3, updated_at = $3
+WHERE delivery_id = $1;`
+
+	res, err := db.ExecContext(ctx, q, id, StatusPending, now)
+	if err != nil {
+		return errors.Wrapf(err, "redelivering %d", id)
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Claim takes up to limit due deliveries. They are not due again until lease
+// passed, so a Dispatcher that died while sending them does not lose them,
+// and concurrent Dispatchers skip the rows the others are claiming.
+func Claim(ctx context.Context, db database.Queryer, now time.Time, lease time.Duration, limit int) ([]Claimed, error) {
+	q := `UPDATE webhook_deliveries AS d SET next_attempt_at = $2, updated_at = $1
+FROM webhook_endpoints AS e
+WHERE e.endpoint_id = d.endpoint_id AND d.delivery_id IN (
+	SELECT delivery_id FROM webhook_deliveries WHERE status = $3 AND next_attempt_at <= $1
+	ORDER BY next_attempt_at LIMIT $4 FOR UPDATE SKIP LOCKED
+)
+RETURNING ` + deliveryColumns + `, e.url, e.secret;`
+
+	var list []Claimed
+	if err := db.SelectContext(ctx, &list, q, now, now.Add(lease), StatusPending, limit); err != nil {
+		return nil, errors.Wrap(err, "claiming deliveries")
+	}
+	return list, nil
+}
+
+// Record logs an attempt and moves its Delivery to status. A pending Delivery
+// is attempted again at next.
+func Record(ctx context.Context, db database.Queryer, a Attempt, status string, next time.Time) error {
+	const qa = `INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms, attempted_at)
+VALUES ($1, $2, $3, $4, $5);`
+	if _, err := db.ExecContext(ctx, qa, a.DeliveryID, a.StatusCode, a.Error, a.DurationMS, a.AttemptedAt); err != nil {
+		return errors.Wrapf(err, "logging attempt of delivery %d", a.DeliveryID)
+	}
+
+	const q = `UPDATE webhook_deliveries SET status = $2, attempts = attempts + 1, next_attempt_at = $3,
+last_status_code = $4, last_error = $5, updated_at = $6,
+delivered_at = CASE WHEN $2 = 'succeeded' THEN $6 ELSE delivered_at END
+WHERE delivery_id = $1;`
+	if _, err := db.ExecContext(ctx, q, a.DeliveryID, status, next, a.StatusCode, a.Error, a.AttemptedAt); err != nil {
+		return errors.Wrapf(err, "updating delivery %d", a.DeliveryID)
+	}
+	return nil
+}
+
+// newEndpoint builds an Endpoint, generating its secret when none is given.
+func newEndpoint(ne NewEndpoint, now time.Time) (*Endpoint, error) {
+	secret := ne.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.Wrap(err, "generating secret")
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	return &Endpoint{
+		ID:         uuid.New().String(),
+		URL:        ne.URL,
+		EventTypes: pq.StringArray(ne.EventTypes),
+		Secret:     secret,
+		CreatedAt:  now.UTC(),
+		UpdatedAt:  now.UTC(),
+	}, nil
+}
+
+// payloadOf is the body sent for e: the event as the event streams show it.
+func payloadOf(e events.Event) ([]byte, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling payload")
+	}
+	return b, nil
+}
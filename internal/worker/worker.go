@@ -0,0 +1,45 @@
+// Package worker assembles the background jobs of the service so the API and
+// the worker command run the same ones.
+//
+// There is no token cleanup job: tokens are stateless JWTs and nothing about
+// them is stored. Nor is there a report pre-aggregation job, as the service
+// has no reports yet; both belong here once there is something to act on.
+package worker
+
+import (
+	"github.com/esmaeilmirzaee/grage/internal/platform/config"
+	"github.com/esmaeilmirzaee/grage/internal/platform/database"
+	"github.com/esmaeilmirzaee/grage/internal/platform/events"
+	"github.com/esmaeilmirzaee/grage/internal/platform/jobs"
+	"github.com/esmaeilmirzaee/grage/internal/product"
+	"github.com/esmaeilmirzaee/grage/internal/webhook"
+	"log"
+)
+
+// NewRunner constructs a Runner of every job of the service.
+func NewRunner(cfg *config.Config, db *database.Cluster, log *log.Logger) (*jobs.Runner, error) {
+	store := jobs.NewPostgres(db)
+	r := jobs.NewRegistry()
+
+	dispatcher := webhook.NewDispatcher(webhook.NewPostgres(db), log)
+	dispatcher.Client.Timeout = cfg.Webhook.Timeout
+	dispatcher.MaxAttempts = cfg.Webhook.MaxAttempts
+
+	if err := webhook.RegisterJobs(r, dispatcher, cfg.Webhook.Interval); err != nil {
+		return nil, err
+	}
+	if err := product.RegisterJobs(r, db, cfg.Jobs.LowStockThreshold); err != nil {
+		return nil, err
+	}
+	if err := events.RegisterJobs(r, db, cfg.Jobs.EventRetention); err != nil {
+		return nil, err
+	}
+	if err := jobs.RegisterPrune(r, store, cfg.Jobs.JobRetention); err != nil {
+		return nil, err
+	}
+
+	runner := jobs.NewRunner(store, r, log)
+	runner.Concurrency = cfg.Jobs.Concurrency
+	runner.PollInterval = cfg.Jobs.PollInterval
+	return runner, nil
+}